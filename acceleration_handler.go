@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// accelerationRange 是解析后的加速度查询范围
+type accelerationRange struct {
+	devEUI   string
+	from, to time.Time
+	interval time.Duration // 0 表示返回原始采样
+}
+
+// bindAccelerationQuery 解析桩号与查询参数，失败时直接写回错误响应
+func (h *Handler) bindAccelerationQuery(c *gin.Context) (accelerationRange, bool) {
+	devEUI, err := h.resolveDevEUI(c.Param("stakeNo"))
+	if err != nil {
+		respondError(c, err, "Failed to resolve stakeNo.")
+		return accelerationRange{}, false
+	}
+
+	var q AccelerationQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondValidationError(c, "Invalid query: "+err.Error())
+		return accelerationRange{}, false
+	}
+	r := accelerationRange{devEUI: devEUI, from: q.From, to: q.To}
+	if r.to.IsZero() {
+		r.to = time.Now()
+	}
+	if r.from.IsZero() {
+		r.from = r.to.Add(-24 * time.Hour)
+	}
+	if !r.from.Before(r.to) {
+		respondValidationError(c, "from must be earlier than to.")
+		return accelerationRange{}, false
+	}
+	if q.Interval != "" {
+		if r.interval, err = time.ParseDuration(q.Interval); err != nil || r.interval <= 0 {
+			respondValidationError(c, "Invalid interval: "+q.Interval)
+			return accelerationRange{}, false
+		}
+	}
+	return r, true
+}
+
+// handleGetAcceleration 查询设备在时间范围内的加速度采样。指定 interval 时按间隔降采样；
+// 未指定且原始采样数超过 acceleration_max_points 时，自动选择间隔使桶数不超过上限
+func (h *Handler) handleGetAcceleration(c *gin.Context) {
+	r, ok := h.bindAccelerationQuery(c)
+	if !ok {
+		return
+	}
+
+	data := gin.H{"stakeNo": h.stakeNoOf(r.devEUI), "devEUI": r.devEUI, "from": r.from, "to": r.to}
+	if r.interval == 0 {
+		samples, err := h.acceleration.Samples(r.devEUI, r.from, r.to, h.config.AccelerationMaxPoints)
+		if err == nil {
+			data["samples"] = samples
+			c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": data})
+			return
+		}
+		if !errors.Is(err, services.ErrTooManySamples) {
+			log.Error().Err(err).Str("devEUI", r.devEUI).Msg("查询加速度采样失败")
+			respondError(c, err, "Failed to query acceleration samples.")
+			return
+		}
+		r.interval = autoInterval(r.from, r.to, h.config.AccelerationMaxPoints)
+	}
+
+	buckets, err := h.acceleration.Downsample(r.devEUI, r.from, r.to, r.interval)
+	if err != nil {
+		log.Error().Err(err).Str("devEUI", r.devEUI).Msg("加速度降采样失败")
+		respondError(c, err, "Failed to query acceleration samples.")
+		return
+	}
+	data["interval"] = r.interval.String()
+	data["buckets"] = buckets
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": data})
+}
+
+// autoInterval 选择使 [from, to) 分桶数不超过 maxPoints 的最小整秒间隔
+func autoInterval(from, to time.Time, maxPoints int) time.Duration {
+	if maxPoints <= 0 {
+		maxPoints = 1
+	}
+	interval := (to.Sub(from) + time.Duration(maxPoints) - 1) / time.Duration(maxPoints)
+	if rounded := interval.Truncate(time.Second); rounded < interval {
+		interval = rounded + time.Second
+	}
+	return interval
+}
+
+// handleExportAcceleration 以 CSV 导出设备在时间范围内的加速度采样，指定 interval 时导出降采样结果
+func (h *Handler) handleExportAcceleration(c *gin.Context) {
+	r, ok := h.bindAccelerationQuery(c)
+	if !ok {
+		return
+	}
+
+	var records [][]string
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	if r.interval == 0 {
+		samples, err := h.acceleration.Samples(r.devEUI, r.from, r.to, 0)
+		if err != nil {
+			log.Error().Err(err).Str("devEUI", r.devEUI).Msg("导出加速度采样失败")
+			respondError(c, err, "Failed to export acceleration samples.")
+			return
+		}
+		records = append(records, []string{"time", "x", "y", "z", "magnitude"})
+		for _, s := range samples {
+			records = append(records, []string{s.At.Format(time.RFC3339Nano), f(s.X), f(s.Y), f(s.Z), f(s.Magnitude())})
+		}
+	} else {
+		buckets, err := h.acceleration.Downsample(r.devEUI, r.from, r.to, r.interval)
+		if err != nil {
+			log.Error().Err(err).Str("devEUI", r.devEUI).Msg("导出加速度采样失败")
+			respondError(c, err, "Failed to export acceleration samples.")
+			return
+		}
+		records = append(records, []string{"start", "count", "xMin", "xMax", "xMean", "yMin", "yMax", "yMean", "zMin", "zMax", "zMean", "peakMagnitude"})
+		for _, b := range buckets {
+			records = append(records, []string{
+				b.Start.Format(time.RFC3339), strconv.Itoa(b.Count),
+				f(b.X.Min), f(b.X.Max), f(b.X.Mean),
+				f(b.Y.Min), f(b.Y.Max), f(b.Y.Mean),
+				f(b.Z.Min), f(b.Z.Max), f(b.Z.Mean),
+				f(b.PeakMagnitude),
+			})
+		}
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="acceleration-`+h.stakeNoOf(r.devEUI)+`.csv"`)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := csv.NewWriter(c.Writer).WriteAll(records); err != nil {
+		log.Error().Err(err).Str("devEUI", r.devEUI).Msg("写入加速度 CSV 失败")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleExportAcceleration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	registry, err := services.NewDeviceRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Create(services.Device{StakeNo: "K1", DevEUI: "0000000000000001"}); err != nil {
+		t.Fatal(err)
+	}
+	acceleration, err := services.NewAccelerationStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { acceleration.Close() })
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, s := range []services.AccelerationSample{
+		{X: 0.6, Y: 0, Z: 0.8, At: start},
+		{X: 0, Y: 0, Z: 1, At: start.Add(30 * time.Second)},
+		{X: 0, Y: 0, Z: 2, At: start.Add(time.Minute)},
+	} {
+		if err := acceleration.Append("0000000000000001", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &Handler{registry: registry, acceleration: acceleration}
+	router := gin.New()
+	router.GET("/devices/:stakeNo/acceleration/export", h.handleExportAcceleration)
+
+	rangeQuery := "from=2024-01-01T00:00:00Z&to=2024-01-01T01:00:00Z"
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		want     [][]string
+	}{
+		{
+			name:     "原始采样",
+			url:      "/devices/K1/acceleration/export?" + rangeQuery,
+			wantCode: http.StatusOK,
+			want: [][]string{
+				{"time", "x", "y", "z", "magnitude"},
+				{"2024-01-01T00:00:00Z", "0.600000", "0.000000", "0.800000", "1.000000"},
+				{"2024-01-01T00:00:30Z", "0.000000", "0.000000", "1.000000", "1.000000"},
+				{"2024-01-01T00:01:00Z", "0.000000", "0.000000", "2.000000", "2.000000"},
+			},
+		},
+		{
+			name:     "降采样",
+			url:      "/devices/K1/acceleration/export?interval=1m&" + rangeQuery,
+			wantCode: http.StatusOK,
+			want: [][]string{
+				{"start", "count", "xMin", "xMax", "xMean", "yMin", "yMax", "yMean", "zMin", "zMax", "zMean", "peakMagnitude"},
+				{"2024-01-01T00:00:00Z", "2", "0.000000", "0.600000", "0.300000", "0.000000", "0.000000", "0.000000", "0.800000", "1.000000", "0.900000", "1.000000"},
+				{"2024-01-01T00:01:00Z", "1", "0.000000", "0.000000", "0.000000", "0.000000", "0.000000", "0.000000", "2.000000", "2.000000", "2.000000", "2.000000"},
+			},
+		},
+		{"桩号未登记", "/devices/K404/acceleration/export?" + rangeQuery, http.StatusNotFound, nil},
+		{"from 晚于 to", "/devices/K1/acceleration/export?from=2024-01-01T01:00:00Z&to=2024-01-01T00:00:00Z", http.StatusBadRequest, nil},
+		{"间隔无效", "/devices/K1/acceleration/export?interval=abc&" + rangeQuery, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("状态码 %d，期望 %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="acceleration-K1.csv"` {
+				t.Errorf("Content-Disposition %q", got)
+			}
+			records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records, tt.want) {
+				t.Fatalf("CSV 内容 %v，期望 %v", records, tt.want)
+			}
+		})
+	}
+}
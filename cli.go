@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/services"
+)
+
+const cliUsage = `用法:
+  chirpstack-httpserver                         启动 HTTP 服务
+  chirpstack-httpserver import [-dry-run] FILE  从 CSV/XLSX 台账导入桩号记录
+  chirpstack-httpserver export FILE             将桩号记录导出为 CSV/XLSX 台账
+
+import/export 直接读写 data_dir 下的设备注册表文件，请在服务停止时执行，
+服务运行期间请使用 /api/devices/import 与 /api/devices/export 接口。
+`
+
+// runCommand 执行命令行子命令，返回进程退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "import":
+		return runImport(args[1:])
+	case "export":
+		return runExport(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+}
+
+// runImport 实现 import 子命令
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只校验台账，不写入注册表")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	path := fs.Arg(0)
+
+	cfg := config.LoadConfig()
+	registry, err := services.NewDeviceRegistry(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	format, err := services.InventoryFormat(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	groups, err := services.NewMulticastGroupDirectory(cfg.DataDir, cfg.MulticastGroups)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	report, err := services.ImportInventory(registry, f, format, groups.Snapshot(), *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if len(report.Issues) > 0 {
+		fmt.Fprintf(os.Stderr, "台账校验未通过，共 %d 个问题，未写入注册表\n", len(report.Issues))
+		return 1
+	}
+	return 0
+}
+
+// runExport 实现 export 子命令
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	path := fs.Arg(0)
+
+	cfg := config.LoadConfig()
+	registry, err := services.NewDeviceRegistry(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	format, err := services.InventoryFormat(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	devices := registry.List(services.DeviceFilter{})
+	if err := services.WriteInventory(f, format, devices); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("已导出 %d 条桩号记录到 %s\n", len(devices), path)
+	return 0
+}
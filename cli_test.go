@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chirpstack-httpserver/services"
+)
+
+func TestImportExportCommands(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	cfg := "data_dir: \"./data\"\nmulticast_groups:\n  group1: \"e81cd77b-f1e9-40fc-87ba-10e1fc935596\"\n"
+	if err := os.WriteFile("config.yaml", []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未知多播组使整个台账被拒绝
+	bad := "桩号,设备编号,多播组\nK1,0102030405060708,group1\nK2,0102030405060709,group9\n"
+	if err := os.WriteFile("bad.csv", []byte(bad), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand([]string{"import", "bad.csv"}); code != 1 {
+		t.Fatalf("导入含未知多播组的台账退出码 %d，期望 1", code)
+	}
+
+	good := "桩号,设备编号,道路,多播组\nK1,0102030405060708,G30,group1\nK2,0102030405060709,G30,\n"
+	if err := os.WriteFile("good.csv", []byte(good), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand([]string{"import", "-dry-run", "good.csv"}); code != 0 {
+		t.Fatalf("dry-run 退出码 %d", code)
+	}
+	if _, err := os.Stat(filepath.Join("data", "devices.json")); !os.IsNotExist(err) {
+		t.Fatalf("dry-run 不应写入注册表: %v", err)
+	}
+	if code := runCommand([]string{"import", "good.csv"}); code != 0 {
+		t.Fatalf("导入退出码 %d", code)
+	}
+
+	// 导出的 XLSX 重新导入后与注册表一致
+	if code := runCommand([]string{"export", "out.xlsx"}); code != 0 {
+		t.Fatalf("导出退出码 %d", code)
+	}
+	f, err := os.Open("out.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, issues, err := services.ParseInventory(f, services.InventoryXLSX)
+	if err != nil || len(issues) > 0 {
+		t.Fatalf("err = %v，issues = %+v", err, issues)
+	}
+	if len(rows) != 2 || rows[0].Device.StakeNo != "K1" || rows[0].Device.MulticastGroups[0] != "group1" || rows[1].Device.Road != "G30" {
+		t.Fatalf("导出结果 %+v", rows)
+	}
+}
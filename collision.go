@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/rs/zerolog/log"
+)
+
+// detectCollision 以设备基线和上一次采样检测碰撞，同时更新最新加速度与基线。
+// 检测到碰撞且不在冷却期内时，以配置的报警类型经发件箱上报状态服务器，与设备自身的事故报警 (0x08) 相互独立
+func (h *Handler) detectCollision(devEUI string, sample services.AccelerationSample) error {
+	cfg := h.config.Collision
+	var check services.CollisionCheck
+	report := false
+
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		if cfg.Enabled {
+			var baseline services.AccelerationBaseline
+			if st.AccelerationBaseline != nil {
+				baseline = *st.AccelerationBaseline
+			}
+			check, baseline = h.collision.Check(baseline, st.LastAcceleration, sample)
+			st.AccelerationBaseline = &baseline
+
+			if check.Collision() && (st.LastCollision == nil || sample.At.Sub(*st.LastCollision) >= cfg.Cooldown) {
+				report = true
+				st.LastCollision = &sample.At
+				st.LastAlarm = &services.AlarmSample{WarnType: cfg.WarnType, At: sample.At}
+			}
+		}
+		st.LastAcceleration = &sample
+	})
+	if !check.Collision() {
+		return nil
+	}
+
+	collisionsTotal.WithLabelValues(check.Trigger).Inc()
+	event := log.Warn().
+		Str("devEUI", devEUI).
+		Str("trigger", check.Trigger).
+		Float64("deviation_g", check.Deviation).
+		Float64("jerk_g_per_s", check.Jerk)
+	if !report {
+		event.Msg("检测到碰撞，仍在冷却期内，不重复上报")
+		return nil
+	}
+	event.Msg("根据加速度数据检测到碰撞")
+
+	queued, err := h.outbox.EnqueueWarnInfo(h.stakeNoOf(devEUI), cfg.WarnType, sample.At)
+	if err != nil {
+		return fmt.Errorf("碰撞报警写入发件箱失败: %w", err)
+	}
+	log.Info().Str("devEUI", devEUI).Str("outboxID", queued.ID).Int("warnType", cfg.WarnType).Msg("碰撞报警已写入发件箱")
+	return nil
+}
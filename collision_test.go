@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/services"
+)
+
+// newTestHandler 创建只带本地存储的 Handler，发件箱中的消息不会被投递
+func newTestHandler(t *testing.T, cfg config.Config) *Handler {
+	t.Helper()
+
+	dir := t.TempDir()
+	registry, err := services.NewDeviceRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := services.NewDeviceStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := services.NewOutbox(dir, services.NewStatusServerClient(cfg), nil, time.Second, time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tilt, err := services.NewTiltStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tilt.Close() })
+
+	return &Handler{
+		registry:  registry,
+		collision: services.NewCollisionDetector(cfg.Collision),
+		outbox:    outbox,
+		state:     state,
+		tilt:      tilt,
+		config:    cfg,
+	}
+}
+
+func TestDetectCollisionCooldown(t *testing.T) {
+	cfg := config.Config{Collision: config.CollisionConfig{
+		Enabled:            true,
+		MagnitudeThreshold: 0.5,
+		BaselineSamples:    1,
+		Cooldown:           time.Minute,
+		WarnType:           9,
+	}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		at        []time.Duration // 每次碰撞相对 start 的时间
+		wantAlarm int             // 写入发件箱的报警数
+	}{
+		{"单次碰撞上报", []time.Duration{time.Second}, 1},
+		{"冷却期内的碰撞不重复上报", []time.Duration{time.Second, 30 * time.Second, 60 * time.Second}, 1},
+		{"冷却期满后再次上报", []time.Duration{time.Second, 61 * time.Second, 90 * time.Second, 2 * time.Minute}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, cfg)
+			if err := h.detectCollision("dev", services.AccelerationSample{Z: 1, At: start}); err != nil {
+				t.Fatal(err)
+			}
+			for _, at := range tt.at {
+				if err := h.detectCollision("dev", services.AccelerationSample{X: 1, Z: 1, At: start.Add(at)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if n := len(h.outbox.Pending()); n != tt.wantAlarm {
+				t.Fatalf("上报 %d 次报警，期望 %d 次", n, tt.wantAlarm)
+			}
+			st, _ := h.state.Get("dev")
+			if st.AccelerationBaseline == nil || st.AccelerationBaseline.Samples != 1 {
+				t.Fatalf("基线 %+v，碰撞采样不应计入基线", st.AccelerationBaseline)
+			}
+		})
+	}
+}
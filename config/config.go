@@ -14,7 +14,142 @@ type Config struct {
 	ListenAddress    string            `mapstructure:"listen_address"`
 	GRPCTimeout      time.Duration     `mapstructure:"grpc_timeout"`
 	HTTPTimeout      time.Duration     `mapstructure:"http_timeout"`
-	MulticastGroups  map[string]string `mapstructure:"multicast_groups"`
+	MulticastGroups  map[string]string `mapstructure:"multicast_groups"` // 仅用于初始化多播组目录 multicast_groups.json
+
+	// ChirpStack 应用 ID，通过接口创建和列出多播组时使用
+	ApplicationID string `mapstructure:"application_id"`
+
+	// 批量单播命令同时进行的 SendDownlink 调用数上限
+	DownlinkConcurrency int `mapstructure:"downlink_concurrency"`
+
+	// 本地数据目录，存放设备注册表等持久化文件
+	DataDir string `mapstructure:"data_dir"`
+
+	// 单播下行需在该时长内完成投递，否则标记为 expired
+	DownlinkTTL time.Duration `mapstructure:"downlink_ttl"`
+	// 下行投递记录的保留时长
+	DownlinkRetention time.Duration `mapstructure:"downlink_retention"`
+
+	// status 事件上报的电量（百分比）低于该值时告警
+	LowBatteryLevel float32 `mapstructure:"low_battery_level"`
+
+	// 上行去重窗口，窗口内 DevEUI + fCnt 或 deduplicationId 相同的上行只处理一次
+	DedupWindow time.Duration `mapstructure:"dedup_window"`
+
+	// 上行命令由工作池异步处理：工作协程数、队列总长度，
+	// 以及队列满时的处理方式（drop_oldest 丢弃最早的任务，reject 拒绝新上行）
+	UplinkWorkers   int    `mapstructure:"uplink_workers"`
+	UplinkQueueSize int    `mapstructure:"uplink_queue_size"`
+	UplinkOverflow  string `mapstructure:"uplink_overflow"`
+
+	// 状态服务器通知发件箱：报警与心跳各自的有效期，重试间隔的下限和上限，以及同时进行的投递数上限
+	OutboxWarnTTL      time.Duration `mapstructure:"outbox_warn_ttl"`
+	OutboxHeartbeatTTL time.Duration `mapstructure:"outbox_heartbeat_ttl"`
+	OutboxMinBackoff   time.Duration `mapstructure:"outbox_min_backoff"`
+	OutboxMaxBackoff   time.Duration `mapstructure:"outbox_max_backoff"`
+	OutboxConcurrency  int           `mapstructure:"outbox_concurrency"`
+
+	// 设备心跳周期；连续错过 offline_missed_heartbeats 个周期没有任何上行即判定离线
+	HeartbeatInterval       time.Duration `mapstructure:"heartbeat_interval"`
+	OfflineMissedHeartbeats int           `mapstructure:"offline_missed_heartbeats"`
+
+	// 读取设备配置时等待应答的默认时长与上限，可通过 timeout 查询参数在上限内调整
+	ReadConfigTimeout    time.Duration `mapstructure:"read_config_timeout"`
+	ReadConfigMaxTimeout time.Duration `mapstructure:"read_config_max_timeout"`
+
+	// 加速度时间序列的保留时长，以及查询时直接返回原始采样的数量上限，超过上限时自动降采样
+	AccelerationRetention time.Duration `mapstructure:"acceleration_retention"`
+	AccelerationMaxPoints int           `mapstructure:"acceleration_max_points"`
+
+	// 根据加速度上报检测碰撞
+	Collision CollisionConfig `mapstructure:"collision"`
+
+	// 根据偏移上报跟踪灯桩姿态
+	Tilt TiltConfig `mapstructure:"tilt"`
+
+	// 时间同步的时延补偿
+	TimeSync TimeSyncConfig `mapstructure:"time_sync"`
+
+	// 未在注册表或设备标签 protocolVersion 中声明协议版本的设备所使用的默认版本。
+	// 默认为第一代固件的版本 1，支持读取配置等新命令的设备需显式声明版本 2
+	ProtocolVersion int `mapstructure:"protocol_version"`
+
+	// 集成事件的接入方式：http 由 ChirpStack 推送到 /integration/uplink，
+	// mqtt 则订阅 ChirpStack MQTT 集成发布的事件，此时不再注册 HTTP 接收端点
+	IntegrationMode string     `mapstructure:"integration_mode"`
+	MQTT            MQTTConfig `mapstructure:"mqtt"`
+}
+
+// 集成事件接入方式
+const (
+	IntegrationHTTP = "http"
+	IntegrationMQTT = "mqtt"
+)
+
+// CollisionConfig 是碰撞检测的配置。采样偏离设备基线的合加速度超过 magnitude_threshold，
+// 或与间隔不超过 jerk_window 的上一次采样之间的加加速度超过 jerk_threshold 时判定为碰撞
+type CollisionConfig struct {
+	Enabled            bool          `mapstructure:"enabled"`
+	MagnitudeThreshold float64       `mapstructure:"magnitude_threshold"` // 单位 g
+	JerkThreshold      float64       `mapstructure:"jerk_threshold"`      // 单位 g/s
+	JerkWindow         time.Duration `mapstructure:"jerk_window"`
+	BaselineAlpha      float64       `mapstructure:"baseline_alpha"`   // 基线指数移动平均的平滑系数
+	BaselineSamples    int           `mapstructure:"baseline_samples"` // 建立基线所需的最少采样数
+	Cooldown           time.Duration `mapstructure:"cooldown"`         // 同一设备两次碰撞报警的最小间隔
+	WarnType           int           `mapstructure:"warn_type"`        // 上报状态服务器的报警类型
+}
+
+// TiltConfig 是灯桩姿态跟踪的配置。安装或重置基线后的前 baseline_samples 次偏移上报取平均作为基准姿态，
+// 此后偏转角超过 angle_threshold 时判定为灯桩被撞倒或倾斜，回落到阈值以内后解除
+type TiltConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`         // 关闭时仍记录姿态历史，只是不判定倾斜
+	AngleThreshold  float64       `mapstructure:"angle_threshold"` // 单位度
+	BaselineSamples int           `mapstructure:"baseline_samples"`
+	WarnType        int           `mapstructure:"warn_type"`  // 上报状态服务器的报警类型
+	Retention       time.Duration `mapstructure:"retention"`  // 姿态历史的保留时长
+	MaxPoints       int           `mapstructure:"max_points"` // 单次查询返回的姿态数量上限
+}
+
+// TimeSyncConfig 是时间同步的配置。应答负载对应的时刻为网关收到请求上行的时刻加上 rx_delay
+// 与下行空中时间，即设备在 RX1 窗口收完应答的时刻
+type TimeSyncConfig struct {
+	UseGPS    bool          `mapstructure:"use_gps"`    // 网关提供 GPS 时间时以其为参考
+	RxDelay   time.Duration `mapstructure:"rx_delay"`   // 上行结束到 RX1 窗口打开的时长，需与网络服务器的 RX1 延迟一致
+	Retention time.Duration `mapstructure:"retention"`  // 时间同步历史的保留时长
+	MaxPoints int           `mapstructure:"max_points"` // 单次查询返回的记录数量上限
+
+	// 应答负载默认使用的时区（IANA 名称）与时间格式：ms_since_midnight、unix_seconds 或 gps_seconds
+	Zone   string `mapstructure:"zone"`
+	Format string `mapstructure:"format"`
+	// 按 ChirpStack 设备配置文件 ID 或名称覆盖时区与时间格式，未填写的项沿用默认值。
+	// viper 会将键转为小写，名称不区分大小写
+	Profiles map[string]TimeProfileConfig `mapstructure:"profiles"`
+
+	// 向全部多播组广播时间同步的周期，0（默认）表示只在调用接口时发送。定时广播会占用每个多播组的下行，
+	// 且发送时间按多播队列为空估算，需确认各组的下行安排后再开启。
+	// multicast_margin 需与 ChirpStack 的 multicast_class_b_margin、multicast_class_c_margin 一致，
+	// multicast_delay 为 Class C 按 DELAY 方式调度时入队到发送的预计时长
+	MulticastInterval time.Duration `mapstructure:"multicast_interval"`
+	MulticastMargin   time.Duration `mapstructure:"multicast_margin"`
+	MulticastDelay    time.Duration `mapstructure:"multicast_delay"`
+}
+
+// TimeProfileConfig 是某一设备配置文件的时间同步设置
+type TimeProfileConfig struct {
+	Zone   string `mapstructure:"zone"`
+	Format string `mapstructure:"format"`
+}
+
+// MQTTConfig 是 MQTT 集成的订阅配置
+type MQTTConfig struct {
+	Server   string `mapstructure:"server"` // 如 tcp://127.0.0.1:1883
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	ClientID string `mapstructure:"client_id"`
+	Topic    string `mapstructure:"topic"`
+	QoS      byte   `mapstructure:"qos"`
+	// 事件负载编码，需与 ChirpStack 的 integration.mqtt.json 设置一致：json 或 protobuf
+	Encoding string `mapstructure:"encoding"`
 }
 
 // LoadConfig 加载并返回配置
@@ -27,6 +162,56 @@ func LoadConfig() Config {
 	// 设置默认值
 	viper.SetDefault("grpc_timeout", "5s")
 	viper.SetDefault("http_timeout", "5s")
+	viper.SetDefault("downlink_concurrency", 8)
+	viper.SetDefault("data_dir", "./data")
+	viper.SetDefault("downlink_ttl", "24h")
+	viper.SetDefault("downlink_retention", "168h")
+	viper.SetDefault("low_battery_level", 20)
+	viper.SetDefault("dedup_window", "10m")
+	viper.SetDefault("uplink_workers", 4)
+	viper.SetDefault("uplink_queue_size", 1000)
+	viper.SetDefault("uplink_overflow", "drop_oldest")
+	viper.SetDefault("outbox_warn_ttl", "72h")
+	viper.SetDefault("outbox_heartbeat_ttl", "1h")
+	viper.SetDefault("outbox_min_backoff", "5s")
+	viper.SetDefault("outbox_max_backoff", "10m")
+	viper.SetDefault("outbox_concurrency", 4)
+	viper.SetDefault("heartbeat_interval", "10m")
+	viper.SetDefault("offline_missed_heartbeats", 3)
+	viper.SetDefault("read_config_timeout", "30s")
+	viper.SetDefault("read_config_max_timeout", "5m")
+	viper.SetDefault("protocol_version", 1)
+	viper.SetDefault("acceleration_retention", "720h")
+	viper.SetDefault("acceleration_max_points", 2000)
+	viper.SetDefault("collision.enabled", true)
+	viper.SetDefault("collision.magnitude_threshold", 0.5)
+	viper.SetDefault("collision.jerk_threshold", 2.0)
+	viper.SetDefault("collision.jerk_window", "10s")
+	viper.SetDefault("collision.baseline_alpha", 0.05)
+	viper.SetDefault("collision.baseline_samples", 10)
+	viper.SetDefault("collision.cooldown", "5m")
+	viper.SetDefault("collision.warn_type", 3)
+	viper.SetDefault("tilt.enabled", true)
+	viper.SetDefault("tilt.angle_threshold", 15.0)
+	viper.SetDefault("tilt.baseline_samples", 3)
+	viper.SetDefault("tilt.warn_type", 4)
+	viper.SetDefault("tilt.retention", "720h")
+	viper.SetDefault("tilt.max_points", 2000)
+	viper.SetDefault("time_sync.use_gps", true)
+	viper.SetDefault("time_sync.rx_delay", "1s")
+	viper.SetDefault("time_sync.retention", "720h")
+	viper.SetDefault("time_sync.max_points", 2000)
+	viper.SetDefault("time_sync.zone", "Asia/Shanghai")
+	viper.SetDefault("time_sync.format", "ms_since_midnight")
+	viper.SetDefault("time_sync.multicast_interval", "0s")
+	viper.SetDefault("time_sync.multicast_margin", "5s")
+	viper.SetDefault("time_sync.multicast_delay", "1s")
+	viper.SetDefault("integration_mode", IntegrationHTTP)
+	viper.SetDefault("mqtt.server", "tcp://127.0.0.1:1883")
+	viper.SetDefault("mqtt.client_id", "chirpstack-httpserver")
+	viper.SetDefault("mqtt.topic", "application/+/device/+/event/+")
+	viper.SetDefault("mqtt.qos", 1)
+	viper.SetDefault("mqtt.encoding", "json")
 
 	err := viper.ReadInConfig()
 	if err != nil {
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// 设备列表默认每页条数
+const defaultDevicePageSize = 20
+
+// handleListDevices 分页查询桩号记录
+func (h *Handler) handleListDevices(c *gin.Context) {
+	var q DeviceListQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondValidationError(c, "Invalid query: "+err.Error())
+		return
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PageSize == 0 {
+		q.PageSize = defaultDevicePageSize
+	}
+
+	devices := h.registry.List(q.filter())
+
+	total := len(devices)
+	start := min((q.Page-1)*q.PageSize, total)
+	end := min(start+q.PageSize, total)
+
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": gin.H{
+		"total":    total,
+		"page":     q.Page,
+		"pageSize": q.PageSize,
+		"items":    devices[start:end],
+	}})
+}
+
+// handleGetDevice 查询单个桩号记录
+func (h *Handler) handleGetDevice(c *gin.Context) {
+	stakeNo := c.Param("stakeNo")
+	d, ok := h.registry.Get(stakeNo)
+	if !ok {
+		respondError(c, newUnknownStakeError(stakeNo), "Device not found.")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": d})
+}
+
+// handleCreateDevice 登记新的桩号记录
+func (h *Handler) handleCreateDevice(c *gin.Context) {
+	var cmd DeviceCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		respondValidationError(c, "Invalid request: "+err.Error())
+		return
+	}
+	if cmd.StakeNo == "" {
+		respondValidationError(c, "stakeNo cannot be empty.")
+		return
+	}
+	if err := h.validateDeviceCommand(cmd); err != nil {
+		respondError(c, err, "Invalid request:")
+		return
+	}
+
+	d := cmd.toDevice()
+	if err := h.registry.Create(d); err != nil {
+		log.Error().Err(err).Str("stakeNo", d.StakeNo).Msg("登记设备失败")
+		respondError(c, err, "Failed to create device.")
+		return
+	}
+	log.Info().Str("stakeNo", d.StakeNo).Str("devEUI", d.DevEUI).Msg("设备已登记")
+
+	d, _ = h.registry.Get(d.StakeNo)
+	c.JSON(http.StatusCreated, gin.H{"code": 201, "message": "Device created successfully.", "data": d})
+}
+
+// handleUpdateDevice 更新桩号记录，常用于现场换灯后更换 DevEUI
+func (h *Handler) handleUpdateDevice(c *gin.Context) {
+	stakeNo := c.Param("stakeNo")
+
+	var cmd DeviceCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		respondValidationError(c, "Invalid request: "+err.Error())
+		return
+	}
+	if cmd.StakeNo != "" && cmd.StakeNo != stakeNo {
+		respondValidationError(c, "stakeNo in body does not match the path.")
+		return
+	}
+	if err := h.validateDeviceCommand(cmd); err != nil {
+		respondError(c, err, "Invalid request:")
+		return
+	}
+
+	d := cmd.toDevice()
+	if err := h.registry.Update(stakeNo, d); err != nil {
+		log.Error().Err(err).Str("stakeNo", stakeNo).Msg("更新设备失败")
+		respondError(c, err, "Failed to update device.")
+		return
+	}
+	log.Info().Str("stakeNo", stakeNo).Str("devEUI", d.DevEUI).Msg("设备已更新")
+
+	d, _ = h.registry.Get(stakeNo)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Device updated successfully.", "data": d})
+}
+
+// handleDeleteDevice 删除桩号记录
+func (h *Handler) handleDeleteDevice(c *gin.Context) {
+	stakeNo := c.Param("stakeNo")
+	if err := h.registry.Delete(stakeNo); err != nil {
+		log.Error().Err(err).Str("stakeNo", stakeNo).Msg("删除设备失败")
+		respondError(c, err, "Failed to delete device.")
+		return
+	}
+	log.Info().Str("stakeNo", stakeNo).Msg("设备已删除")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Device deleted successfully."})
+}
+
+// handleImportDevices 从上传的 CSV/XLSX 台账批量导入桩号记录，任一行校验失败则整体不写入
+func (h *Handler) handleImportDevices(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondValidationError(c, "Missing file: "+err.Error())
+		return
+	}
+	format := c.Query("format")
+	if format == "" {
+		if format, err = services.InventoryFormat(file.Filename); err != nil {
+			respondValidationError(c, err.Error())
+			return
+		}
+	}
+	dryRun := c.Query("dryRun") == "true"
+
+	f, err := file.Open()
+	if err != nil {
+		respondError(c, err, "Failed to open uploaded file.")
+		return
+	}
+	defer f.Close()
+
+	report, err := services.ImportInventory(h.registry, f, format, h.groups.Snapshot(), dryRun)
+	if err != nil {
+		log.Error().Err(err).Str("file", file.Filename).Msg("导入设备台账失败")
+		respondValidationError(c, err.Error())
+		return
+	}
+	if len(report.Issues) > 0 {
+		log.Warn().Str("file", file.Filename).Int("issues", len(report.Issues)).Msg("设备台账校验未通过")
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "reason": reasonValidationError, "message": "Inventory validation failed.", "data": report})
+		return
+	}
+
+	log.Info().Str("file", file.Filename).Int("created", report.Created).Int("updated", report.Updated).Bool("dryRun", dryRun).Msg("设备台账导入完成")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Inventory imported successfully.", "data": report})
+}
+
+// handleExportDevices 按筛选条件将桩号记录导出为 CSV/XLSX 文件
+func (h *Handler) handleExportDevices(c *gin.Context) {
+	var q DeviceListQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondValidationError(c, "Invalid query: "+err.Error())
+		return
+	}
+	format := c.DefaultQuery("format", services.InventoryCSV)
+	if format != services.InventoryCSV && format != services.InventoryXLSX {
+		respondValidationError(c, "format must be csv or xlsx")
+		return
+	}
+
+	devices := h.registry.List(q.filter())
+
+	var buf bytes.Buffer
+	if err := services.WriteInventory(&buf, format, devices); err != nil {
+		log.Error().Err(err).Msg("导出设备台账失败")
+		respondError(c, err, "Failed to export inventory.")
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == services.InventoryXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	c.Header("Content-Disposition", `attachment; filename="devices.`+format+`"`)
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// validateDeviceCommand 校验 DevEUI 格式、协议版本，以及多播组名称均已在多播组目录中登记
+func (h *Handler) validateDeviceCommand(cmd DeviceCommand) error {
+	if !services.IsDevEUI(cmd.DevEUI) {
+		return newValidationError("invalid devEUI: %s", cmd.DevEUI)
+	}
+	if cmd.ProtocolVersion != 0 && !protocol.Version(cmd.ProtocolVersion).Known() {
+		return newValidationError("unknown protocol version: %d", cmd.ProtocolVersion)
+	}
+	for _, g := range cmd.MulticastGroups {
+		if _, ok := h.groups.Lookup(g); !ok {
+			return newValidationError("unknown multicast group: %s", g)
+		}
+	}
+	return nil
+}
+
+// toDevice 将请求体转换为注册表记录
+func (cmd DeviceCommand) toDevice() services.Device {
+	return services.Device{
+		StakeNo:         cmd.StakeNo,
+		DevEUI:          cmd.DevEUI,
+		Road:            cmd.Road,
+		Direction:       cmd.Direction,
+		KilometrePost:   cmd.KilometrePost,
+		MulticastGroups: cmd.MulticastGroups,
+		InstallDate:     cmd.InstallDate,
+		ProtocolVersion: cmd.ProtocolVersion,
+	}
+}
+
+// filter 将查询参数转换为注册表筛选条件
+func (q DeviceListQuery) filter() services.DeviceFilter {
+	return services.DeviceFilter{
+		Road:      q.Road,
+		Direction: q.Direction,
+		Group:     q.Group,
+		Keyword:   q.Keyword,
+		KmFrom:    q.KmFrom,
+		KmTo:      q.KmTo,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeviceCRUD(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h := newGroupTestHandler(t, t.TempDir(), map[string]string{"g1": "uuid-1"})
+	router := gin.New()
+	router.GET("/devices/:stakeNo", h.handleGetDevice)
+	router.POST("/devices", h.handleCreateDevice)
+	router.PUT("/devices/:stakeNo", h.handleUpdateDevice)
+	router.DELETE("/devices/:stakeNo", h.handleDeleteDevice)
+
+	// 各步骤依次执行，后面的步骤依赖前面的结果
+	steps := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantCode   int
+		wantReason string
+	}{
+		{"登记", http.MethodPost, "/devices", `{"stakeNo": "K1", "devEUI": "0000000000000001", "multicastGroups": ["g1"], "protocolVersion": 2}`, http.StatusCreated, ""},
+		{"桩号重复", http.MethodPost, "/devices", `{"stakeNo": "K1", "devEUI": "0000000000000002"}`, http.StatusConflict, reasonConflict},
+		{"DevEUI 已被其他桩号使用", http.MethodPost, "/devices", `{"stakeNo": "K2", "devEUI": "0000000000000001"}`, http.StatusConflict, reasonConflict},
+		{"DevEUI 格式错误", http.MethodPost, "/devices", `{"stakeNo": "K2", "devEUI": "00000000000000zz"}`, http.StatusBadRequest, reasonValidationError},
+		{"DevEUI 长度错误", http.MethodPost, "/devices", `{"stakeNo": "K2", "devEUI": "0001"}`, http.StatusBadRequest, reasonValidationError},
+		{"缺少 DevEUI", http.MethodPost, "/devices", `{"stakeNo": "K2"}`, http.StatusBadRequest, reasonValidationError},
+		{"缺少桩号", http.MethodPost, "/devices", `{"devEUI": "0000000000000002"}`, http.StatusBadRequest, reasonValidationError},
+		{"多播组未登记", http.MethodPost, "/devices", `{"stakeNo": "K2", "devEUI": "0000000000000002", "multicastGroups": ["g404"]}`, http.StatusBadRequest, reasonValidationError},
+		{"协议版本未知", http.MethodPost, "/devices", `{"stakeNo": "K2", "devEUI": "0000000000000002", "protocolVersion": 9}`, http.StatusBadRequest, reasonValidationError},
+		{"安装日期格式错误", http.MethodPost, "/devices", `{"stakeNo": "K2", "devEUI": "0000000000000002", "installDate": "2024/01/01"}`, http.StatusBadRequest, reasonValidationError},
+		{"请求体桩号与路径不一致", http.MethodPut, "/devices/K1", `{"stakeNo": "K2", "devEUI": "0000000000000003"}`, http.StatusBadRequest, reasonValidationError},
+		{"更新未登记的桩号", http.MethodPut, "/devices/K404", `{"devEUI": "0000000000000003"}`, http.StatusNotFound, reasonUnknownStake},
+		{"更新时多播组未登记", http.MethodPut, "/devices/K1", `{"devEUI": "0000000000000003", "multicastGroups": ["g404"]}`, http.StatusBadRequest, reasonValidationError},
+		{"换灯后更换 DevEUI", http.MethodPut, "/devices/K1", `{"devEUI": "0000000000000003"}`, http.StatusOK, ""},
+		{"查询", http.MethodGet, "/devices/K1", "", http.StatusOK, ""},
+		{"旧 DevEUI 可重新登记", http.MethodPost, "/devices", `{"stakeNo": "K2", "devEUI": "0000000000000001"}`, http.StatusCreated, ""},
+		{"删除", http.MethodDelete, "/devices/K1", "", http.StatusOK, ""},
+		{"查询已删除的桩号", http.MethodGet, "/devices/K1", "", http.StatusNotFound, reasonUnknownStake},
+		{"重复删除", http.MethodDelete, "/devices/K1", "", http.StatusNotFound, reasonUnknownStake},
+	}
+	for _, step := range steps {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(step.method, step.url, strings.NewReader(step.body)))
+
+		var resp struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if w.Code != step.wantCode || resp.Reason != step.wantReason {
+			t.Fatalf("%s: 响应 %d %s，期望 %d %s", step.name, w.Code, w.Body, step.wantCode, step.wantReason)
+		}
+	}
+
+	if d, ok := h.registry.Get("K2"); !ok || d.DevEUI != "0000000000000001" {
+		t.Fatalf("K2 的记录 %+v", d)
+	}
+	if _, ok := h.registry.Get("K1"); ok {
+		t.Fatal("K1 删除后仍在注册表中")
+	}
+	if devEUI, err := h.resolveDevEUI("K2"); err != nil || devEUI != "0000000000000001" {
+		t.Fatalf("K2 解析为 %s %v", devEUI, err)
+	}
+	if _, err := h.resolveDevEUI("K1"); err == nil {
+		t.Fatal("已删除的桩号仍可解析")
+	}
+	if members := h.registry.List(services.DeviceFilter{Group: "g1"}); len(members) != 0 {
+		t.Fatalf("g1 中还有 %d 台设备", len(members))
+	}
+}
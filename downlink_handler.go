@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetDownlink 查询单播下行的投递状态
+func (h *Handler) handleGetDownlink(c *gin.Context) {
+	id := c.Param("id")
+	rec, found := h.tracker.Get(id)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "reason": "NotFound", "message": "Unknown downlink id: " + id})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": rec})
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"time"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+	"github.com/chirpstack/chirpstack/api/go/v4/integration"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// errInvalidEvent 表示事件负载无法解析
+var errInvalidEvent = errors.New("invalid event payload")
+
+// eventPayload 是一条尚未解析的集成事件负载
+type eventPayload struct {
+	Body     []byte
+	Protobuf bool // true 表示 Protobuf 编码，否则为 JSON
+}
+
+// isProtobufContentType 判断 HTTP 集成的 Content-Type 是否为 Protobuf 编码。
+// ChirpStack 以 Protobuf 编码推送时使用 application/octet-stream
+func isProtobufContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/octet-stream", "application/protobuf", "application/x-protobuf":
+		return true
+	default:
+		return false
+	}
+}
+
+// decode 按负载的编码将其解析到 msg
+func (p eventPayload) decode(msg proto.Message) error {
+	var err error
+	if p.Protobuf {
+		err = proto.Unmarshal(p.Body, msg)
+	} else {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(p.Body, msg)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidEvent, err)
+	}
+	return nil
+}
+
+// eventHandlerFunc 定义了处理 ChirpStack 集成事件的函数签名
+type eventHandlerFunc func(h *Handler, payload eventPayload) error
+
+// eventHandlers 是一个从事件类型（event 查询参数）到其处理函数的映射（注册表）
+var eventHandlers = map[string]eventHandlerFunc{
+	"up":       handleUplinkEvent,
+	"join":     handleJoinEvent,
+	"ack":      handleAckEvent,
+	"txack":    handleTxAckEvent,
+	"status":   handleStatusEvent,
+	"log":      handleLogEvent,
+	"location": handleLocationEvent,
+}
+
+// dispatchEvent 按事件类型将事件分派给 eventHandlers 中的处理函数
+func (h *Handler) dispatchEvent(event string, payload eventPayload) error {
+	handlerFunc, found := eventHandlers[event]
+	if !found {
+		log.Warn().Str("event", event).Msg("接收到未处理的事件，已忽略")
+		return nil
+	}
+
+	if err := handlerFunc(h, payload); err != nil {
+		log.Error().Err(err).Str("event", event).Bool("protobuf", payload.Protobuf).Msg("事件处理失败")
+		return err
+	}
+	return nil
+}
+
+// handleMQTTEvent 处理 MQTT 集成事件，负载编码由配置决定
+func (h *Handler) handleMQTTEvent(event string, body []byte) {
+	payload := eventPayload{Body: body, Protobuf: h.config.MQTT.Encoding == "protobuf"}
+	// 解析失败等错误已在 dispatchEvent 中记录，MQTT 没有可回复的对象
+	_ = h.dispatchEvent(event, payload)
+}
+
+// handleUplinkEvent 处理 up 事件，按首字节命令码分派到 commandHandlers
+func handleUplinkEvent(h *Handler, payload eventPayload) error {
+	var uplink UplinkEvent
+	if err := payload.decode(&uplink); err != nil {
+		return err
+	}
+
+	devEUI := uplink.GetDeviceInfo().GetDevEui()
+	logEvent := log.Info().
+		Str("devEUI", devEUI).
+		Uint32("fCnt", uplink.GetFCnt()).
+		Uint32("fPort", uplink.GetFPort()).
+		Uint32("dr", uplink.GetDr())
+	if rx := bestRxInfo(&uplink); rx != nil {
+		logEvent = logEvent.Str("gatewayId", rx.GetGatewayId()).Int32("rssi", rx.GetRssi()).Float32("snr", rx.GetSnr())
+	}
+	logEvent.Msg("收到上行数据")
+
+	// 命令处理可能需要调用状态服务器，交给工作池异步执行以便立即应答 ChirpStack
+	return h.enqueueUplink(devEUI, func() {
+		h.processUplink(devEUI, &uplink)
+	})
+}
+
+// processUplink 对上行去重后按首字节命令码分派到 commandHandlers
+func (h *Handler) processUplink(devEUI string, uplink *UplinkEvent) {
+	verdict := h.dedup.Check(devEUI, uplink.GetFCnt(), uplink.GetDeduplicationId())
+	uplinksTotal.WithLabelValues(string(verdict)).Inc()
+	switch verdict {
+	case services.UplinkDuplicate:
+		log.Warn().Str("devEUI", devEUI).Uint32("fCnt", uplink.GetFCnt()).Str("deduplicationId", uplink.GetDeduplicationId()).Msg("重复的上行，已忽略")
+		return
+	case services.UplinkReplay:
+		log.Warn().Str("devEUI", devEUI).Uint32("fCnt", uplink.GetFCnt()).Msg("帧计数器回退，疑似重放，已拒绝")
+		return
+	case services.UplinkCounterReset:
+		// 入网事件丢失或未被处理，按重新入网继续处理，缺失的配置由心跳触发补发
+		log.Warn().Str("devEUI", devEUI).Uint32("fCnt", uplink.GetFCnt()).Msg("帧计数器大幅回退，按设备重新入网处理")
+	}
+
+	if at := uplinkTime(uplink); h.presence.Seen(devEUI, at) {
+		h.reportPresence(devEUI, true, at)
+	}
+
+	h.learnProtocolVersion(uplink.GetDeviceInfo())
+	h.learnDeviceProfile(uplink.GetDeviceInfo())
+
+	decodedData := uplink.GetData()
+	if len(decodedData) == 0 {
+		log.Warn().Str("devEUI", devEUI).Msg("数据负载为空")
+		return
+	}
+
+	cmdCode := decodedData[0]
+	handlerFunc, found := commandHandlers[cmdCode]
+	if !found {
+		log.Warn().Int("cmdCode", int(cmdCode)).Str("devEUI", devEUI).Msg("未知的命令码")
+		return
+	}
+
+	codec := h.codecFor(devEUI)
+	msg, err := codec.DecodeUplink(decodedData)
+	if err != nil {
+		log.Error().Err(err).Str("devEUI", devEUI).Int("cmdCode", int(cmdCode)).Stringer("protocolVersion", codec.Version()).Hex("data", decodedData).Msg("上行负载解码失败")
+		return
+	}
+
+	if err := handlerFunc(h, devEUI, msg, uplink); err != nil {
+		// 处理器内部已经记录了详细错误，这里只记录分派层面的失败信息
+		log.Error().Err(err).Str("devEUI", devEUI).Int("cmdCode", int(cmdCode)).Msg("命令处理失败")
+	}
+}
+
+// reportPresence 将设备上线或离线通过发件箱上报给状态服务器
+func (h *Handler) reportPresence(devEUI string, online bool, at time.Time) {
+	stakeNo := h.stakeNoOf(devEUI)
+	loraStatus := services.LoraOffline
+	if online {
+		loraStatus = services.LoraOnline
+		log.Info().Str("devEUI", devEUI).Str("stakeNo", stakeNo).Msg("设备恢复在线")
+	} else {
+		log.Warn().Str("devEUI", devEUI).Str("stakeNo", stakeNo).Msg("设备长时间未上行，判定为离线")
+	}
+
+	if _, err := h.outbox.EnqueueLoraStatus(stakeNo, loraStatus, at); err != nil {
+		log.Error().Err(err).Str("devEUI", devEUI).Str("loraStatus", loraStatus).Msg("在线状态写入发件箱失败")
+	}
+}
+
+// enqueueUplink 将设备的上行任务提交到工作池，同一设备的任务按提交顺序执行。
+// 队列满时只会丢弃该设备自己最早的任务
+func (h *Handler) enqueueUplink(devEUI string, job func()) error {
+	dropped, err := h.uplinks.Submit(devEUI, job)
+	if err != nil {
+		uplinkJobsTotal.WithLabelValues("rejected").Inc()
+		log.Warn().Err(err).Str("devEUI", devEUI).Msg("上行任务提交失败")
+		return err
+	}
+	uplinkJobsTotal.WithLabelValues("queued").Inc()
+	if dropped {
+		uplinkJobsTotal.WithLabelValues("dropped").Inc()
+		h.state.Update(devEUI, func(st *services.DeviceState) { st.DroppedUplinks++ })
+		log.Warn().Str("devEUI", devEUI).Msg("上行队列已满，已丢弃该设备最早的任务")
+	}
+	return nil
+}
+
+// handleJoinEvent 处理 join 事件。已登记的灯具再次入网通常意味着断电重启或掉线后重连
+func handleJoinEvent(h *Handler, payload eventPayload) error {
+	var event JoinEvent
+	if err := payload.decode(&event); err != nil {
+		return err
+	}
+
+	devEUI := event.GetDeviceInfo().GetDevEui()
+	log.Warn().
+		Str("devEUI", devEUI).
+		Str("stakeNo", h.stakeNoOf(devEUI)).
+		Str("devAddr", event.GetDevAddr()).
+		Msg("设备重新入网")
+
+	// 入网后帧计数器归零，清除去重记录以免新的上行被当作重放，并补发设备错过的配置。
+	// 经由工作池执行，保证入网前已排队的上行先按旧的帧计数器处理
+	return h.enqueueUplink(devEUI, func() {
+		h.learnProtocolVersion(event.GetDeviceInfo())
+		h.learnDeviceProfile(event.GetDeviceInfo())
+		h.dedup.Reset(devEUI)
+		h.reconcile(devEUI, "join")
+	})
+}
+
+// handleAckEvent 处理 ack 事件，更新确认帧的投递状态
+func handleAckEvent(h *Handler, payload eventPayload) error {
+	var event AckEvent
+	if err := payload.decode(&event); err != nil {
+		return err
+	}
+
+	devEUI := event.GetDeviceInfo().GetDevEui()
+	rec, found := h.tracker.MarkAck(event.GetQueueItemId(), event.GetAcknowledged())
+	if !found {
+		log.Warn().Str("devEUI", devEUI).Str("queueItemId", event.GetQueueItemId()).Msg("收到未跟踪下行的 ack 事件")
+	} else if event.GetAcknowledged() {
+		h.recordDelivered(rec)
+		log.Info().Str("devEUI", devEUI).Str("downlinkID", rec.ID).Uint32("fCntDown", event.GetFCntDown()).Msg("下行已被设备确认")
+	} else {
+		log.Warn().Str("devEUI", devEUI).Str("downlinkID", rec.ID).Uint32("fCntDown", event.GetFCntDown()).Msg("下行未被设备确认")
+	}
+	return nil
+}
+
+// handleTxAckEvent 处理 txack 事件，标记下行已由网关发出
+func handleTxAckEvent(h *Handler, payload eventPayload) error {
+	var event TxAckEvent
+	if err := payload.decode(&event); err != nil {
+		return err
+	}
+
+	devEUI := event.GetDeviceInfo().GetDevEui()
+	if rec, found := h.tracker.MarkSent(event.GetQueueItemId(), event.GetFCntDown()); found {
+		h.recordDelivered(rec)
+		log.Info().Str("devEUI", devEUI).Str("downlinkID", rec.ID).Uint32("fCntDown", event.GetFCntDown()).Msg("下行已由网关发出")
+	} else {
+		log.Warn().Str("devEUI", devEUI).Str("queueItemId", event.GetQueueItemId()).Msg("收到未跟踪下行的 txack 事件")
+	}
+	return nil
+}
+
+// handleStatusEvent 处理 status 事件，电池供电的设备电量低于阈值时告警
+func handleStatusEvent(h *Handler, payload eventPayload) error {
+	var event StatusEvent
+	if err := payload.decode(&event); err != nil {
+		return err
+	}
+
+	devEUI := event.GetDeviceInfo().GetDevEui()
+	logEvent := log.Info()
+	msg := "收到设备状态"
+	if !event.GetExternalPowerSource() && !event.GetBatteryLevelUnavailable() && event.GetBatteryLevel() < h.config.LowBatteryLevel {
+		logEvent = log.Warn()
+		msg = "设备电量低"
+	}
+	logEvent.
+		Str("devEUI", devEUI).
+		Str("stakeNo", h.stakeNoOf(devEUI)).
+		Int32("margin", event.GetMargin()).
+		Bool("externalPower", event.GetExternalPowerSource()).
+		Float32("batteryLevel", event.GetBatteryLevel()).
+		Msg(msg)
+	return nil
+}
+
+// handleLogEvent 处理 log 事件。带有队列项 ID 的错误日志说明对应的下行未能发出
+func handleLogEvent(h *Handler, payload eventPayload) error {
+	var event LogEvent
+	if err := payload.decode(&event); err != nil {
+		return err
+	}
+
+	devEUI := event.GetDeviceInfo().GetDevEui()
+	logEvent := log.Info()
+	switch event.GetLevel() {
+	case integration.LogLevel_WARNING:
+		logEvent = log.Warn()
+	case integration.LogLevel_ERROR:
+		logEvent = log.Error()
+	}
+	logEvent.
+		Str("devEUI", devEUI).
+		Str("code", event.GetCode().String()).
+		Interface("context", event.GetContext()).
+		Msg("ChirpStack 日志: " + event.GetDescription())
+
+	queueItemID := event.GetContext()["queue_item_id"]
+	if queueItemID == "" {
+		return nil
+	}
+	switch {
+	case event.GetCode() == integration.LogCode_EXPIRED:
+		h.tracker.MarkExpired(queueItemID, event.GetDescription())
+	case event.GetLevel() == integration.LogLevel_ERROR:
+		h.tracker.MarkFailed(queueItemID, event.GetCode().String()+": "+event.GetDescription())
+	}
+	return nil
+}
+
+// handleLocationEvent 处理 location 事件
+func handleLocationEvent(h *Handler, payload eventPayload) error {
+	var event LocationEvent
+	if err := payload.decode(&event); err != nil {
+		return err
+	}
+
+	devEUI := event.GetDeviceInfo().GetDevEui()
+	location := event.GetLocation()
+	log.Info().
+		Str("devEUI", devEUI).
+		Str("stakeNo", h.stakeNoOf(devEUI)).
+		Float64("latitude", location.GetLatitude()).
+		Float64("longitude", location.GetLongitude()).
+		Float64("altitude", location.GetAltitude()).
+		Str("source", location.GetSource().String()).
+		Msg("收到设备位置")
+	return nil
+}
+
+// uplinkTime 返回上行的接收时间（本地时区），事件中未携带时间时使用当前时间
+func uplinkTime(uplink *UplinkEvent) time.Time {
+	if t := uplink.GetTime(); t != nil {
+		return t.AsTime().Local()
+	}
+	return time.Now()
+}
+
+// bestRxInfo 返回信噪比最高的接收网关信息，没有网关信息时返回 nil
+func bestRxInfo(uplink *UplinkEvent) *gw.UplinkRxInfo {
+	var best *gw.UplinkRxInfo
+	for _, rx := range uplink.GetRxInfo() {
+		if best == nil || rx.GetSnr() > best.GetSnr() {
+			best = rx
+		}
+	}
+	return best
+}
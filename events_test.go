@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/common"
+	"github.com/chirpstack/chirpstack/api/go/v4/integration"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestIsProtobufContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/octet-stream", true},
+		{"application/protobuf", true},
+		{"application/x-protobuf", true},
+		{"application/octet-stream; charset=binary", true},
+		{"application/json", false},
+		{"application/json; charset=utf-8", false},
+		{"", false},
+		{"not a media type;;", false},
+	}
+	for _, tt := range tests {
+		if got := isProtobufContentType(tt.contentType); got != tt.want {
+			t.Errorf("isProtobufContentType(%q) = %v，期望 %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestEventPayloadDecode(t *testing.T) {
+	event := &AckEvent{DeviceInfo: &integration.DeviceInfo{DevEui: "0000000000000001"}, QueueItemId: "dl-1", Acknowledged: true, FCntDown: 7}
+	binary, err := proto.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		payload eventPayload
+		wantErr bool
+	}{
+		{"Protobuf", eventPayload{Body: binary, Protobuf: true}, false},
+		{"JSON", eventPayload{Body: []byte(`{"deviceInfo": {"devEui": "0000000000000001"}, "queueItemId": "dl-1", "acknowledged": true, "fCntDown": 7}`)}, false},
+		{"JSON 中的未知字段被忽略", eventPayload{Body: []byte(`{"deviceInfo": {"devEui": "0000000000000001"}, "queueItemId": "dl-1", "acknowledged": true, "fCntDown": 7, "newField": 1}`)}, false},
+		{"Protobuf 负载按 JSON 解析", eventPayload{Body: binary}, true},
+		{"JSON 负载按 Protobuf 解析", eventPayload{Body: []byte(`{"queueItemId": "dl-1"}`), Protobuf: true}, true},
+		{"JSON 格式错误", eventPayload{Body: []byte(`{"queueItemId":`)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got AckEvent
+			err := tt.payload.decode(&got)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidEvent) {
+					t.Fatalf("错误 %v，期望 %v", err, errInvalidEvent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(&got, event) {
+				t.Fatalf("解析结果 %v，期望 %v", &got, event)
+			}
+		})
+	}
+}
+
+func TestDispatchEvent(t *testing.T) {
+	const devEUI = "0000000000000001"
+	info := &integration.DeviceInfo{DevEui: devEUI}
+
+	// wantStatus 为事件处理后 dl-1 的投递状态
+	tests := []struct {
+		name       string
+		event      string
+		msg        proto.Message
+		wantStatus services.DownlinkStatus
+		wantError  string
+	}{
+		{"ack 已确认", "ack", &AckEvent{DeviceInfo: info, QueueItemId: "dl-1", Acknowledged: true}, services.DownlinkAcknowledged, ""},
+		{"ack 未确认", "ack", &AckEvent{DeviceInfo: info, QueueItemId: "dl-1"}, services.DownlinkFailed, "not acknowledged by device"},
+		{"ack 未跟踪的下行", "ack", &AckEvent{DeviceInfo: info, QueueItemId: "dl-404", Acknowledged: true}, services.DownlinkQueued, ""},
+		{"txack", "txack", &TxAckEvent{DeviceInfo: info, QueueItemId: "dl-1", FCntDown: 7}, services.DownlinkSent, ""},
+		{"log 队列项过期", "log", &LogEvent{DeviceInfo: info, Level: integration.LogLevel_WARNING, Code: integration.LogCode_EXPIRED, Description: "expired", Context: map[string]string{"queue_item_id": "dl-1"}}, services.DownlinkExpired, "expired"},
+		{"log 下行发送错误", "log", &LogEvent{DeviceInfo: info, Level: integration.LogLevel_ERROR, Code: integration.LogCode_DOWNLINK_GATEWAY, Description: "TX_FREQ", Context: map[string]string{"queue_item_id": "dl-1"}}, services.DownlinkFailed, "DOWNLINK_GATEWAY: TX_FREQ"},
+		{"log 非错误日志", "log", &LogEvent{DeviceInfo: info, Level: integration.LogLevel_INFO, Code: integration.LogCode_DOWNLINK_GATEWAY, Context: map[string]string{"queue_item_id": "dl-1"}}, services.DownlinkQueued, ""},
+		{"log 不带队列项", "log", &LogEvent{DeviceInfo: info, Level: integration.LogLevel_ERROR, Code: integration.LogCode_UPLINK_CODEC}, services.DownlinkQueued, ""},
+		{"status", "status", &StatusEvent{DeviceInfo: info, BatteryLevel: 5}, services.DownlinkQueued, ""},
+		{"location", "location", &LocationEvent{DeviceInfo: info, Location: &common.Location{Latitude: 31.2, Longitude: 121.5}}, services.DownlinkQueued, ""},
+		{"未知事件被忽略", "integration", &StatusEvent{DeviceInfo: info}, services.DownlinkQueued, ""},
+	}
+	for _, tt := range tests {
+		for _, encoding := range []string{"JSON", "Protobuf"} {
+			protobuf := encoding == "Protobuf"
+			t.Run(tt.name+"/"+encoding, func(t *testing.T) {
+				h, _ := newUnicastTestHandler(t, nil)
+				h.config.LowBatteryLevel = 20
+				h.tracker.Track(services.DownlinkRecord{ID: "dl-1", DevEUI: devEUI, Confirmed: true})
+
+				if err := h.dispatchEvent(tt.event, encodeEvent(t, tt.msg, protobuf)); err != nil {
+					t.Fatal(err)
+				}
+				r, _ := h.tracker.Get("dl-1")
+				if r.Status != tt.wantStatus || r.Error != tt.wantError {
+					t.Fatalf("下行状态 %s %q，期望 %s %q", r.Status, r.Error, tt.wantStatus, tt.wantError)
+				}
+			})
+		}
+	}
+
+	// 负载无法解析时返回错误，由调用方应答 400
+	h, _ := newUnicastTestHandler(t, nil)
+	for event := range eventHandlers {
+		if err := h.dispatchEvent(event, eventPayload{Body: []byte("{")}); !errors.Is(err, errInvalidEvent) {
+			t.Errorf("%s 事件负载无效时返回 %v，期望 %v", event, err, errInvalidEvent)
+		}
+	}
+}
+
+func TestDispatchJoinEvent(t *testing.T) {
+	const devEUI = "0000000000000001"
+	for _, protobuf := range []bool{false, true} {
+		h, fake := newUnicastTestHandler(t, nil)
+		uplinks, err := services.NewWorkerPool(1, 8, services.OverflowDropOldest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.uplinks = uplinks
+		h.dedup = services.NewUplinkDeduplicator(0)
+		h.dedup.Check(devEUI, 100, "")
+		// 设备断电期间错过了整体设置
+		h.recordDesired(devEUI, protocol.Overall{Color: 1, Frequency: 60, Level: 2000, Manner: 1})
+
+		event := &JoinEvent{DeviceInfo: &integration.DeviceInfo{
+			DevEui:            devEUI,
+			DeviceProfileName: "street-light",
+			Tags:              map[string]string{protocolVersionTag: "2"},
+		}}
+		if err := h.dispatchEvent("join", encodeEvent(t, event, protobuf)); err != nil {
+			t.Fatal(err)
+		}
+		uplinks.Stop()
+
+		st, _ := h.state.Get(devEUI)
+		if st.ProtocolVersion != 2 || st.DeviceProfileName != "street-light" {
+			t.Fatalf("protobuf=%v: 入网后设备状态 %+v，期望记录标签中的协议版本和设备配置文件", protobuf, st)
+		}
+		// 入网后帧计数器从 0 开始，不应被判为回退
+		if verdict := h.dedup.Check(devEUI, 1, ""); verdict != services.UplinkAccepted {
+			t.Fatalf("protobuf=%v: 入网后的首个上行判定为 %s，期望 %s", protobuf, verdict, services.UplinkAccepted)
+		}
+		if len(fake.unicast) != 1 || !fake.unicast[0].GetConfirmed() {
+			t.Fatalf("protobuf=%v: 入网后补发了 %d 条下行，期望 1 条确认帧", protobuf, len(fake.unicast))
+		}
+	}
+}
+
+// encodeEvent 将事件编码为 ChirpStack 推送的 JSON 或 Protobuf 负载
+func encodeEvent(t *testing.T, msg proto.Message, protobuf bool) eventPayload {
+	t.Helper()
+
+	var body []byte
+	var err error
+	if protobuf {
+		body, err = proto.Marshal(msg)
+	} else {
+		body, err = protojson.Marshal(msg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eventPayload{Body: body, Protobuf: protobuf}
+}
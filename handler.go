@@ -1,69 +1,83 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/protocol"
 	"chirpstack-httpserver/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
-// commandHandlerFunc 定义了处理上行命令的函数签名
-type commandHandlerFunc func(h *Handler, devEUI string, data []byte) error
+// commandHandlerFunc 定义了处理上行命令的函数签名，msg 为按设备协议版本解码后的负载，
+// uplink 携带完整的上行元数据（rxInfo、txInfo、fCnt 等）
+type commandHandlerFunc func(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error
 
 // commandHandlers 是一个从命令码到其处理函数的映射（注册表）
 var commandHandlers = map[byte]commandHandlerFunc{
-	0x04: handleSkew,
-	0x05: handleAccMonitor,
-	0x06: handleTimeSync,
-	0x07: handleManualAlarm,
-	0x08: handleAccidentAlarm,
-	0x09: handleHeartbeat,
+	protocol.CodeSkew:          handleSkew,
+	protocol.CodeAcceleration:  handleAccMonitor,
+	protocol.CodeTimeSync:      handleTimeSync,
+	protocol.CodeManualAlarm:   handleManualAlarm,
+	protocol.CodeAccidentAlarm: handleAccidentAlarm,
+	protocol.CodeHeartbeat:     handleHeartbeat,
+	protocol.CodeConfigReport:  handleConfigReport,
 }
 
-// handleSkew 处理偏移请求 (原 case 0x04)
-func handleSkew(h *Handler, devEUI string, data []byte) error {
-	log.Info().Str("devEUI", devEUI).Msg("处理偏移请求")
-	return nil
-}
-
-// handleTimeSync 处理时间同步请求 (原 case 0x06)
-func handleTimeSync(h *Handler, devEUI string, data []byte) error {
-	log.Info().Str("devEUI", devEUI).Msg("处理延迟测量请求")
+// handleSkew 处理偏移上报 (原 case 0x04)，换算姿态角、写入时间序列并检测灯桩倾斜
+func handleSkew(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error {
+	skew := msg.(protocol.Skew)
+	x, y, z := protocol.Axes(skew).G()
+	sample := services.NewTiltSample(x, y, z, uplinkTime(uplink))
 
-	// 【1】 获取当前CTS
-	nowCST := time.Now().In(time.FixedZone("CST", 8*60*60))
-
-	// 【2】 计算当天午夜CTS时间点
-	midnightCST := time.Date(nowCST.Year(), nowCST.Month(), nowCST.Day(), 0, 0, 0, 0, nowCST.Location())
+	log.Info().
+		Str("devEUI", devEUI).
+		Float64("pitch", sample.Pitch).
+		Float64("roll", sample.Roll).
+		Float64("tilt", sample.Tilt).
+		Msg("收到偏移数据")
 
-	// 【3】 计算自午夜以来经过的毫秒数
-	durationSinceMidnight := nowCST.Sub(midnightCST)
-	msSinceMidnight := uint32(durationSinceMidnight.Milliseconds())
+	return h.detectTilt(devEUI, sample)
+}
 
-	// 【4】 将毫秒数(uint32)序列化为4个字节 (Big Endian)
-	timeBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(timeBytes, msSinceMidnight)
+// handleTimeSync 处理时间同步请求 (原 case 0x06)，以网关收到请求的时刻补偿上下行时延后，
+// 按设备配置文件的时区与时间格式应答；请求附带设备时钟时同时记录时钟漂移
+func handleTimeSync(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error {
+	log.Info().Str("devEUI", devEUI).Msg("处理延迟测量请求")
 
-	// 【5】 构建最终的下行数据包：命令码 + 时间数据
-	payload := timeBytes
+	profile := h.timeProfileFor(devEUI)
+	sample := h.compensateTimeSync(uplink, msg.(protocol.TimeSyncRequest), profile.Location)
+	h.recordTimeSync(devEUI, sample)
+	ts, err := protocol.NewTimeSyncMessage(sample.Target.In(profile.Location), profile.Format)
+	if err != nil {
+		return fmt.Errorf("生成时间同步负载失败: %w", err)
+	}
 
-	// 【6】 日志
-	log.Info().
+	event := log.Info().
 		Str("devEUI", devEUI).
-		Time("nowCTS", nowCST).
-		Uint32("msSinceMidnight", msSinceMidnight).
-		Hex("payload", payload). // 以十六进制格式记录最终的数据包
-		Msg("准备发送时间同步下行数据")
+		Str("source", sample.Source).
+		Str("format", string(profile.Format)).
+		Time("target", sample.Target.In(profile.Location)).
+		Float64("processingDelayMs", sample.ProcessingDelayMs).
+		Float64("downlinkAirtimeMs", sample.DownlinkAirtimeMs).
+		Interface("payload", ts)
+	if sample.DriftMs != nil {
+		event = event.Int64("driftMs", *sample.DriftMs)
+	}
+	event.Msg("准备发送时间同步下行数据")
+	if delay := time.Since(sample.At); delay >= h.config.TimeSync.RxDelay {
+		log.Warn().Str("devEUI", devEUI).Dur("delay", delay).Msg("处理时延已超过 RX1 延迟，应答可能错过本次接收窗口")
+	}
 
-	downlinkID, err := h.csClient.SendDownlink(devEUI, 9, false, payload)
+	downlinkID, _, err := h.sendMessage(devEUI, false, ts)
 	if err != nil {
 		// 返回错误，由上层统一处理日志
 		return fmt.Errorf("发送下行消息失败: %w", err)
@@ -74,45 +88,42 @@ func handleTimeSync(h *Handler, devEUI string, data []byte) error {
 }
 
 // handleManualAlarm 处理人工报警 (原 case 0x07)
-func handleManualAlarm(h *Handler, devEUI string, data []byte) error {
+func handleManualAlarm(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error {
 	log.Info().Str("devEUI", devEUI).Msg("处理人工报警")
-	if err := h.statusClient.SendWarnInfo(devEUI, 1); err != nil {
-		return fmt.Errorf("转发人工报警到状态服务器失败: %w", err)
+	at := uplinkTime(uplink)
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.LastAlarm = &services.AlarmSample{WarnType: services.WarnManual, At: at}
+	})
+
+	queued, err := h.outbox.EnqueueWarnInfo(h.stakeNoOf(devEUI), services.WarnManual, at)
+	if err != nil {
+		return fmt.Errorf("人工报警写入发件箱失败: %w", err)
 	}
-	log.Info().Str("devEUI", devEUI).Msg("成功转发人工报警")
+	log.Info().Str("devEUI", devEUI).Str("outboxID", queued.ID).Msg("人工报警已写入发件箱")
 	return nil
 }
 
 // handleAccidentAlarm 处理事故报警 (原 case 0x08)
-func handleAccidentAlarm(h *Handler, devEUI string, data []byte) error {
+func handleAccidentAlarm(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error {
 	log.Info().Str("devEUI", devEUI).Msg("处理事故报警")
-	if err := h.statusClient.SendWarnInfo(devEUI, 2); err != nil {
-		return fmt.Errorf("转发事故报警到状态服务器失败: %w", err)
+	at := uplinkTime(uplink)
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.LastAlarm = &services.AlarmSample{WarnType: services.WarnAccident, At: at}
+	})
+
+	queued, err := h.outbox.EnqueueWarnInfo(h.stakeNoOf(devEUI), services.WarnAccident, at)
+	if err != nil {
+		return fmt.Errorf("事故报警写入发件箱失败: %w", err)
 	}
-	log.Info().Str("devEUI", devEUI).Msg("成功转发事故报警")
+	log.Info().Str("devEUI", devEUI).Str("outboxID", queued.ID).Msg("事故报警已写入发件箱")
 	return nil
 }
 
-// handleAccMonitor 打印加速度数值
-func handleAccMonitor(h *Handler, devEUI string, data []byte) error {
-	/*
-		for i, b := range data {
-			log.Info().Int("index", i).Str("byte", fmt.Sprintf("%02x", b)).Msg("解码后的数据")
-		}
-	*/
-	if len(data) < 7 {
-		log.Error().Str("devEUI", devEUI).Msg("加速度数据长度不足")
-		return fmt.Errorf("加速度数据长度不足")
-	}
-	// 小端序解析
-	x := int16(data[1]) | int16(data[2])<<8
-	y := int16(data[3]) | int16(data[4])<<8
-	z := int16(data[5]) | int16(data[6])<<8
-
-	scale := 0.061 / 1000
-	accX := float64(x) * scale
-	accY := float64(y) * scale
-	accZ := float64(z) * scale
+// handleAccMonitor 处理三维加速度上报，记录最新值、写入时间序列并检测碰撞
+func handleAccMonitor(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error {
+	acc := msg.(protocol.Acceleration)
+	x, y, z := acc.X, acc.Y, acc.Z
+	accX, accY, accZ := protocol.Axes(acc).G()
 
 	log.Info().
 		Str("devEUI", devEUI).
@@ -123,39 +134,126 @@ func handleAccMonitor(h *Handler, devEUI string, data []byte) error {
 		Float64("acc_Y_g", accY).
 		Float64("acc_Z_g", accZ).
 		Msg("收到三维加速度数据")
-	return nil
+
+	sample := services.AccelerationSample{X: accX, Y: accY, Z: accZ, At: uplinkTime(uplink)}
+	if err := h.acceleration.Append(devEUI, sample); err != nil {
+		log.Error().Err(err).Str("devEUI", devEUI).Msg("保存加速度采样失败")
+	}
+	return h.detectCollision(devEUI, sample)
 }
 
 // handleHeartbeat 处理心跳 (原 case 0x09)
-func handleHeartbeat(h *Handler, devEUI string, data []byte) error {
+func handleHeartbeat(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error {
 	log.Info().Str("devEUI", devEUI).Msg("处理心跳数据")
-	if err := h.statusClient.SendHeartbeat(devEUI); err != nil {
-		return fmt.Errorf("转发心跳到状态服务器失败: %w", err)
+	at := uplinkTime(uplink)
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.LastHeartbeat = &at
+	})
+
+	queued, err := h.outbox.EnqueueHeartbeat(h.stakeNoOf(devEUI), at)
+	if err != nil {
+		return fmt.Errorf("心跳写入发件箱失败: %w", err)
 	}
-	log.Info().Str("devEUI", devEUI).Msg("成功转发心跳")
+	log.Info().Str("devEUI", devEUI).Str("outboxID", queued.ID).Msg("心跳已写入发件箱")
+
+	h.reconcile(devEUI, "heartbeat")
 	return nil
 }
 
 // Handler 结构体持有所有依赖，如服务客户端
 type Handler struct {
-	csClient     *services.ChirpStackClient
-	statusClient *services.StatusServerClient
-	config       config.Config
+	csClient  *services.ChirpStackClient
+	registry  *services.DeviceRegistry
+	tracker   *services.DownlinkTracker
+	dedup     *services.UplinkDeduplicator
+	collision *services.CollisionDetector
+	uplinks   *services.WorkerPool
+	outbox    *services.Outbox
+	presence  *services.PresenceMonitor
+	state     *services.DeviceStateStore
+	desired   *services.DesiredStateStore
+	config    config.Config
+
+	acceleration  *services.AccelerationStore       // 加速度时间序列
+	tilt          *services.TiltStore               // 灯桩姿态时间序列
+	timeSync      *services.TimeSyncStore           // 时间同步记录
+	timeProfiles  timeProfiles                      // 按设备配置文件的时间同步设置
+	groups        *services.MulticastGroupDirectory // 多播组名称到 ChirpStack 多播组 UUID 的映射
+	configWaiters *configWaiters                    // 等待读取配置应答的调用方
 }
 
 // NewHandler 创建一个新的 Handler
-func NewHandler(cs *services.ChirpStackClient, ss *services.StatusServerClient, cfg config.Config) *Handler {
+func NewHandler(cs *services.ChirpStackClient, reg *services.DeviceRegistry, tracker *services.DownlinkTracker, uplinks *services.WorkerPool, outbox *services.Outbox, presence *services.PresenceMonitor, state *services.DeviceStateStore, desired *services.DesiredStateStore, groups *services.MulticastGroupDirectory, acceleration *services.AccelerationStore, tilt *services.TiltStore, timeSync *services.TimeSyncStore, cfg config.Config) *Handler {
+	profiles, _ := loadTimeProfiles(cfg.TimeSync) // 已在启动时校验
 	return &Handler{
-		csClient:     cs,
-		statusClient: ss,
-		config:       cfg,
+		csClient:  cs,
+		registry:  reg,
+		tracker:   tracker,
+		dedup:     services.NewUplinkDeduplicator(cfg.DedupWindow),
+		collision: services.NewCollisionDetector(cfg.Collision),
+		uplinks:   uplinks,
+		outbox:    outbox,
+		presence:  presence,
+		state:     state,
+		desired:   desired,
+		config:    cfg,
+
+		acceleration: acceleration,
+		tilt:         tilt,
+		timeSync:     timeSync,
+		timeProfiles: profiles,
+		groups:       groups,
+
+		configWaiters: newConfigWaiters(),
 	}
 }
 
+// resolveDevEUI 将桩号解析为 DevEUI。未登记但本身符合 DevEUI 格式的值按 DevEUI 直接使用，
+// 以兼容仍以 DevEUI 作为 stakeNo 的调用方
+func (h *Handler) resolveDevEUI(stakeNo string) (string, error) {
+	if devEUI, ok := h.registry.DevEUI(stakeNo); ok {
+		return devEUI, nil
+	}
+	if services.IsDevEUI(stakeNo) {
+		return stakeNo, nil
+	}
+	return "", newUnknownStakeError(stakeNo)
+}
+
+// sendDownlink 发送单播下行，并将返回的队列项 ID 交给下行跟踪器
+func (h *Handler) sendDownlink(devEUI string, fPort uint32, confirmed bool, data []byte) (string, error) {
+	id, err := h.csClient.SendDownlink(devEUI, fPort, confirmed, data)
+	if err != nil {
+		return "", err
+	}
+	h.tracker.Track(services.DownlinkRecord{
+		ID:        id,
+		DevEUI:    devEUI,
+		StakeNo:   h.stakeNoOf(devEUI),
+		FPort:     fPort,
+		Confirmed: confirmed,
+		Data:      data,
+	})
+	return id, nil
+}
+
+// stakeNoOf 返回 DevEUI 对应的桩号，未登记时返回 DevEUI 本身
+func (h *Handler) stakeNoOf(devEUI string) string {
+	if stakeNo, ok := h.registry.StakeNo(devEUI); ok {
+		return stakeNo
+	}
+	return devEUI
+}
+
 // RegisterRoutes 注册所有 API 路由
 func (h *Handler) RegisterRoutes(router *gin.Engine) {
-	// ChirpStack 事件回调
-	router.POST("/integration/uplink", h.handleChirpStackEvent)
+	// ChirpStack 事件回调，MQTT 接入方式下不对外暴露
+	if h.config.IntegrationMode != config.IntegrationMQTT {
+		router.POST("/integration/uplink", h.handleChirpStackEvent)
+	}
+
+	// Prometheus 指标
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// 外部 API
 	apiGroup := router.Group("/api")
@@ -168,10 +266,43 @@ func (h *Handler) RegisterRoutes(router *gin.Engine) {
 			lights.POST("/set-manner", h.handleSetManner)
 			lights.POST("/set-switch", h.handleSetSwitch)
 			lights.POST("/overall-setting", h.handleOverallSetting)
+			lights.POST("/read-config", h.handleReadConfig)
 			lights.POST("/set-multicast-group", h.handleSetMulticastGroup)
 		}
 		// 注册加速度检测开关接口
 		apiGroup.POST("/device/set-acceleration-mode", h.handleSetAccelerationMode)
+
+		// 设备注册表管理
+		devices := apiGroup.Group("/devices")
+		{
+			devices.GET("", h.handleListDevices)
+			devices.POST("", h.handleCreateDevice)
+			devices.POST("/import", h.handleImportDevices)
+			devices.GET("/export", h.handleExportDevices)
+			devices.GET("/:stakeNo", h.handleGetDevice)
+			devices.PUT("/:stakeNo", h.handleUpdateDevice)
+			devices.DELETE("/:stakeNo", h.handleDeleteDevice)
+			devices.GET("/:stakeNo/state", h.handleGetDeviceState)
+			devices.GET("/:stakeNo/acceleration", h.handleGetAcceleration)
+			devices.GET("/:stakeNo/acceleration/export", h.handleExportAcceleration)
+			devices.GET("/:stakeNo/tilt", h.handleGetTilt)
+			devices.POST("/:stakeNo/tilt/baseline", h.handleResetTiltBaseline)
+			devices.GET("/:stakeNo/time-sync", h.handleGetTimeSync)
+		}
+
+		// 下行投递状态查询
+		apiGroup.GET("/downlinks/:id", h.handleGetDownlink)
+		apiGroup.GET("/reconciliation", h.handleReconciliationReport)
+		apiGroup.GET("/time-sync/drift", h.handleClockDriftReport)
+		apiGroup.POST("/time-sync/multicast", h.handleMulticastTimeSync)
+
+		outbox := apiGroup.Group("/outbox")
+		{
+			outbox.GET("/pending", h.handleListOutboxPending)
+			outbox.GET("/dead-letters", h.handleListDeadLetters)
+			outbox.POST("/dead-letters/redrive", h.handleRedriveAllDeadLetters)
+			outbox.POST("/dead-letters/:id/redrive", h.handleRedriveDeadLetter)
+		}
 	}
 
 	// 新增：多播 API
@@ -185,460 +316,439 @@ func (h *Handler) RegisterRoutes(router *gin.Engine) {
 		multicastGroup.POST("/overall-setting", h.handleMulticastSetOverall)
 		multicastGroup.POST("/set-character", h.handleMulticastSetCharacter)
 		multicastGroup.POST("/set-brightness", h.handleMulticastSetBrightness)
+		multicastGroup.POST("/read-config", h.handleMulticastReadConfig)
+
+		// 多播组管理，变更同步到 ChirpStack 并写入多播组目录
+		multicastGroup.GET("", h.handleListMulticastGroups)
+		multicastGroup.POST("", h.handleCreateMulticastGroup)
+		multicastGroup.GET("/:groupId", h.handleGetMulticastGroup)
+		multicastGroup.PUT("/:groupId", h.handleUpdateMulticastGroup)
+		multicastGroup.DELETE("/:groupId", h.handleDeleteMulticastGroup)
+		multicastGroup.GET("/:groupId/devices", h.handleListMulticastGroupDevices)
+		multicastGroup.POST("/:groupId/devices", h.handleAddMulticastGroupDevices)
+		multicastGroup.DELETE("/:groupId/devices/:stakeNo", h.handleRemoveMulticastGroupDevice)
 	}
 
 }
 
-// handleChirpStackEvent 处理来自 ChirpStack 的上行数据
+// handleChirpStackEvent 处理来自 ChirpStack 的集成事件回调
 func (h *Handler) handleChirpStackEvent(c *gin.Context) {
 	event := c.Query("event")
-	if event != "up" {
-		log.Warn().Str("event", event).Msg("接收到非 up 事件，已忽略")
-		c.JSON(http.StatusOK, gin.H{"message": "event ignored"})
+	body, err := c.GetRawData()
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("读取事件请求体失败")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
 		return
 	}
 
-	var uplink UplinkEvent
-	if err := c.ShouldBindJSON(&uplink); err != nil {
-		log.Error().Err(err).Msg("解析上行事件 JSON 失败")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
-		return
+	payload := eventPayload{Body: body, Protobuf: isProtobufContentType(c.ContentType())}
+	err = h.dispatchEvent(event, payload)
+	switch {
+	case errors.Is(err, errInvalidEvent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+	case errors.Is(err, services.ErrQueueFull), errors.Is(err, services.ErrPoolStopped):
+		// 返回 503 让 ChirpStack 稍后重推
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "uplink queue unavailable"})
+	default:
+		c.Status(http.StatusOK)
 	}
+}
 
-	devEUI := uplink.DeviceInfo.DevEui
-	log.Info().Str("devEUI", devEUI).Msg("收到上行数据")
+// unicastDownlink 描述批量单播命令中的一条下行
+type unicastDownlink struct {
+	StakeNo   string
+	Confirmed bool
+	Message   protocol.Message
+	Name      string // 命令名称，仅用于日志
+}
 
-	decodedData, err := base64.StdEncoding.DecodeString(uplink.Data)
-	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Msg("Base64 解码失败")
-		return
+// bindUnicastCommands 解析批量单播请求体，失败时直接写回 400 响应
+func bindUnicastCommands[T any](c *gin.Context, commands *[]T) bool {
+	if err := c.ShouldBindJSON(commands); err != nil {
+		respondValidationError(c, err.Error())
+		return false
 	}
+	if len(*commands) == 0 {
+		respondValidationError(c, "Request body must contain at least one command.")
+		return false
+	}
+	return true
+}
 
-	if len(decodedData) == 0 {
-		log.Warn().Str("devEUI", devEUI).Msg("数据负载为空")
-		return
+// sendUnicastBatch 以有限并发发送一批单播下行，结果顺序与输入一致
+func (h *Handler) sendUnicastBatch(downlinks []unicastDownlink) []StakeResult {
+	results := make([]StakeResult, len(downlinks))
+
+	concurrency := h.config.DownlinkConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
 	}
-	/*
-		for i, b := range decodedData {
-			log.Info().Int("index", i).Str("byte", fmt.Sprintf("%02x", b)).Msg("解码后的数据")
-		}
-	*/
-	cmdCode := decodedData[0]
-	handlerFunc, found := commandHandlers[cmdCode]
+	sem := make(chan struct{}, concurrency)
 
-	// log.Info().Int("cmdCode", int(cmdCode)).Msg("收到命令码")
+	var wg sync.WaitGroup
+	for i, dl := range downlinks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-	if !found {
-		log.Warn().Int("cmdCode", int(cmdCode)).Str("devEUI", devEUI).Msg("未知的命令码")
-		c.Status(http.StatusOK)
-		return
+			results[i] = h.sendUnicast(dl)
+		}()
 	}
+	wg.Wait()
+
+	return results
+}
+
+// sendUnicast 发送单条单播下行并生成对应的结果
+func (h *Handler) sendUnicast(dl unicastDownlink) StakeResult {
+	result := StakeResult{StakeNo: dl.StakeNo}
 
-	// 3. 执行具体的处理器
-	if err := handlerFunc(h, devEUI, decodedData); err != nil {
-		// 处理器内部已经记录了详细错误，这里只记录分派层面的失败信息
-		log.Error().Err(err).Str("devEUI", devEUI).Int("cmdCode", int(cmdCode)).Msg("命令处理失败")
+	var id string
+	var data []byte
+	devEUI, err := h.resolveDevEUI(dl.StakeNo)
+	if err == nil {
+		id, data, err = h.sendMessage(devEUI, dl.Confirmed, dl.Message)
 	}
-	c.Status(http.StatusOK)
+	if err != nil {
+		result.Code, result.Reason = errorStatus(err)
+		result.Error = errorMessage(err)
+		log.Error().Err(err).Str("stakeNo", dl.StakeNo).Str("devEUI", devEUI).Hex("payload", data).Str("reason", result.Reason).Msg("发送" + dl.Name + "失败")
+		return result
+	}
+
+	h.recordDesired(devEUI, dl.Message)
+
+	result.Code, result.Reason = http.StatusOK, reasonOK
+	result.DownlinkID = id
+	log.Info().Str("stakeNo", dl.StakeNo).Str("devEUI", devEUI).Hex("payload", data).Str("downlinkID", id).Msg(dl.Name + "下行已发送")
+	return result
 }
 
 // handleSetColor 处理设置颜色请求
 func (h *Handler) handleSetColor(c *gin.Context) {
-	// log.Info().Msg("here")
 	var commands []SetColorCommand
-	if err := c.ShouldBindJSON(&commands); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+	if !bindUnicastCommands(c, &commands) {
 		return
 	}
 
-	// 只能处理一个命令
-	if len(commands) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Request body must contain at least one command."})
-		return
+	downlinks := make([]unicastDownlink, 0, len(commands))
+	for _, cmd := range commands {
+		downlinks = append(downlinks, unicastDownlink{
+			StakeNo:   cmd.StakeNo,
+			Confirmed: cmd.Confirmed,
+			Message:   protocol.Color{Color: uint8(cmd.Color)},
+			Name:      "颜色设置",
+		})
 	}
-	cmd := commands[0]
-
-	// 取出控制命令参数
-	devEUI := cmd.StakeNo
-	data := []byte{byte(cmd.Color)}
-	id, err := h.csClient.SendDownlink(devEUI, 11, false, data)
-	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Msg("发送颜色设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to send downlink."})
-		return
-	}
-	log.Info().Str("devEUI", devEUI).Int("color", cmd.Color).Str("downlinkID", id).Msg("颜色设置下行已发送")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Color setting applied successfully."})
+	respondBatch(c, h.sendUnicastBatch(downlinks), "Color setting applied successfully.")
 }
 
 // handleSetFrequency 处理设置频率请求
 func (h *Handler) handleSetFrequency(c *gin.Context) {
 	var commands []SetFrequencyCommand
-	if err := c.ShouldBindJSON(&commands); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+	if !bindUnicastCommands(c, &commands) {
 		return
 	}
-	freqMap := map[int]byte{30: 0x1E, 60: 0x3C, 120: 0x78}
-
-	// 只能处理一个命令
-	if len(commands) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Request body must contain at least one command."})
-		return
-	}
-	cmd := commands[0]
-
-	// 取出控制命令参数
-	devEUI := cmd.StakeNo
-	data := []byte{freqMap[cmd.Frequency]}
-	id, err := h.csClient.SendDownlink(devEUI, 10, false, data)
-	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Msg("发送频率设置失败")
+	downlinks := make([]unicastDownlink, 0, len(commands))
+	for _, cmd := range commands {
+		downlinks = append(downlinks, unicastDownlink{
+			StakeNo:   cmd.StakeNo,
+			Confirmed: cmd.Confirmed,
+			Message:   protocol.Frequency{PerMinute: cmd.Frequency},
+			Name:      "频率设置",
+		})
 	}
-	log.Info().Str("devEUI", devEUI).Int("frequency", cmd.Frequency).Str("downlinkID", id).Msg("频率设置下行已发送")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Frequency setting applied successfully."})
+	respondBatch(c, h.sendUnicastBatch(downlinks), "Frequency setting applied successfully.")
 }
 
 // handleSetLevel 处理设置亮度请求
 func (h *Handler) handleSetLevel(c *gin.Context) {
 	var commands []SetLevelCommand
-	if err := c.ShouldBindJSON(&commands); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
-		return
-	}
-	// 只能处理一个命令
-	if len(commands) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Request body must contain at least one command."})
+	if !bindUnicastCommands(c, &commands) {
 		return
 	}
-	// 取出控制命令参数
-	cmd := commands[0]
 
-	devEUI := cmd.StakeNo
-	highByte := byte(cmd.Level >> 8 & 0xFF)
-	lowByte := byte(cmd.Level & 0xFF)
-	data := []byte{highByte, lowByte}
-	id, err := h.csClient.SendDownlink(devEUI, 13, false, data)
-	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Msg("发送亮度设置失败")
+	downlinks := make([]unicastDownlink, 0, len(commands))
+	for _, cmd := range commands {
+		downlinks = append(downlinks, unicastDownlink{
+			StakeNo:   cmd.StakeNo,
+			Confirmed: cmd.Confirmed,
+			Message:   protocol.Level{Level: uint16(cmd.Level)},
+			Name:      "亮度设置",
+		})
 	}
-	log.Info().Str("devEUI", devEUI).Int("level", cmd.Level).Str("downlinkID", id).Msg("亮度设置下行已发送")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Level setting applied successfully."})
+	respondBatch(c, h.sendUnicastBatch(downlinks), "Level setting applied successfully.")
 }
 
 // handleSetManner 处理设置亮灯方式请求
 func (h *Handler) handleSetManner(c *gin.Context) {
 	var commands []SetMannerCommand
-	if err := c.ShouldBindJSON(&commands); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+	if !bindUnicastCommands(c, &commands) {
 		return
 	}
 
-	// 只能处理一个命令
-	if len(commands) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Request body must contain at least one command."})
-		return
-	}
-	// 取出控制命令参数
-	cmd := commands[0]
-	devEUI := cmd.StakeNo
-	data := []byte{byte(cmd.Manner)}
-	id, err := h.csClient.SendDownlink(devEUI, 12, false, data)
-	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Msg("发送亮灯方式设置失败")
+	downlinks := make([]unicastDownlink, 0, len(commands))
+	for _, cmd := range commands {
+		downlinks = append(downlinks, unicastDownlink{
+			StakeNo:   cmd.StakeNo,
+			Confirmed: cmd.Confirmed,
+			Message:   protocol.Manner{Manner: uint8(cmd.Manner)},
+			Name:      "亮灯方式设置",
+		})
 	}
-	log.Info().Str("devEUI", devEUI).Int("manner", cmd.Manner).Str("downlinkID", id).Msg("亮灯方式设置下行已发送")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Manner setting applied successfully."})
+	respondBatch(c, h.sendUnicastBatch(downlinks), "Manner setting applied successfully.")
 }
 
 // handleSetSwitch 处理设置开关请求
 func (h *Handler) handleSetSwitch(c *gin.Context) {
 	var commands []SetSwitchCommand
-	if err := c.ShouldBindJSON(&commands); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+	if !bindUnicastCommands(c, &commands) {
 		return
 	}
-	// 只能处理一个命令
-	if len(commands) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Request body must contain at least one command."})
-		return
-	}
-	// 取出控制命令参数
-	cmd := commands[0]
-	devEUI := cmd.StakeNo
-	data := []byte{byte(cmd.Switch)}
-	id, err := h.csClient.SendDownlink(devEUI, 14, false, data)
-	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Msg("发送开关设置失败")
+
+	downlinks := make([]unicastDownlink, 0, len(commands))
+	for _, cmd := range commands {
+		downlinks = append(downlinks, unicastDownlink{
+			StakeNo:   cmd.StakeNo,
+			Confirmed: cmd.Confirmed,
+			Message:   protocol.Switch{Switch: uint8(cmd.Switch)},
+			Name:      "开关设置",
+		})
 	}
-	log.Info().Str("devEUI", devEUI).Int("switch", cmd.Switch).Str("downlinkID", id).Msg("开关设置下行已发送")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Switch setting applied successfully."})
+	respondBatch(c, h.sendUnicastBatch(downlinks), "Switch setting applied successfully.")
 }
 
 // handleOverallSetting 处理整体设置请求
 func (h *Handler) handleOverallSetting(c *gin.Context) {
 	var commands []OverallSettingCommand
-	if err := c.ShouldBindJSON(&commands); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
-		return
-	}
-	freqMap := map[int]byte{30: 0x1E, 60: 0x3C, 120: 0x78}
-
-	// 只能处理一个命令
-	if len(commands) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Request body must contain at least one command."})
-		return
-	}
-	// 取出控制命令参数
-	cmd := commands[0]
-	devEUI := cmd.StakeNo
-	payload := []byte{
-		byte(cmd.Color),
-		freqMap[cmd.Frequency],
-		byte(cmd.Level >> 8 & 0xFF),
-		byte(cmd.Level & 0xFF),
-		byte(cmd.Manner),
-		byte(cmd.RadarEnable),
-	}
-
-	id, err := h.csClient.SendDownlink(devEUI, 15, false, payload)
-	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Msg("发送整体设置失败")
-	}
-	log.Info().Str("devEUI", devEUI).Str("downlinkID", id).Msg("整体设置下行已发送")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Overall setting applied successfully."})
+	if !bindUnicastCommands(c, &commands) {
+		return
+	}
+	downlinks := make([]unicastDownlink, 0, len(commands))
+	for _, cmd := range commands {
+		downlinks = append(downlinks, unicastDownlink{
+			StakeNo:   cmd.StakeNo,
+			Confirmed: cmd.Confirmed,
+			Message: protocol.Overall{
+				Color:       uint8(cmd.Color),
+				Frequency:   cmd.Frequency,
+				Level:       uint16(cmd.Level),
+				Manner:      uint8(cmd.Manner),
+				RadarEnable: uint8(cmd.RadarEnable),
+			},
+			Name: "整体设置",
+		})
+	}
+	respondBatch(c, h.sendUnicastBatch(downlinks), "Overall setting applied successfully.")
 }
 
 // handleMulticastSetColor 处理多播组的颜色设置请求
 func (h *Handler) handleMulticastSetColor(c *gin.Context) {
 	var cmd MulticastSetColorCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
 	// 从映射中查找多播组 UUID
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	// 准备数据包并发送
-	data := []byte{byte(cmd.Color)}
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 11, data)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.Color{Color: uint8(cmd.Color)})
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播颜色设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	// log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("color", cmd.Color).Str("downlinkID", id).Msg("多播颜色设置已入队")
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("color", cmd.Color).Msg("多播颜色设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast color setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("color", cmd.Color).Uint32("fCnt", fCnt).Msg("多播颜色设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast color setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleMulticastSetFrequency 处理多播组的频率设置请求
 func (h *Handler) handleMulticastSetFrequency(c *gin.Context) {
 	var cmd MulticastSetFrequencyCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	freqMap := map[int]byte{30: 0x1E, 60: 0x3C, 120: 0x78}
-	data := []byte{freqMap[cmd.Frequency]}
-
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 10, data)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.Frequency{PerMinute: cmd.Frequency})
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播频率设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	// log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("frequency", cmd.Frequency).Str("downlinkID", id).Msg("多播频率设置已入队")
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("frequency", cmd.Frequency).Msg("多播频率设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast frequency setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("frequency", cmd.Frequency).Uint32("fCnt", fCnt).Msg("多播频率设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast frequency setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleMulticastSetLevel 处理多播组的亮度设置请求
 func (h *Handler) handleMulticastSetLevel(c *gin.Context) {
 	var cmd MulticastSetLevelCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	highByte := byte(cmd.Level >> 8 & 0xFF)
-	lowByte := byte(cmd.Level & 0xFF)
-	data := []byte{highByte, lowByte}
-
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 13, data)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.Level{Level: uint16(cmd.Level)})
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播亮度设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	// log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("level", cmd.Level).Str("downlinkID", id).Msg("多播亮度设置已入队")
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("level", cmd.Level).Msg("多播亮度设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast level setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("level", cmd.Level).Uint32("fCnt", fCnt).Msg("多播亮度设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast level setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleMulticastSetManner 处理多播组的亮灯方式设置请求
 func (h *Handler) handleMulticastSetManner(c *gin.Context) {
 	var cmd MulticastSetMannerCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	data := []byte{byte(cmd.Manner)}
-
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 12, data)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.Manner{Manner: uint8(cmd.Manner)})
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播亮灯方式设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	// log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Manner", cmd.Manner).Str("downlinkID", id).Msg("多播亮度设置已入队")
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Manner", cmd.Manner).Msg("多播亮灯方式设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast level setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Manner", cmd.Manner).Uint32("fCnt", fCnt).Msg("多播亮灯方式设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast manner setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleMulticastSetSwitch 处理多播组开关设置请求
 func (h *Handler) handleMulticastSetSwitch(c *gin.Context) {
 	var cmd MulticastSetSwitchCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	data := []byte{byte(cmd.Switch)}
-
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 14, data)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.Switch{Switch: uint8(cmd.Switch)})
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播开关设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	// log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Switch", cmd.Switch).Str("downlinkID", id).Msg("多播亮度设置已入队")
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Switch", cmd.Switch).Msg("多播开关设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast level setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Switch", cmd.Switch).Uint32("fCnt", fCnt).Msg("多播开关设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast switch setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleMulticastSetCharacter 处理多播组的字符设置请求
 func (h *Handler) handleMulticastSetCharacter(c *gin.Context) {
 	var cmd MulticastCharacterCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	data := []byte{byte(cmd.Switch)}
-
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 18, data)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.Character{Switch: uint8(cmd.Switch)})
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播字符设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	// log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Switch", cmd.Switch).Str("downlinkID", id).Msg("多播亮度设置已入队")
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Switch", cmd.Switch).Msg("多播字符设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast character setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Switch", cmd.Switch).Uint32("fCnt", fCnt).Msg("多播字符设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast character setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleMulticastSetBrightness 处理多播组的亮度设置请求
 func (h *Handler) handleMulticastSetBrightness(c *gin.Context) {
 	var cmd MulticastSetBrightnessCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	data := []byte{byte(cmd.Brightness)}
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 19, data)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.Brightness{Brightness: uint8(cmd.Brightness)})
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播亮度设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Brightness", cmd.Brightness).Msg("多播亮度设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast brightness setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Brightness", cmd.Brightness).Uint32("fCnt", fCnt).Msg("多播亮度设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast brightness setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleMulticastSetOverall 处理多播组总体设置请求
 func (h *Handler) handleMulticastSetOverall(c *gin.Context) {
 	var cmd MulticastOverallSettingCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
-	multicastGroupID, found := h.config.MulticastGroups[cmd.GroupID]
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
 	if !found {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Unknown groupId: " + cmd.GroupID})
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
 		return
 	}
 
-	// data := []byte{byte(cmd.Switch)}
-	freqMap := map[int]byte{30: 0x1E, 60: 0x3C, 120: 0x78}
-	payload := []byte{
-		byte(cmd.Color),
-		freqMap[cmd.Frequency],
-		byte(cmd.Level >> 8 & 0xFF),
-		byte(cmd.Level & 0xFF),
-		byte(cmd.Manner),
-		byte(cmd.RadarEnable),
+	overall := protocol.Overall{
+		Color:       uint8(cmd.Color),
+		Frequency:   cmd.Frequency,
+		Level:       uint16(cmd.Level),
+		Manner:      uint8(cmd.Manner),
+		RadarEnable: uint8(cmd.RadarEnable),
 	}
-	_, err := h.csClient.EnqueueMulticast(multicastGroupID, 15, payload)
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, overall)
 	if err != nil {
 		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播总体设置失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to enqueue multicast downlink."})
+		respondError(c, err, "Failed to enqueue multicast downlink.")
 		return
 	}
 
-	// log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Str("downlinkID", id).Msg("多播亮度设置已入队")
-	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Color", cmd.Color).Int("Frequency", cmd.Frequency).Int("Level", cmd.Level).Int("Manner", cmd.Manner).Int("RadarEnable", cmd.RadarEnable).Msg("多播总体设置已入队")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast level setting enqueued successfully."})
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("Color", cmd.Color).Int("Frequency", cmd.Frequency).Int("Level", cmd.Level).Int("Manner", cmd.Manner).Int("RadarEnable", cmd.RadarEnable).Uint32("fCnt", fCnt).Msg("多播总体设置已入队")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast overall setting enqueued successfully.", "data": gin.H{"fCnt": fCnt}})
 }
 
 // handleSetMulticastGroup 处理设置设备加入多播组的请求 (单播)
@@ -646,43 +756,45 @@ func (h *Handler) handleSetMulticastGroup(c *gin.Context) {
 	var cmd SetMulticastGroupCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
 		log.Error().Err(err).Msg("解析设置多播组请求 JSON 失败")
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid request: " + err.Error()})
+		respondValidationError(c, "Invalid request: "+err.Error())
 		return
 	}
 
-	devEUI := cmd.StakeNo // 使用 StakeNo 作为设备的 DevEUI
+	devEUI, err := h.resolveDevEUI(cmd.StakeNo)
+	if err != nil {
+		respondError(c, err, "Failed to resolve stakeNo.")
+		return
+	}
 
 	// 将十六进制字符串解码为字节数组
 	devAddrBytes, err := hex.DecodeString(cmd.DevAddr)
 	if err != nil {
 		log.Error().Err(err).Str("devEUI", devEUI).Str("devAddr", cmd.DevAddr).Msg("DevAddr 十六进制解码失败")
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid DevAddr format or length."})
+		respondValidationError(c, "Invalid DevAddr format or length.")
 		return
 	}
 	appSKeyBytes, err := hex.DecodeString(cmd.AppSKey)
 	if err != nil {
 		log.Error().Err(err).Str("devEUI", devEUI).Str("appSKey", cmd.AppSKey).Msg("AppSKey 十六进制解码失败")
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid AppSKey format or length."})
+		respondValidationError(c, "Invalid AppSKey format or length.")
 		return
 	}
 	nwkSKeyBytes, err := hex.DecodeString(cmd.NwkSKey)
 	if err != nil {
 		log.Error().Err(err).Str("devEUI", devEUI).Str("nwkSKey", cmd.NwkSKey).Msg("NwkSKey 十六进制解码失败")
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "Invalid NwkSKey format or length."})
+		respondValidationError(c, "Invalid NwkSKey format or length.")
 		return
 	}
 
-	// Payload 结构：[DevAddr (4字节)] + [AppSKey (16字节)] + [NwkSKey (16字节)]
-	// 总长度 = 4 + 16 + 16 = 36 字节
-	payload := make([]byte, len(devAddrBytes)+len(appSKeyBytes)+len(nwkSKeyBytes)) // 预分配 payload 空间
-	copy(payload[0:], devAddrBytes)
-	copy(payload[len(devAddrBytes):], appSKeyBytes)
-	copy(payload[len(devAddrBytes)+len(appSKeyBytes):], nwkSKeyBytes)
+	var keys protocol.MulticastKeys
+	copy(keys.DevAddr[:], devAddrBytes)
+	copy(keys.AppSKey[:], appSKeyBytes)
+	copy(keys.NwkSKey[:], nwkSKeyBytes)
 
-	id, err := h.csClient.SendDownlink(devEUI, 16, false, payload) // 使用 SendDownlink 进行单播
+	id, _, err := h.sendMessage(devEUI, cmd.Confirmed, keys)
 	if err != nil {
-		log.Error().Err(err).Str("devEUI", devEUI).Hex("payload", payload).Msg("发送设置多播组下行消息失败")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to send downlink."})
+		log.Error().Err(err).Str("devEUI", devEUI).Msg("发送设置多播组下行消息失败")
+		respondError(c, err, "Failed to send downlink.")
 		return
 	}
 
@@ -691,31 +803,38 @@ func (h *Handler) handleSetMulticastGroup(c *gin.Context) {
 		Str("downlinkID", id).
 		Str("devAddr", cmd.DevAddr).
 		Msg("设置多播组下行消息已发送")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast group setting applied successfully."})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast group setting applied successfully.", "data": gin.H{"downlinkId": id}})
 }
 
 // handleSetAccelerationMode 处理加速度检测开关请求
 func (h *Handler) handleSetAccelerationMode(c *gin.Context) {
 	var cmd SetAccelerationModeCommand
 	if err := c.ShouldBindJSON(&cmd); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		respondValidationError(c, err.Error())
 		return
 	}
-	if cmd.DevEUI == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "devEUI cannot be empty！"})
+	if cmd.DevEUI == "" && cmd.StakeNo == "" {
+		respondValidationError(c, "devEUI or stakeNo cannot be empty！")
 		return
 	}
 	if cmd.Enable != 0 && cmd.Enable != 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "enable can only be 0 or 1"})
+		respondValidationError(c, "enable can only be 0 or 1")
 		return
 	}
-	data := []byte{byte(cmd.Enable)}
-	id, err := h.csClient.SendDownlink(cmd.DevEUI, 17, false, data)
+	devEUI := cmd.DevEUI
+	if devEUI == "" {
+		var err error
+		if devEUI, err = h.resolveDevEUI(cmd.StakeNo); err != nil {
+			respondError(c, err, "Failed to resolve stakeNo.")
+			return
+		}
+	}
+	id, _, err := h.sendMessage(devEUI, cmd.Confirmed, protocol.AccelerationMode{Enable: uint8(cmd.Enable)})
 	if err != nil {
-		log.Error().Err(err).Str("devEUI", cmd.DevEUI).Msg("The command to send the acceleration detection switch failed")
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "Failed to send downlink."})
+		log.Error().Err(err).Str("devEUI", devEUI).Msg("The command to send the acceleration detection switch failed")
+		respondError(c, err, "Failed to send downlink.")
 		return
 	}
-	log.Info().Str("devEUI", cmd.DevEUI).Int("enable", cmd.Enable).Str("downlinkID", id).Msg("The acceleration detection switch command has been sent")
-	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "instruction send"})
+	log.Info().Str("devEUI", devEUI).Int("enable", cmd.Enable).Str("downlinkID", id).Msg("The acceleration detection switch command has been sent")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "instruction send", "data": gin.H{"downlinkId": id}})
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/api"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeChirpStack 是进程内的 ChirpStack gRPC 服务，记录收到的下行，并按 DevEUI 或多播组 ID 返回预设的错误
+type fakeChirpStack struct {
+	mu        sync.Mutex
+	errs      map[string]error
+	unicast   []*api.DeviceQueueItem
+	multicast []*api.MulticastGroupQueueItem
+}
+
+type fakeDeviceService struct {
+	api.UnimplementedDeviceServiceServer
+	*fakeChirpStack
+}
+
+func (s fakeDeviceService) Enqueue(_ context.Context, req *api.EnqueueDeviceQueueItemRequest) (*api.EnqueueDeviceQueueItemResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := req.GetQueueItem()
+	if err := s.errs[item.GetDevEui()]; err != nil {
+		return nil, err
+	}
+	s.unicast = append(s.unicast, item)
+	return &api.EnqueueDeviceQueueItemResponse{Id: "dl-" + item.GetDevEui()}, nil
+}
+
+type fakeMulticastService struct {
+	api.UnimplementedMulticastGroupServiceServer
+	*fakeChirpStack
+}
+
+func (s fakeMulticastService) Enqueue(_ context.Context, req *api.EnqueueMulticastGroupQueueItemRequest) (*api.EnqueueMulticastGroupQueueItemResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := req.GetQueueItem()
+	if err := s.errs[item.GetMulticastGroupId()]; err != nil {
+		return nil, err
+	}
+	s.multicast = append(s.multicast, item)
+	return &api.EnqueueMulticastGroupQueueItemResponse{FCnt: uint32(len(s.multicast))}, nil
+}
+
+// newFakeChirpStack 启动 fakeChirpStack，返回连接到它的客户端
+func newFakeChirpStack(t *testing.T, errs map[string]error) (*fakeChirpStack, *services.ChirpStackClient) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeChirpStack{errs: errs}
+	server := grpc.NewServer()
+	api.RegisterDeviceServiceServer(server, fakeDeviceService{fakeChirpStack: fake})
+	api.RegisterMulticastGroupServiceServer(server, fakeMulticastService{fakeChirpStack: fake})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	client, err := services.NewChirpStackClient(config.Config{ChirpStackServer: lis.Addr().String(), GRPCTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, client
+}
+
+// newUnicastTestHandler 创建下发单播命令所需的 Handler，K1、K2 已登记
+func newUnicastTestHandler(t *testing.T, errs map[string]error) (*Handler, *fakeChirpStack) {
+	t.Helper()
+
+	dir := t.TempDir()
+	fake, client := newFakeChirpStack(t, errs)
+	registry, err := services.NewDeviceRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []services.Device{{StakeNo: "K1", DevEUI: "0000000000000001"}, {StakeNo: "K2", DevEUI: "0000000000000002"}} {
+		if err := registry.Create(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := services.NewDeviceStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := services.NewDownlinkTracker(dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired, err := services.NewDesiredStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		csClient: client,
+		registry: registry,
+		tracker:  tracker,
+		state:    state,
+		desired:  desired,
+		config:   config.Config{ProtocolVersion: 1, DownlinkConcurrency: 2},
+	}, fake
+}
+
+func TestHandleSetColorBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h, fake := newUnicastTestHandler(t, map[string]error{
+		"0000000000000002": status.Error(codes.Unavailable, "network server unavailable"),
+	})
+	router := gin.New()
+	router.POST("/color", h.handleSetColor)
+
+	body := `[
+		{"stakeNo": "K1", "color": 1},
+		{"stakeNo": "K2", "color": 0},
+		{"stakeNo": "K404", "color": 1},
+		{"stakeNo": "00000000000000ff", "color": 0, "confirmed": true}
+	]`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/color", strings.NewReader(body)))
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("状态码 %d，期望 207: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Data []StakeResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// 每条命令都被处理，结果顺序与请求一致
+	want := []StakeResult{
+		{StakeNo: "K1", Code: http.StatusOK, Reason: reasonOK, DownlinkID: "dl-0000000000000001"},
+		{StakeNo: "K2", Code: http.StatusServiceUnavailable, Reason: "Unavailable", Error: "network server unavailable"},
+		{StakeNo: "K404", Code: http.StatusNotFound, Reason: reasonUnknownStake, Error: "unknown stakeNo: K404"},
+		{StakeNo: "00000000000000ff", Code: http.StatusOK, Reason: reasonOK, DownlinkID: "dl-00000000000000ff"},
+	}
+	if !reflect.DeepEqual(resp.Data, want) {
+		t.Fatalf("结果 %+v，期望 %+v", resp.Data, want)
+	}
+
+	if len(fake.unicast) != 2 {
+		t.Fatalf("ChirpStack 收到 %d 条下行，期望 2 条", len(fake.unicast))
+	}
+	for _, item := range fake.unicast {
+		if item.GetDevEui() == "00000000000000ff" && !item.GetConfirmed() {
+			t.Errorf("%s 的下行未以确认帧发送", item.GetDevEui())
+		}
+	}
+}
+
+func TestHandleSetColorInvalidBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h, fake := newUnicastTestHandler(t, nil)
+	router := gin.New()
+	router.POST("/color", h.handleSetColor)
+
+	for _, body := range []string{`[]`, `{"stakeNo": "K1", "color": 1}`, `[{"stakeNo": "K1", "color": 2}]`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/color", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("请求体 %s: 状态码 %d，期望 400", body, w.Code)
+		}
+	}
+	if len(fake.unicast) != 0 {
+		t.Fatalf("请求体无效时仍发送了 %d 条下行", len(fake.unicast))
+	}
+}
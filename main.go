@@ -2,10 +2,17 @@ package main
 
 import (
 	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/protocol"
 	"chirpstack-httpserver/services"
+	"context"
+	"errors"
+	"net/http"
 	"os"
-
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // 内嵌时区数据库，时间同步时区不依赖宿主机的 zoneinfo
 
 	"github.com/gin-gonic/gin"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
@@ -14,12 +21,12 @@ import (
 )
 
 func main() {
+	// 子命令：离线导入/导出设备台账
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
 	// 日志轮转：每天零点轮转，文件名为 httpserver.log.2025-07-04，主日志为 httpserver.log
-	// location, err := time.LoadLocation("Asia/Shanghai")
-	// if err != nil {
-	// 	log.Fatal().Err(err).Msg("无法加载上海时区")
-	// }
 	rotator, err := rotatelogs.New(
 		"httpserver.log.%Y-%m-%d",
 		rotatelogs.WithLinkName("httpserver.log"), // 始终指向当前日志
@@ -44,8 +51,63 @@ func main() {
 
 	// 加载配置
 	cfg := config.LoadConfig()
+	if _, err := protocol.ForVersion(protocol.Version(cfg.ProtocolVersion)); err != nil {
+		log.Fatal().Err(err).Msg("默认协议版本无效")
+	}
+	if _, err := loadTimeProfiles(cfg.TimeSync); err != nil {
+		log.Fatal().Err(err).Msg("时间同步的时区或时间格式配置无效")
+	}
 	log.Info().Msg("配置加载成功")
 
+	// 加载桩号与 DevEUI 的设备注册表
+	registry, err := services.NewDeviceRegistry(cfg.DataDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法加载设备注册表")
+	}
+	log.Info().Int("devices", registry.Len()).Msg("设备注册表加载成功")
+
+	// 加载下行投递记录
+	tracker, err := services.NewDownlinkTracker(cfg.DataDir, cfg.DownlinkTTL, cfg.DownlinkRetention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法加载下行记录")
+	}
+
+	// 加载设备状态
+	deviceState, err := services.NewDeviceStateStore(cfg.DataDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法加载设备状态")
+	}
+
+	// 加载按设备和多播组设置的期望配置
+	desired, err := services.NewDesiredStateStore(cfg.DataDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法加载期望配置")
+	}
+
+	// 加载多播组目录，首次启动时以配置中的 multicast_groups 初始化
+	groups, err := services.NewMulticastGroupDirectory(cfg.DataDir, cfg.MulticastGroups)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法加载多播组目录")
+	}
+
+	// 打开加速度时间序列库
+	acceleration, err := services.NewAccelerationStore(cfg.DataDir, cfg.AccelerationRetention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法打开加速度时间序列库")
+	}
+
+	// 打开灯桩姿态时间序列库
+	tilt, err := services.NewTiltStore(cfg.DataDir, cfg.Tilt.Retention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法打开姿态时间序列库")
+	}
+
+	// 打开时间同步记录库
+	timeSync, err := services.NewTimeSyncStore(cfg.DataDir, cfg.TimeSync.Retention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法打开时间同步记录库")
+	}
+
 	// 初始化 ChirpStack 客户端
 	csClient, err := services.NewChirpStackClient(cfg)
 	if err != nil {
@@ -57,17 +119,138 @@ func main() {
 	statusClient := services.NewStatusServerClient(cfg)
 	log.Info().Str("url", cfg.StatusServerURL).Msg("状态服务器客户端初始化成功")
 
+	// 加载状态服务器通知发件箱
+	outbox, err := services.NewOutbox(cfg.DataDir, statusClient, map[services.OutboxKind]time.Duration{
+		services.OutboxWarnInfo:  cfg.OutboxWarnTTL,
+		services.OutboxHeartbeat: cfg.OutboxHeartbeatTTL,
+	}, cfg.OutboxMinBackoff, cfg.OutboxMaxBackoff, cfg.OutboxConcurrency)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法加载发件箱")
+	}
+	log.Info().Int("pending", len(outbox.Pending())).Int("dead", len(outbox.DeadLetters())).Msg("发件箱加载成功")
+
+	// 收到退出信号时停止后台任务并关闭 HTTP 服务
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tracker.Run(ctx, 5*time.Second)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		deviceState.Run(ctx, 5*time.Second)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		outbox.Run(ctx, time.Second)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		acceleration.Run(ctx, time.Hour)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tilt.Run(ctx, time.Hour)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timeSync.Run(ctx, time.Hour)
+	}()
+
+	// 加载设备在线状态
+	presence, err := services.NewPresenceMonitor(cfg.DataDir, cfg.HeartbeatInterval*time.Duration(cfg.OfflineMissedHeartbeats))
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法加载设备在线状态")
+	}
+
+	// 上行命令处理工作池
+	uplinkPool, err := services.NewWorkerPool(cfg.UplinkWorkers, cfg.UplinkQueueSize, cfg.UplinkOverflow)
+	if err != nil {
+		log.Fatal().Err(err).Msg("无法创建上行工作池")
+	}
+	registerUplinkQueueMetrics(uplinkPool)
+
 	// 初始化 Gin 引擎
 	router := gin.Default()
 
 	// 创建并注册路由
-	handler := NewHandler(csClient, statusClient, cfg)
+	handler := NewHandler(csClient, registry, tracker, uplinkPool, outbox, presence, deviceState, desired, groups, acceleration, tilt, timeSync, cfg)
 	handler.RegisterRoutes(router)
 	log.Info().Msg("API 路由注册成功")
 
+	// 检查超时未上行的设备，离线时上报状态服务器
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		presence.Run(ctx, min(time.Minute, cfg.HeartbeatInterval), handler.reportPresence)
+	}()
+
+	// 定期向全部多播组广播时间同步，使同一路段的灯具同相闪烁
+	if cfg.TimeSync.MulticastInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.RunMulticastTimeSync(ctx, cfg.TimeSync.MulticastInterval)
+		}()
+	}
+
+	// MQTT 接入方式下订阅 ChirpStack MQTT 集成事件
+	var consumer *services.MQTTConsumer
+	if cfg.IntegrationMode == config.IntegrationMQTT {
+		consumer = services.NewMQTTConsumer(cfg.MQTT, handler.handleMQTTEvent)
+		if err := consumer.Start(10 * time.Second); err != nil {
+			log.Fatal().Err(err).Str("server", cfg.MQTT.Server).Msg("无法连接到 MQTT 服务器")
+		}
+		log.Info().Str("server", cfg.MQTT.Server).Str("encoding", cfg.MQTT.Encoding).Msg("MQTT 集成订阅已启动")
+	}
+
 	// 启动 HTTP 服务
+	server := &http.Server{Addr: cfg.ListenAddress, Handler: router}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("HTTP 服务关闭失败")
+		}
+	}()
+
 	log.Info().Str("address", cfg.ListenAddress).Msg("HTTP 服务即将启动")
-	if err := router.Run(cfg.ListenAddress); err != nil {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal().Err(err).Msg("HTTP 服务启动失败")
 	}
+
+	// 依次停止事件来源、处理完已排队的上行，再保存处理过程中产生的下行记录、设备状态、在线状态和通知
+	stop()
+	wg.Wait()
+	if consumer != nil {
+		consumer.Stop()
+	}
+	uplinkPool.Stop()
+	tracker.Flush()
+	deviceState.Flush()
+	presence.Flush()
+	if err := outbox.Flush(); err != nil {
+		log.Error().Err(err).Msg("保存发件箱失败")
+	}
+	if err := acceleration.Close(); err != nil {
+		log.Error().Err(err).Msg("关闭加速度时间序列库失败")
+	}
+	if err := tilt.Close(); err != nil {
+		log.Error().Err(err).Msg("关闭姿态时间序列库失败")
+	}
+	if err := timeSync.Close(); err != nil {
+		log.Error().Err(err).Msg("关闭时间同步记录库失败")
+	}
+	log.Info().Msg("服务已退出")
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"chirpstack-httpserver/services"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus 指标，通过 /metrics 暴露
+var (
+	// uplinksTotal 按去重判定结果（accepted、duplicate、replay、reset）统计上行数量
+	uplinksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpstack_httpserver_uplinks_total",
+		Help: "Number of uplink events received, by deduplication verdict.",
+	}, []string{"verdict"})
+
+	// uplinkJobsTotal 按结果（queued、dropped、rejected）统计提交到上行工作池的任务数
+	uplinkJobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpstack_httpserver_uplink_jobs_total",
+		Help: "Number of uplink jobs submitted to the worker pool, by result.",
+	}, []string{"result"})
+
+	// collisionsTotal 按触发条件（magnitude、jerk）统计根据加速度检测到的碰撞数，含冷却期内未上报的
+	collisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpstack_httpserver_collisions_total",
+		Help: "Number of collisions detected from acceleration samples, by trigger.",
+	}, []string{"trigger"})
+)
+
+// registerUplinkQueueMetrics 注册上行工作池队列深度指标
+func registerUplinkQueueMetrics(pool *services.WorkerPool) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "chirpstack_httpserver_uplink_queue_depth",
+		Help: "Number of uplink jobs waiting in the worker pool.",
+	}, func() float64 {
+		return float64(pool.Len())
+	})
+}
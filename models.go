@@ -1,35 +1,48 @@
 package main
 
+import (
+	"time"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/integration"
+)
+
 // --- 单播 API 模型  ---
 
 // SetColorCommand 对应设置颜色的请求体
 type SetColorCommand struct {
-	StakeNo string `json:"stakeNo" binding:"required"`
-	Color   int    `json:"color" binding:"oneof=0 1"`
+	StakeNo   string `json:"stakeNo" binding:"required"`
+	Color     int    `json:"color" binding:"oneof=0 1"`
+	Confirmed bool   `json:"confirmed"` // 是否以确认帧下发
 }
 
 // SetFrequencyCommand 对应设置频率的请求体
 type SetFrequencyCommand struct {
 	StakeNo   string `json:"stakeNo" binding:"required"`
 	Frequency int    `json:"frequency" binding:"oneof=30 60 120"`
+	Confirmed bool   `json:"confirmed"`
 }
 
 // SetLevelCommand 对应设置亮度的请求体
 type SetLevelCommand struct {
-	StakeNo string `json:"stakeNo" binding:"required"`
-	Level   int    `json:"level" binding:"oneof=500 1000 2000 4000 7000"`
+	StakeNo   string `json:"stakeNo" binding:"required"`
+	Level     int    `json:"level" binding:"oneof=500 1000 2000 4000 7000"`
+	Confirmed bool   `json:"confirmed"`
 }
 
 // SetMannerCommand 对应设置亮灯方式的请求体
 type SetMannerCommand struct {
-	StakeNo string `json:"stakeNo" binding:"required"`
-	Manner  int    `json:"manner" binding:"oneof=0 1"`
+	StakeNo   string `json:"stakeNo" binding:"required"`
+	Manner    int    `json:"manner" binding:"oneof=0 1"`
+	Confirmed bool   `json:"confirmed"`
 }
 
 // SetSwitchCommand 对应设置开关的请求体
 type SetSwitchCommand struct {
-	StakeNo string `json:"stakeNo" binding:"required"`
-	Switch  int    `json:"switch" binding:"oneof=0 1"`
+	StakeNo   string `json:"stakeNo" binding:"required"`
+	Switch    int    `json:"switch" binding:"oneof=0 1"`
+	Confirmed bool   `json:"confirmed"`
 }
 
 // OverallSettingCommand 对应整体设置的请求体
@@ -40,16 +53,72 @@ type OverallSettingCommand struct {
 	Level       int    `json:"level" binding:"oneof=500 1000 2000 4000 7000"`
 	Manner      int    `json:"manner" binding:"oneof=0 1"`
 	RadarEnable int    `json:"radarEnable" binding:"oneof=0 1"`
+	Confirmed   bool   `json:"confirmed"`
 }
 
-// UplinkEvent 对应 ChirpStack 上行事件的 JSON 结构
-type UplinkEvent struct {
-	DeviceInfo struct {
-		DevEui string `json:"devEui"`
-	} `json:"deviceInfo"`
-	Data string `json:"data"`
+// ReadConfigCommand 对应读取设备配置的请求体
+type ReadConfigCommand struct {
+	StakeNo   string `json:"stakeNo" binding:"required"`
+	Confirmed bool   `json:"confirmed"`
 }
 
+// StakeResult 对应批量单播命令中单个桩号的执行结果
+// code 与 HTTP 状态码一致，reason 为 OK、ValidationError 或 ChirpStack 返回的 gRPC 状态码名称
+type StakeResult struct {
+	StakeNo    string                   `json:"stakeNo"`
+	Code       int                      `json:"code"`
+	Reason     string                   `json:"reason"`
+	DownlinkID string                   `json:"downlinkId,omitempty"`
+	Error      string                   `json:"error,omitempty"`
+	Config     *services.ReportedConfig `json:"config,omitempty"` // 读取配置命令的应答
+}
+
+// GroupResult 是多播命令中单个多播组的结果
+type GroupResult struct {
+	GroupID string     `json:"groupId"`
+	Code    int        `json:"code"`
+	Reason  string     `json:"reason"`
+	FCnt    uint32     `json:"fCnt,omitempty"`
+	Target  *time.Time `json:"target,omitempty"` // 时间同步负载对应的时刻，即预计组内设备收完下行的时刻
+	Error   string     `json:"error,omitempty"`
+}
+
+// MulticastTimeSyncCommand 是多播时间同步请求，未指定 groupIds 时发往全部多播组
+type MulticastTimeSyncCommand struct {
+	GroupIDs []string `json:"groupIds"`
+}
+
+// ReconcileStatus 是单个设备的配置对账结果
+type ReconcileStatus struct {
+	StakeNo          string   `json:"stakeNo"`
+	DevEUI           string   `json:"devEUI"`
+	Status           string   `json:"status"`
+	Mismatched       []string `json:"mismatched,omitempty"`       // 与期望不一致的配置项
+	Missing          []string `json:"missing,omitempty"`          // 补发整体设置所缺少的配置项
+	PendingDownlinks []string `json:"pendingDownlinks,omitempty"` // 投递中的配置下行
+}
+
+// AccelerationQuery 对应加速度时间序列的查询参数，from 与 to 为 RFC3339 时间，默认查询最近 24 小时
+type AccelerationQuery struct {
+	From     time.Time `form:"from"`
+	To       time.Time `form:"to"`
+	Interval string    `form:"interval"` // 降采样间隔，如 1m；为空时原始采样超过上限才自动降采样
+}
+
+// --- ChirpStack 集成事件模型 ---
+
+// 集成事件直接使用 ChirpStack integration 包中的消息定义，
+// 同一份模型既可解析 JSON 编码也可解析 Protobuf 编码的负载
+type (
+	UplinkEvent   = integration.UplinkEvent
+	JoinEvent     = integration.JoinEvent
+	AckEvent      = integration.AckEvent
+	TxAckEvent    = integration.TxAckEvent
+	StatusEvent   = integration.StatusEvent
+	LogEvent      = integration.LogEvent
+	LocationEvent = integration.LocationEvent
+)
+
 // --- 新增：多播 API 模型 ---
 
 type MulticastSetColorCommand struct {
@@ -87,6 +156,10 @@ type MulticastSetBrightnessCommand struct {
 	Brightness int    `json:"brightness" binding:"gte=0,lte=255"`
 }
 
+type MulticastReadConfigCommand struct {
+	GroupID string `json:"groupId" binding:"required"`
+}
+
 type MulticastOverallSettingCommand struct {
 	GroupID     string `json:"groupId" binding:"required"`
 	Color       int    `json:"color" binding:"oneof=0 1"`
@@ -98,16 +171,103 @@ type MulticastOverallSettingCommand struct {
 
 // 新增，传递多播组参数给单个设备
 type SetMulticastGroupCommand struct {
-	StakeNo string `json:"stakeNo" binding:"required"`
-	DevAddr string `json:"devAddr" binding:"required,len=8"`
-	AppSKey string `json:"appSKey" binding:"required,len=32"`
-	NwkSKey string `json:"nwkSKey" binding:"required,len=32"`
+	StakeNo   string `json:"stakeNo" binding:"required"`
+	DevAddr   string `json:"devAddr" binding:"required,len=8"`
+	AppSKey   string `json:"appSKey" binding:"required,len=32"`
+	NwkSKey   string `json:"nwkSKey" binding:"required,len=32"`
+	Confirmed bool   `json:"confirmed"`
 }
 
 // 设置加速度检测模式的请求体
-// devEUI: 设备唯一标识，enable: 1=打开，0=关闭
+// devEUI: 设备唯一标识，stakeNo: 桩号（二者填其一），enable: 1=打开，0=关闭
 // 用于POST /api/device/set-acceleration-mode
 type SetAccelerationModeCommand struct {
-	DevEUI string `json:"devEUI" binding:"required"`
-	Enable int    `json:"enable" binding:"oneof=0 1"`
+	DevEUI    string `json:"devEUI" binding:"required_without=StakeNo"`
+	StakeNo   string `json:"stakeNo" binding:"required_without=DevEUI"`
+	Enable    int    `json:"enable" binding:"oneof=0 1"`
+	Confirmed bool   `json:"confirmed"`
+}
+
+// --- 设备注册表 API 模型 ---
+
+// DeviceCommand 对应创建或更新桩号记录的请求体
+type DeviceCommand struct {
+	StakeNo         string   `json:"stakeNo"`
+	DevEUI          string   `json:"devEUI" binding:"required"`
+	Road            string   `json:"road"`
+	Direction       string   `json:"direction"`
+	KilometrePost   float64  `json:"kilometrePost" binding:"gte=0"`
+	MulticastGroups []string `json:"multicastGroups"`
+	InstallDate     string   `json:"installDate" binding:"omitempty,datetime=2006-01-02"`
+	ProtocolVersion int      `json:"protocolVersion"`
+}
+
+// DeviceListQuery 对应设备列表的分页与筛选参数
+type DeviceListQuery struct {
+	Page      int      `form:"page" binding:"omitempty,gte=1"`
+	PageSize  int      `form:"pageSize" binding:"omitempty,gte=1,lte=500"`
+	Road      string   `form:"road"`
+	Direction string   `form:"direction"`
+	Group     string   `form:"group"`
+	Keyword   string   `form:"q"`
+	KmFrom    *float64 `form:"kmFrom"`
+	KmTo      *float64 `form:"kmTo"`
+}
+
+// --- 多播组管理 API 模型 ---
+
+// MulticastGroupSettings 是多播组在 ChirpStack 中的参数，更新时未填写的项保持不变
+type MulticastGroupSettings struct {
+	Region                    string  `json:"region"`                                              // 区域名称，如 CN470
+	McAddr                    string  `json:"mcAddr" binding:"omitempty,len=8,hexadecimal"`        // 多播地址
+	McNwkSKey                 string  `json:"mcNwkSKey" binding:"omitempty,len=32,hexadecimal"`    // 多播网络会话密钥
+	McAppSKey                 string  `json:"mcAppSKey" binding:"omitempty,len=32,hexadecimal"`    // 多播应用会话密钥
+	GroupType                 string  `json:"groupType" binding:"omitempty,oneof=CLASS_C CLASS_B"` // 默认 CLASS_C
+	Dr                        *uint32 `json:"dr"`
+	Frequency                 *uint32 `json:"frequency"` // 单位 Hz
+	ClassBPingSlotPeriodicity *uint32 `json:"classBPingSlotPeriodicity" binding:"omitempty,lte=7"`
+	ClassCSchedulingType      string  `json:"classCSchedulingType" binding:"omitempty,oneof=DELAY GPS_TIME"`
+}
+
+// CreateMulticastGroupCommand 对应创建多播组的请求体。
+// 填写 multicastGroupId 时登记 ChirpStack 中已有的多播组，忽略其余参数
+type CreateMulticastGroupCommand struct {
+	GroupID          string `json:"groupId" binding:"required"`
+	MulticastGroupID string `json:"multicastGroupId"`
+	MulticastGroupSettings
+}
+
+// MulticastGroupDevicesCommand 对应批量将桩号加入多播组的请求体
+type MulticastGroupDevicesCommand struct {
+	StakeNos []string `json:"stakeNos" binding:"required,min=1"`
+}
+
+// MulticastGroupView 是多播组的查询结果
+type MulticastGroupView struct {
+	GroupID          string               `json:"groupId"`
+	MulticastGroupID string               `json:"multicastGroupId"`
+	Members          []string             `json:"members"` // 注册表中属于该组的桩号
+	ChirpStack       *MulticastGroupState `json:"chirpstack,omitempty"`
+}
+
+// MulticastGroupState 是 ChirpStack 中多播组的当前参数，不含会话密钥
+type MulticastGroupState struct {
+	Name                      string `json:"name"`
+	Region                    string `json:"region"`
+	McAddr                    string `json:"mcAddr"`
+	GroupType                 string `json:"groupType"`
+	Dr                        uint32 `json:"dr"`
+	Frequency                 uint32 `json:"frequency"`
+	ClassBPingSlotPeriodicity uint32 `json:"classBPingSlotPeriodicity"`
+	ClassCSchedulingType      string `json:"classCSchedulingType"`
+	FCnt                      uint32 `json:"fCnt"`
+}
+
+// RemoteMulticastGroup 是 ChirpStack 应用下的多播组，groupId 为空表示尚未登记
+type RemoteMulticastGroup struct {
+	MulticastGroupID string `json:"multicastGroupId"`
+	Name             string `json:"name"`
+	Region           string `json:"region"`
+	GroupType        string `json:"groupType"`
+	GroupID          string `json:"groupId,omitempty"`
 }
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/api"
+	"github.com/chirpstack/chirpstack/api/go/v4/common"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// handleListMulticastGroups 列出已登记的多播组及其成员；remote=true 时改为列出 ChirpStack 应用下的全部多播组
+func (h *Handler) handleListMulticastGroups(c *gin.Context) {
+	if c.Query("remote") == "true" {
+		h.listRemoteMulticastGroups(c)
+		return
+	}
+
+	names := h.groups.Names()
+	views := make([]MulticastGroupView, 0, len(names))
+	for _, name := range names {
+		id, _ := h.groups.Lookup(name)
+		views = append(views, h.multicastGroupView(name, id))
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": views})
+}
+
+// listRemoteMulticastGroups 列出 ChirpStack 应用下的多播组，并标注已登记的名称，便于登记已有多播组
+func (h *Handler) listRemoteMulticastGroups(c *gin.Context) {
+	if h.config.ApplicationID == "" {
+		respondValidationError(c, "application_id is not configured.")
+		return
+	}
+	items, err := h.csClient.ListMulticastGroups(h.config.ApplicationID)
+	if err != nil {
+		log.Error().Err(err).Str("applicationId", h.config.ApplicationID).Msg("查询 ChirpStack 多播组失败")
+		respondError(c, err, "Failed to list multicast groups.")
+		return
+	}
+
+	registered := make(map[string]string)
+	for name, id := range h.groups.Snapshot() {
+		registered[id] = name
+	}
+	groups := make([]RemoteMulticastGroup, 0, len(items))
+	for _, item := range items {
+		groups = append(groups, RemoteMulticastGroup{
+			MulticastGroupID: item.GetId(),
+			Name:             item.GetName(),
+			Region:           item.GetRegion().String(),
+			GroupType:        item.GetGroupType().String(),
+			GroupID:          registered[item.GetId()],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": groups})
+}
+
+// handleCreateMulticastGroup 在 ChirpStack 中创建多播组并登记，或登记 ChirpStack 中已有的多播组
+func (h *Handler) handleCreateMulticastGroup(c *gin.Context) {
+	var cmd CreateMulticastGroupCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		respondValidationError(c, "Invalid request: "+err.Error())
+		return
+	}
+	if _, ok := h.groups.Lookup(cmd.GroupID); ok {
+		respondError(c, services.ErrGroupConflict, "Failed to create multicast group "+cmd.GroupID+":")
+		return
+	}
+
+	id := cmd.MulticastGroupID
+	if id != "" {
+		// 登记已有多播组前确认其存在
+		if _, err := h.csClient.GetMulticastGroup(id); err != nil {
+			respondError(c, err, "Failed to get multicast group.")
+			return
+		}
+	} else {
+		g, err := h.newMulticastGroup(cmd)
+		if err != nil {
+			respondError(c, err, "Invalid request:")
+			return
+		}
+		if id, err = h.csClient.CreateMulticastGroup(g); err != nil {
+			log.Error().Err(err).Str("groupId", cmd.GroupID).Msg("创建 ChirpStack 多播组失败")
+			respondError(c, err, "Failed to create multicast group.")
+			return
+		}
+	}
+
+	if err := h.groups.Add(cmd.GroupID, id); err != nil {
+		log.Error().Err(err).Str("groupId", cmd.GroupID).Msg("登记多播组失败")
+		if cmd.MulticastGroupID == "" {
+			// 回滚刚创建的多播组，避免在 ChirpStack 中留下未登记的多播组
+			if derr := h.csClient.DeleteMulticastGroup(id); derr != nil {
+				log.Error().Err(derr).Str("multicastGroupId", id).Msg("回滚 ChirpStack 多播组失败")
+			}
+		}
+		respondError(c, err, "Failed to register multicast group.")
+		return
+	}
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastGroupId", id).Bool("adopted", cmd.MulticastGroupID != "").Msg("多播组已登记")
+
+	c.JSON(http.StatusCreated, gin.H{"code": 201, "message": "Multicast group created successfully.", "data": h.multicastGroupView(cmd.GroupID, id)})
+}
+
+// handleGetMulticastGroup 查询多播组在 ChirpStack 中的参数及注册表中的成员
+func (h *Handler) handleGetMulticastGroup(c *gin.Context) {
+	name := c.Param("groupId")
+	id, ok := h.groups.Lookup(name)
+	if !ok {
+		respondError(c, services.ErrGroupNotFound, "Multicast group not found.")
+		return
+	}
+	g, err := h.csClient.GetMulticastGroup(id)
+	if err != nil {
+		respondError(c, err, "Failed to get multicast group.")
+		return
+	}
+
+	view := h.multicastGroupView(name, id)
+	view.ChirpStack = newMulticastGroupState(g)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": view})
+}
+
+// handleUpdateMulticastGroup 修改多播组在 ChirpStack 中的参数，未填写的项保持不变
+func (h *Handler) handleUpdateMulticastGroup(c *gin.Context) {
+	name := c.Param("groupId")
+	id, ok := h.groups.Lookup(name)
+	if !ok {
+		respondError(c, services.ErrGroupNotFound, "Multicast group not found.")
+		return
+	}
+
+	var settings MulticastGroupSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondValidationError(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	g, err := h.csClient.GetMulticastGroup(id)
+	if err != nil {
+		respondError(c, err, "Failed to get multicast group.")
+		return
+	}
+	if err := settings.apply(g); err != nil {
+		respondError(c, err, "Invalid request:")
+		return
+	}
+	if err := h.csClient.UpdateMulticastGroup(g); err != nil {
+		log.Error().Err(err).Str("groupId", name).Msg("更新 ChirpStack 多播组失败")
+		respondError(c, err, "Failed to update multicast group.")
+		return
+	}
+	log.Info().Str("groupId", name).Str("multicastGroupId", id).Msg("多播组已更新")
+
+	view := h.multicastGroupView(name, id)
+	view.ChirpStack = newMulticastGroupState(g)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast group updated successfully.", "data": view})
+}
+
+// handleDeleteMulticastGroup 删除 ChirpStack 中的多播组并注销，同时从所有设备记录中移除该组、清除该组的期望配置；
+// keepRemote=true 时只注销，保留 ChirpStack 中的多播组
+func (h *Handler) handleDeleteMulticastGroup(c *gin.Context) {
+	name := c.Param("groupId")
+	id, ok := h.groups.Lookup(name)
+	if !ok {
+		respondError(c, services.ErrGroupNotFound, "Multicast group not found.")
+		return
+	}
+
+	if c.Query("keepRemote") != "true" {
+		// ChirpStack 中已不存在时视为删除成功，以便注销失效的登记
+		if err := h.csClient.DeleteMulticastGroup(id); err != nil && status.Code(err) != codes.NotFound {
+			log.Error().Err(err).Str("groupId", name).Msg("删除 ChirpStack 多播组失败")
+			respondError(c, err, "Failed to delete multicast group.")
+			return
+		}
+	}
+	// 先更新设备记录并清除期望配置，再注销登记，失败时可按同一名称重试
+	removed, err := h.registry.RemoveGroup(name)
+	if err != nil {
+		log.Error().Err(err).Str("groupId", name).Msg("从设备记录中移除多播组失败")
+		respondError(c, err, "Failed to update devices of the multicast group.")
+		return
+	}
+	if err := h.desired.DeleteGroup(name); err != nil {
+		log.Error().Err(err).Str("groupId", name).Msg("删除多播组期望配置失败")
+		respondError(c, err, "Failed to delete desired state of the multicast group.")
+		return
+	}
+	if err := h.groups.Delete(name); err != nil {
+		log.Error().Err(err).Str("groupId", name).Msg("注销多播组失败")
+		respondError(c, err, "Failed to delete multicast group.")
+		return
+	}
+	log.Info().Str("groupId", name).Str("multicastGroupId", id).Int("devices", removed).Msg("多播组已删除")
+
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Multicast group deleted successfully.", "data": gin.H{"devices": removed}})
+}
+
+// handleListMulticastGroupDevices 列出注册表中属于多播组的设备
+func (h *Handler) handleListMulticastGroupDevices(c *gin.Context) {
+	name := c.Param("groupId")
+	if _, ok := h.groups.Lookup(name); !ok {
+		respondError(c, services.ErrGroupNotFound, "Multicast group not found.")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": h.registry.List(services.DeviceFilter{Group: name})})
+}
+
+// handleAddMulticastGroupDevices 将已登记的桩号批量加入多播组，ChirpStack 与注册表同时更新
+func (h *Handler) handleAddMulticastGroupDevices(c *gin.Context) {
+	name := c.Param("groupId")
+	id, ok := h.groups.Lookup(name)
+	if !ok {
+		respondError(c, services.ErrGroupNotFound, "Multicast group not found.")
+		return
+	}
+
+	var cmd MulticastGroupDevicesCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		respondValidationError(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	results := make([]StakeResult, 0, len(cmd.StakeNos))
+	for _, stakeNo := range cmd.StakeNos {
+		result := StakeResult{StakeNo: stakeNo, Code: http.StatusOK, Reason: reasonOK}
+		if err := h.addGroupMember(name, id, stakeNo); err != nil {
+			result.Code, result.Reason = errorStatus(err)
+			result.Error = errorMessage(err)
+		}
+		results = append(results, result)
+	}
+	respondBatch(c, results, "Devices added to multicast group successfully.")
+}
+
+// addGroupMember 将桩号加入 ChirpStack 多播组并记入注册表，注册表写入失败时撤销 ChirpStack 中的变更
+func (h *Handler) addGroupMember(name, multicastGroupID, stakeNo string) error {
+	d, ok := h.registry.Get(stakeNo)
+	if !ok {
+		return newUnknownStakeError(stakeNo)
+	}
+	if err := h.csClient.AddDeviceToMulticastGroup(multicastGroupID, d.DevEUI); err != nil {
+		log.Error().Err(err).Str("groupId", name).Str("stakeNo", stakeNo).Msg("将设备加入 ChirpStack 多播组失败")
+		return err
+	}
+	if err := h.registry.SetGroupMember(stakeNo, name, true); err != nil {
+		log.Error().Err(err).Str("groupId", name).Str("stakeNo", stakeNo).Msg("记录多播组成员失败")
+		if rerr := h.csClient.RemoveDeviceFromMulticastGroup(multicastGroupID, d.DevEUI); rerr != nil {
+			log.Error().Err(rerr).Str("groupId", name).Str("stakeNo", stakeNo).Msg("回滚 ChirpStack 多播组成员失败")
+		}
+		return err
+	}
+	log.Info().Str("groupId", name).Str("stakeNo", stakeNo).Msg("设备已加入多播组")
+	return nil
+}
+
+// handleRemoveMulticastGroupDevice 将桩号移出多播组，ChirpStack 与注册表同时更新
+func (h *Handler) handleRemoveMulticastGroupDevice(c *gin.Context) {
+	name := c.Param("groupId")
+	stakeNo := c.Param("stakeNo")
+	id, ok := h.groups.Lookup(name)
+	if !ok {
+		respondError(c, services.ErrGroupNotFound, "Multicast group not found.")
+		return
+	}
+	d, ok := h.registry.Get(stakeNo)
+	if !ok {
+		respondError(c, newUnknownStakeError(stakeNo), "Device not found.")
+		return
+	}
+
+	// 设备已不在 ChirpStack 多播组中时仍更新注册表，使两边保持一致
+	if err := h.csClient.RemoveDeviceFromMulticastGroup(id, d.DevEUI); err != nil && status.Code(err) != codes.NotFound {
+		log.Error().Err(err).Str("groupId", name).Str("stakeNo", stakeNo).Msg("将设备移出 ChirpStack 多播组失败")
+		respondError(c, err, "Failed to remove device from multicast group.")
+		return
+	}
+	if err := h.registry.SetGroupMember(stakeNo, name, false); err != nil {
+		log.Error().Err(err).Str("groupId", name).Str("stakeNo", stakeNo).Msg("记录多播组成员失败")
+		respondError(c, err, "Failed to remove device from multicast group.")
+		return
+	}
+	log.Info().Str("groupId", name).Str("stakeNo", stakeNo).Msg("设备已移出多播组")
+
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Device removed from multicast group successfully."})
+}
+
+// multicastGroupView 组装多播组的登记信息与成员桩号
+func (h *Handler) multicastGroupView(name, multicastGroupID string) MulticastGroupView {
+	devices := h.registry.List(services.DeviceFilter{Group: name})
+	members := make([]string, 0, len(devices))
+	for _, d := range devices {
+		members = append(members, d.StakeNo)
+	}
+	return MulticastGroupView{GroupID: name, MulticastGroupID: multicastGroupID, Members: members}
+}
+
+// newMulticastGroup 根据创建请求生成 ChirpStack 多播组，名称与 groupId 相同
+func (h *Handler) newMulticastGroup(cmd CreateMulticastGroupCommand) (*api.MulticastGroup, error) {
+	if h.config.ApplicationID == "" {
+		return nil, newValidationError("application_id is not configured")
+	}
+	if cmd.Region == "" || cmd.McAddr == "" || cmd.McNwkSKey == "" || cmd.McAppSKey == "" || cmd.Frequency == nil {
+		return nil, newValidationError("region, mcAddr, mcNwkSKey, mcAppSKey and frequency are required")
+	}
+
+	g := &api.MulticastGroup{Name: cmd.GroupID, ApplicationId: h.config.ApplicationID}
+	if err := cmd.MulticastGroupSettings.apply(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// apply 将请求中填写的参数写入 g
+func (s MulticastGroupSettings) apply(g *api.MulticastGroup) error {
+	if s.Region != "" {
+		region, ok := common.Region_value[strings.ToUpper(s.Region)]
+		if !ok {
+			return newValidationError("unknown region: %s", s.Region)
+		}
+		g.Region = common.Region(region)
+	}
+	// 校验器的 hexadecimal 允许 0x 前缀，这里按 ChirpStack 的要求检查为纯十六进制
+	for _, f := range []struct {
+		name, value string
+		size        int
+		dst         *string
+	}{
+		{"mcAddr", s.McAddr, 4, &g.McAddr},
+		{"mcNwkSKey", s.McNwkSKey, 16, &g.McNwkSKey},
+		{"mcAppSKey", s.McAppSKey, 16, &g.McAppSKey},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if raw, err := hex.DecodeString(f.value); err != nil || len(raw) != f.size {
+			return newValidationError("%s must be %d hexadecimal characters: %s", f.name, 2*f.size, f.value)
+		}
+		*f.dst = strings.ToLower(f.value)
+	}
+	if s.GroupType != "" {
+		g.GroupType = api.MulticastGroupType(api.MulticastGroupType_value[s.GroupType])
+	}
+	if s.Dr != nil {
+		g.Dr = *s.Dr
+	}
+	if s.Frequency != nil {
+		g.Frequency = *s.Frequency
+	}
+	if s.ClassBPingSlotPeriodicity != nil {
+		g.ClassBPingSlotPeriodicity = *s.ClassBPingSlotPeriodicity
+	}
+	if s.ClassCSchedulingType != "" {
+		g.ClassCSchedulingType = api.MulticastGroupSchedulingType(api.MulticastGroupSchedulingType_value[s.ClassCSchedulingType])
+	}
+	return nil
+}
+
+// newMulticastGroupState 提取 ChirpStack 多播组中可公开的参数
+func newMulticastGroupState(g *api.MulticastGroup) *MulticastGroupState {
+	return &MulticastGroupState{
+		Name:                      g.GetName(),
+		Region:                    g.GetRegion().String(),
+		McAddr:                    g.GetMcAddr(),
+		GroupType:                 g.GetGroupType().String(),
+		Dr:                        g.GetDr(),
+		Frequency:                 g.GetFrequency(),
+		ClassBPingSlotPeriodicity: g.GetClassBPingSlotPeriodicity(),
+		ClassCSchedulingType:      g.GetClassCSchedulingType().String(),
+		FCnt:                      g.GetFCnt(),
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/api"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeleteMulticastGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		failSave   string // 保存失败的数据文件
+		wantCode   int
+		wantGroups []string // K1 所属的多播组
+		wantLookup bool     // g1 是否仍在多播组目录中
+		wantColor  bool     // g1 的期望配置是否仍参与合并
+	}{
+		{"删除成功", "", http.StatusOK, []string{"g2"}, false, false},
+		{"设备记录更新失败时保留登记与期望配置", "devices.json", http.StatusInternalServerError, []string{"g1", "g2"}, true, true},
+		{"期望配置删除失败时保留登记", "desired_state.json", http.StatusInternalServerError, []string{"g2"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			h := newGroupTestHandler(t, dir, map[string]string{"g1": "uuid-1", "g2": "uuid-2"})
+			if err := h.registry.Create(services.Device{StakeNo: "K1", DevEUI: "0000000000000001", MulticastGroups: []string{"g1", "g2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.desired.SetGroup("g1", services.LightConfig{Color: intPtr(1)}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.failSave != "" {
+				// 临时文件的位置被目录占用，写入失败
+				if err := os.Mkdir(filepath.Join(dir, tt.failSave+".tmp"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			router := gin.New()
+			router.DELETE("/multicast-groups/:groupId", h.handleDeleteMulticastGroup)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/multicast-groups/g1?keepRemote=true", nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("状态码 %d，期望 %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+
+			if d, _ := h.registry.Get("K1"); !reflect.DeepEqual(d.MulticastGroups, tt.wantGroups) {
+				t.Errorf("K1 所属多播组 %v，期望 %v", d.MulticastGroups, tt.wantGroups)
+			}
+			if _, ok := h.groups.Lookup("g1"); ok != tt.wantLookup {
+				t.Errorf("g1 仍在目录中 = %v，期望 %v", ok, tt.wantLookup)
+			}
+			if c := h.desired.Effective("K1", []string{"g1"}); (c.Color != nil) != tt.wantColor {
+				t.Errorf("g1 的期望配置仍存在 = %v，期望 %v", c.Color != nil, tt.wantColor)
+			}
+		})
+	}
+}
+
+func TestMulticastGroupSettingsApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings MulticastGroupSettings
+		wantErr  bool
+		wantAddr string
+	}{
+		{"多播地址统一为小写", MulticastGroupSettings{McAddr: "01ABCDEF"}, false, "01abcdef"},
+		{"多播地址带 0x 前缀", MulticastGroupSettings{McAddr: "0x123456"}, true, ""},
+		{"会话密钥带 0x 前缀", MulticastGroupSettings{McNwkSKey: "0x" + strings.Repeat("0", 30)}, true, ""},
+		{"未知区域", MulticastGroupSettings{Region: "XX999"}, true, ""},
+		{"未填写的项保持不变", MulticastGroupSettings{Region: "cn470"}, false, "00000001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &api.MulticastGroup{McAddr: "00000001"}
+			err := tt.settings.apply(g)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply: %v，期望出错 %v", err, tt.wantErr)
+			}
+			if err == nil && g.McAddr != tt.wantAddr {
+				t.Fatalf("mcAddr = %s，期望 %s", g.McAddr, tt.wantAddr)
+			}
+		})
+	}
+}
+
+// newGroupTestHandler 创建只带注册表、期望状态与多播组目录的 Handler
+func newGroupTestHandler(t *testing.T, dir string, groups map[string]string) *Handler {
+	t.Helper()
+
+	registry, err := services.NewDeviceRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired, err := services.NewDesiredStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory, err := services.NewMulticastGroupDirectory(dir, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{registry: registry, desired: desired, groups: directory}
+}
+
+// intPtr 返回 v 的指针
+func intPtr(v int) *int {
+	return &v
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/api"
+	"github.com/chirpstack/chirpstack/api/go/v4/common"
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// groupModulation 返回多播组数据速率对应的 LoRa 扩频因子与带宽，FSK 等非 LoRa 速率返回 ok=false
+func groupModulation(g *api.MulticastGroup) (sf, bw int, ok bool) {
+	dr := int(g.GetDr())
+	if dr >= 8 && dr <= 13 && (g.GetRegion() == common.Region_US915 || g.GetRegion() == common.Region_AU915) {
+		return 20 - dr, 500000, true
+	}
+	switch g.GetRegion() {
+	case common.Region_US915:
+		switch {
+		case dr <= 3:
+			return 10 - dr, 125000, true
+		case dr == 4:
+			return 8, 500000, true
+		}
+		return 0, 0, false
+	case common.Region_AU915:
+		switch {
+		case dr <= 5:
+			return 12 - dr, 125000, true
+		case dr == 6:
+			return 8, 500000, true
+		}
+		return 0, 0, false
+	}
+	switch {
+	case dr <= 5:
+		return 12 - dr, 125000, true
+	case dr == 6:
+		return 7, 250000, true
+	}
+	return 0, 0, false
+}
+
+// multicastEmitTime 预计 ChirpStack 发送 now 时刻入队的多播下行的时间：Class B 为入队余量之后的
+// 第一个 ping 时隙，Class C 按 GPS 时间调度时为入队余量之后，按 DELAY 调度时为 multicast_delay 之后。
+// 估算假设该组的多播队列为空：队列中已有的下行会先发送，时间同步随之推迟，设备时钟将偏慢。
+// 按 DELAY 调度时 ChirpStack 经由组内各网关依次发送，估算只对应最先发送的网关，
+// 不保证每个网关下的设备都能对准；需要逐网关的精度时应使用 GPS 时间调度或 Class B
+func (h *Handler) multicastEmitTime(g *api.MulticastGroup, now time.Time) (time.Time, error) {
+	cfg := h.config.TimeSync
+	if g.GetGroupType() == api.MulticastGroupType_CLASS_B {
+		raw, err := hex.DecodeString(g.GetMcAddr())
+		if err != nil || len(raw) != 4 {
+			return time.Time{}, newValidationError("invalid mcAddr %q of multicast group %s", g.GetMcAddr(), g.GetName())
+		}
+		return services.NextPingSlot(now.Add(cfg.MulticastMargin), [4]byte(raw), int(g.GetClassBPingSlotPeriodicity())), nil
+	}
+	if g.GetClassCSchedulingType() == api.MulticastGroupSchedulingType_GPS_TIME {
+		return now.Add(cfg.MulticastMargin), nil
+	}
+	return now.Add(cfg.MulticastDelay), nil
+}
+
+// groupTimeProfile 返回多播组已登记设备共同的时间同步设置，组内设置不一致时无法共用一个负载
+func (h *Handler) groupTimeProfile(group string) (timeProfile, error) {
+	profile, first := h.timeProfiles.fallback, ""
+	for _, d := range h.registry.List(services.DeviceFilter{Group: group}) {
+		p := h.timeProfileFor(d.DevEUI)
+		if first == "" {
+			profile, first = p, d.StakeNo
+			continue
+		}
+		if p.Location.String() != profile.Location.String() || p.Format != profile.Format {
+			return timeProfile{}, newUnsupportedError("devices %s and %s in group %s use different time zones or formats; group must be split by device profile", first, d.StakeNo, group)
+		}
+	}
+	return profile, nil
+}
+
+// multicastTimeSync 向一个多播组发送时间同步，负载对应组内设备预计收完下行的时刻
+func (h *Handler) multicastTimeSync(group, multicastGroupID string) GroupResult {
+	result := GroupResult{GroupID: group}
+	fail := func(err error) GroupResult {
+		result.Code, result.Reason = errorStatus(err)
+		result.Error = errorMessage(err)
+		log.Error().Err(err).Str("groupId", group).Str("multicastUUID", multicastGroupID).Msg("多播时间同步失败")
+		return result
+	}
+
+	profile, err := h.groupTimeProfile(group)
+	if err != nil {
+		return fail(err)
+	}
+	g, err := h.csClient.GetMulticastGroup(multicastGroupID)
+	if err != nil {
+		return fail(err)
+	}
+	emit, err := h.multicastEmitTime(g, time.Now())
+	if err != nil {
+		return fail(err)
+	}
+	var airtime time.Duration
+	if sf, bw, ok := groupModulation(g); ok {
+		airtime = services.LoRaAirtime(lorawanOverhead+protocol.TimeSync{}.Spec().Size, sf, bw, int(gw.CodeRate_CR_4_5), false)
+	}
+	target := emit.Add(airtime).In(profile.Location)
+	msg, err := protocol.NewTimeSyncMessage(target, profile.Format)
+	if err != nil {
+		return fail(err)
+	}
+
+	fCnt, err := h.enqueueMulticast(group, multicastGroupID, msg)
+	if err != nil {
+		return fail(err)
+	}
+	log.Info().
+		Str("groupId", group).
+		Str("multicastUUID", multicastGroupID).
+		Str("groupType", g.GetGroupType().String()).
+		Str("format", string(profile.Format)).
+		Time("target", target).
+		Interface("payload", msg).
+		Uint32("fCnt", fCnt).
+		Msg("多播时间同步已入队")
+	result.Code, result.Reason, result.FCnt, result.Target = http.StatusOK, reasonOK, fCnt, &target
+	return result
+}
+
+// broadcastTimeSync 依次向多播组发送时间同步，groups 为空时发往目录中的全部多播组
+func (h *Handler) broadcastTimeSync(groups []string) []GroupResult {
+	if len(groups) == 0 {
+		groups = h.groups.Names()
+	}
+	results := make([]GroupResult, 0, len(groups))
+	for _, group := range groups {
+		multicastGroupID, found := h.groups.Lookup(group)
+		if !found {
+			results = append(results, GroupResult{GroupID: group, Code: http.StatusBadRequest, Reason: reasonValidationError, Error: "unknown groupId"})
+			continue
+		}
+		results = append(results, h.multicastTimeSync(group, multicastGroupID))
+	}
+	return results
+}
+
+// RunMulticastTimeSync 按 interval 定期向全部多播组广播时间同步，直到 ctx 取消
+func (h *Handler) RunMulticastTimeSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			failed := make([]string, 0)
+			results := h.broadcastTimeSync(nil)
+			for _, r := range results {
+				if r.Code != http.StatusOK {
+					failed = append(failed, r.GroupID)
+				}
+			}
+			log.Info().Int("groups", len(results)).Strs("failed", failed).Msg("定时多播时间同步完成")
+		}
+	}
+}
+
+// handleMulticastTimeSync 立即向指定或全部多播组广播时间同步
+func (h *Handler) handleMulticastTimeSync(c *gin.Context) {
+	var cmd MulticastTimeSyncCommand
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&cmd); err != nil {
+			respondValidationError(c, "Invalid request: "+err.Error())
+			return
+		}
+	}
+	if len(cmd.GroupIDs) == 0 && len(h.groups.Names()) == 0 {
+		respondValidationError(c, "No multicast groups configured.")
+		return
+	}
+	respondBatch(c, h.broadcastTimeSync(cmd.GroupIDs), "Multicast time sync enqueued successfully.")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/api"
+	"github.com/chirpstack/chirpstack/api/go/v4/common"
+)
+
+func TestGroupModulation(t *testing.T) {
+	tests := []struct {
+		region common.Region
+		dr     uint32
+		sf, bw int
+		ok     bool
+	}{
+		{common.Region_EU868, 0, 12, 125000, true},
+		{common.Region_EU868, 5, 7, 125000, true},
+		{common.Region_EU868, 6, 7, 250000, true},
+		{common.Region_EU868, 7, 0, 0, false}, // FSK
+		{common.Region_CN470, 2, 10, 125000, true},
+		{common.Region_US915, 0, 10, 125000, true},
+		{common.Region_US915, 3, 7, 125000, true},
+		{common.Region_US915, 4, 8, 500000, true},
+		{common.Region_US915, 5, 0, 0, false},
+		{common.Region_US915, 8, 12, 500000, true},
+		{common.Region_US915, 13, 7, 500000, true},
+		{common.Region_AU915, 0, 12, 125000, true},
+		{common.Region_AU915, 6, 8, 500000, true},
+		{common.Region_AU915, 7, 0, 0, false},
+		{common.Region_AU915, 8, 12, 500000, true},
+	}
+	for _, tt := range tests {
+		sf, bw, ok := groupModulation(&api.MulticastGroup{Region: tt.region, Dr: tt.dr})
+		if sf != tt.sf || bw != tt.bw || ok != tt.ok {
+			t.Errorf("%s DR%d: SF%d %dHz %v，期望 SF%d %dHz %v", tt.region, tt.dr, sf, bw, ok, tt.sf, tt.bw, tt.ok)
+		}
+	}
+}
+
+func TestMulticastEmitTime(t *testing.T) {
+	h := &Handler{config: config.Config{TimeSync: config.TimeSyncConfig{MulticastMargin: 5 * time.Second, MulticastDelay: time.Second}}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		group   *api.MulticastGroup
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "Class C 按 GPS 时间调度",
+			group: &api.MulticastGroup{GroupType: api.MulticastGroupType_CLASS_C, ClassCSchedulingType: api.MulticastGroupSchedulingType_GPS_TIME},
+			want:  now.Add(5 * time.Second),
+		},
+		{
+			name:  "Class C 按 DELAY 调度",
+			group: &api.MulticastGroup{GroupType: api.MulticastGroupType_CLASS_C, ClassCSchedulingType: api.MulticastGroupSchedulingType_DELAY},
+			want:  now.Add(time.Second),
+		},
+		{
+			name:  "Class B 为入队余量之后的第一个 ping 时隙",
+			group: &api.MulticastGroup{GroupType: api.MulticastGroupType_CLASS_B, McAddr: "01020304", ClassBPingSlotPeriodicity: 3},
+			want:  services.NextPingSlot(now.Add(5*time.Second), [4]byte{0x01, 0x02, 0x03, 0x04}, 3),
+		},
+		{
+			name:    "Class B 的 mcAddr 无效",
+			group:   &api.MulticastGroup{Name: "g1", GroupType: api.MulticastGroupType_CLASS_B, McAddr: "0102"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.multicastEmitTime(tt.group, now)
+			if tt.wantErr {
+				if code, _ := errorStatus(err); code != http.StatusBadRequest {
+					t.Fatalf("错误 %v，期望参数错误", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("预计发送时间 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupTimeProfile(t *testing.T) {
+	profiles, err := loadTimeProfiles(config.TimeSyncConfig{
+		Zone:     "Asia/Shanghai",
+		Format:   string(protocol.FormatMsSinceMidnight),
+		Profiles: map[string]config.TimeProfileConfig{"lamp-v3": {Zone: "UTC", Format: string(protocol.FormatUnixSeconds)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// profiles 为组内各设备上报的设备配置文件名称，空字符串表示未上报
+	tests := []struct {
+		name       string
+		profiles   []string
+		wantZone   string
+		wantFormat protocol.TimeFormat
+		wantErr    bool
+	}{
+		{"组内无设备时使用默认设置", nil, "Asia/Shanghai", protocol.FormatMsSinceMidnight, false},
+		{"均使用默认设置", []string{"", "street-light"}, "Asia/Shanghai", protocol.FormatMsSinceMidnight, false},
+		{"均使用同一设备配置文件", []string{"lamp-v3", "LAMP-V3"}, "UTC", protocol.FormatUnixSeconds, false},
+		{"设置不一致", []string{"", "lamp-v3"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			registry, err := services.NewDeviceRegistry(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, err := services.NewDeviceStateStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &Handler{registry: registry, state: state, timeProfiles: profiles}
+			for i, name := range tt.profiles {
+				d := services.Device{StakeNo: fmt.Sprintf("K%d", i+1), DevEUI: fmt.Sprintf("%016x", i+1), MulticastGroups: []string{"g1"}}
+				if err := registry.Create(d); err != nil {
+					t.Fatal(err)
+				}
+				if name != "" {
+					state.Update(d.DevEUI, func(st *services.DeviceState) { st.DeviceProfileName = name })
+				}
+			}
+
+			p, err := h.groupTimeProfile("g1")
+			if tt.wantErr {
+				if code, reason := errorStatus(err); code != http.StatusBadRequest || reason != reasonUnsupported {
+					t.Fatalf("错误 %v，期望 %s", err, reasonUnsupported)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Location.String() != tt.wantZone || p.Format != tt.wantFormat {
+				t.Fatalf("时间同步设置 %s %s，期望 %s %s", p.Location, p.Format, tt.wantZone, tt.wantFormat)
+			}
+		})
+	}
+}
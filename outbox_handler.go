@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleListOutboxPending 列出等待投递到状态服务器的通知
+func (h *Handler) handleListOutboxPending(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": h.outbox.Pending()})
+}
+
+// handleListDeadLetters 列出投递失败的通知
+func (h *Handler) handleListDeadLetters(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": h.outbox.DeadLetters()})
+}
+
+// handleRedriveDeadLetter 将一条死信重新放回待投递队列
+func (h *Handler) handleRedriveDeadLetter(c *gin.Context) {
+	id := c.Param("id")
+	msg, err := h.outbox.Redrive(id)
+	if errors.Is(err, services.ErrOutboxMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "reason": "NotFound", "message": "Unknown dead letter id: " + id})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Dead letter requeued.", "data": msg})
+}
+
+// handleRedriveAllDeadLetters 将全部死信重新放回待投递队列
+func (h *Handler) handleRedriveAllDeadLetters(c *gin.Context) {
+	n := h.outbox.RedriveAll()
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Dead letters requeued.", "data": gin.H{"requeued": n}})
+}
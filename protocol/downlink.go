@@ -0,0 +1,243 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Color 设置颜色 (fPort 11)
+type Color struct {
+	Color uint8
+}
+
+func (Color) Spec() Spec { return mustSpec("color") }
+
+func (m Color) Encode() ([]byte, error) { return []byte{m.Color}, nil }
+
+// DecodeColor 解码颜色设置
+func DecodeColor(data []byte) (Color, error) { return decodeAs[Color](Color{}.Spec(), data) }
+
+// Frequency 设置闪烁频率 (fPort 10)，PerMinute 为每分钟闪烁次数
+type Frequency struct {
+	PerMinute int
+}
+
+// Frequencies 是设备支持的闪烁频率
+var Frequencies = []int{30, 60, 120}
+
+func (Frequency) Spec() Spec { return mustSpec("frequency") }
+
+// Encode 编码频率，频率字节即每分钟闪烁次数
+func (m Frequency) Encode() ([]byte, error) {
+	b, err := encodeFrequency(m.PerMinute)
+	if err != nil {
+		return nil, err
+	}
+	return []byte{b}, nil
+}
+
+// DecodeFrequency 解码频率设置
+func DecodeFrequency(data []byte) (Frequency, error) {
+	return decodeAs[Frequency](Frequency{}.Spec(), data)
+}
+
+func encodeFrequency(perMinute int) (byte, error) {
+	for _, f := range Frequencies {
+		if f == perMinute {
+			return byte(perMinute), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported frequency: %d", perMinute)
+}
+
+// Manner 设置亮灯方式 (fPort 12)
+type Manner struct {
+	Manner uint8
+}
+
+func (Manner) Spec() Spec { return mustSpec("manner") }
+
+func (m Manner) Encode() ([]byte, error) { return []byte{m.Manner}, nil }
+
+// DecodeManner 解码亮灯方式设置
+func DecodeManner(data []byte) (Manner, error) { return decodeAs[Manner](Manner{}.Spec(), data) }
+
+// Level 设置亮度等级 (fPort 13)
+type Level struct {
+	Level uint16
+}
+
+func (Level) Spec() Spec { return mustSpec("level") }
+
+func (m Level) Encode() ([]byte, error) { return binary.BigEndian.AppendUint16(nil, m.Level), nil }
+
+// DecodeLevel 解码亮度等级设置
+func DecodeLevel(data []byte) (Level, error) { return decodeAs[Level](Level{}.Spec(), data) }
+
+// Switch 设置开关 (fPort 14)
+type Switch struct {
+	Switch uint8
+}
+
+func (Switch) Spec() Spec { return mustSpec("switch") }
+
+func (m Switch) Encode() ([]byte, error) { return []byte{m.Switch}, nil }
+
+// DecodeSwitch 解码开关设置
+func DecodeSwitch(data []byte) (Switch, error) { return decodeAs[Switch](Switch{}.Spec(), data) }
+
+// Overall 整体设置 (fPort 15)
+type Overall struct {
+	Color       uint8
+	Frequency   int
+	Level       uint16
+	Manner      uint8
+	RadarEnable uint8
+}
+
+func (Overall) Spec() Spec { return mustSpec("overall") }
+
+func (m Overall) Encode() ([]byte, error) {
+	freq, err := encodeFrequency(m.Frequency)
+	if err != nil {
+		return nil, err
+	}
+	return []byte{m.Color, freq, byte(m.Level >> 8), byte(m.Level), m.Manner, m.RadarEnable}, nil
+}
+
+// DecodeOverall 解码整体设置
+func DecodeOverall(data []byte) (Overall, error) { return decodeAs[Overall](Overall{}.Spec(), data) }
+
+func decodeOverall(b []byte) Overall {
+	return Overall{Color: b[0], Frequency: int(b[1]), Level: be16(b[2:]), Manner: b[4], RadarEnable: b[5]}
+}
+
+// MulticastKeys 下发设备加入多播组所需的地址与会话密钥 (fPort 16)
+type MulticastKeys struct {
+	DevAddr [4]byte
+	AppSKey [16]byte
+	NwkSKey [16]byte
+}
+
+func (MulticastKeys) Spec() Spec { return mustSpec("multicastKeys") }
+
+func (m MulticastKeys) Encode() ([]byte, error) {
+	payload := make([]byte, 0, 36)
+	payload = append(payload, m.DevAddr[:]...)
+	payload = append(payload, m.AppSKey[:]...)
+	return append(payload, m.NwkSKey[:]...), nil
+}
+
+// DecodeMulticastKeys 解码多播组密钥
+func DecodeMulticastKeys(data []byte) (MulticastKeys, error) {
+	return decodeAs[MulticastKeys](MulticastKeys{}.Spec(), data)
+}
+
+func decodeMulticastKeys(b []byte) MulticastKeys {
+	var m MulticastKeys
+	copy(m.DevAddr[:], b[0:4])
+	copy(m.AppSKey[:], b[4:20])
+	copy(m.NwkSKey[:], b[20:36])
+	return m
+}
+
+// AccelerationMode 设置加速度检测开关 (fPort 17)
+type AccelerationMode struct {
+	Enable uint8
+}
+
+func (AccelerationMode) Spec() Spec { return mustSpec("accelerationMode") }
+
+func (m AccelerationMode) Encode() ([]byte, error) { return []byte{m.Enable}, nil }
+
+// DecodeAccelerationMode 解码加速度检测开关
+func DecodeAccelerationMode(data []byte) (AccelerationMode, error) {
+	return decodeAs[AccelerationMode](AccelerationMode{}.Spec(), data)
+}
+
+// Character 设置字符显示开关 (fPort 18)
+type Character struct {
+	Switch uint8
+}
+
+func (Character) Spec() Spec { return mustSpec("character") }
+
+func (m Character) Encode() ([]byte, error) { return []byte{m.Switch}, nil }
+
+// DecodeCharacter 解码字符显示开关
+func DecodeCharacter(data []byte) (Character, error) {
+	return decodeAs[Character](Character{}.Spec(), data)
+}
+
+// Brightness 设置屏幕亮度 (fPort 19)
+type Brightness struct {
+	Brightness uint8
+}
+
+func (Brightness) Spec() Spec { return mustSpec("brightness") }
+
+func (m Brightness) Encode() ([]byte, error) { return []byte{m.Brightness}, nil }
+
+// DecodeBrightness 解码屏幕亮度设置
+func DecodeBrightness(data []byte) (Brightness, error) {
+	return decodeAs[Brightness](Brightness{}.Spec(), data)
+}
+
+// TimeSync 时间同步应答 (fPort 9)，MsSinceMidnight 为自当天午夜起的毫秒数。
+// 设备配置文件约定其他时间格式时改用 UnixTimeSync 或 GPSTimeSync，三者共用 fPort 9 与 4 字节布局，
+// 由固件按约定解读；解码 fPort 9 时一律得到 TimeSync
+type TimeSync struct {
+	MsSinceMidnight uint32
+}
+
+// NewTimeSync 根据 t 所在时区的墙上时间计算自午夜起的毫秒数。按时分秒而非距午夜的时长计算，
+// 夏令时切换当天也不会超出 [0, 86400000)
+func NewTimeSync(t time.Time) TimeSync {
+	ms := ((t.Hour()*60+t.Minute())*60+t.Second())*1000 + t.Nanosecond()/int(time.Millisecond)
+	return TimeSync{MsSinceMidnight: uint32(ms)}
+}
+
+func (TimeSync) Spec() Spec { return mustSpec("timeSync") }
+
+func (m TimeSync) Encode() ([]byte, error) {
+	return binary.BigEndian.AppendUint32(nil, m.MsSinceMidnight), nil
+}
+
+// DecodeTimeSync 解码时间同步应答
+func DecodeTimeSync(data []byte) (TimeSync, error) {
+	return decodeAs[TimeSync](TimeSync{}.Spec(), data)
+}
+
+func decodeTimeSync(b []byte) TimeSync {
+	return TimeSync{MsSinceMidnight: binary.BigEndian.Uint32(b)}
+}
+
+// UnixTimeSync 以 Unix 秒表示的时间同步应答 (fPort 9)
+type UnixTimeSync struct {
+	Seconds uint32
+}
+
+func (UnixTimeSync) Spec() Spec { return mustSpec("timeSync") }
+
+func (m UnixTimeSync) Encode() ([]byte, error) {
+	return binary.BigEndian.AppendUint32(nil, m.Seconds), nil
+}
+
+// GPSTimeSync 以自 GPS 零点起的秒数表示的时间同步应答 (fPort 9)，与 GPS 接收机一致，不扣除闰秒
+type GPSTimeSync struct {
+	Seconds uint32
+}
+
+func (GPSTimeSync) Spec() Spec { return mustSpec("timeSync") }
+
+func (m GPSTimeSync) Encode() ([]byte, error) {
+	return binary.BigEndian.AppendUint32(nil, m.Seconds), nil
+}
+
+// ReadConfig 请求设备上报当前配置 (fPort 20)，设备以 ConfigReport 应答
+type ReadConfig struct{}
+
+func (ReadConfig) Spec() Spec { return mustSpec("readConfig") }
+
+func (ReadConfig) Encode() ([]byte, error) { return []byte{CodeConfigReport}, nil }
@@ -0,0 +1,179 @@
+// Package protocol 定义诱导灯与服务器之间的二进制负载：下行命令按 fPort 区分，
+// 上行命令以首字节命令码区分。Table 是所有负载端口与字节布局的唯一描述
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 下行命令使用的 fPort
+const (
+	PortTimeSync         uint32 = 9
+	PortFrequency        uint32 = 10
+	PortColor            uint32 = 11
+	PortManner           uint32 = 12
+	PortLevel            uint32 = 13
+	PortSwitch           uint32 = 14
+	PortOverall          uint32 = 15
+	PortMulticastKeys    uint32 = 16
+	PortAccelerationMode uint32 = 17
+	PortCharacter        uint32 = 18
+	PortBrightness       uint32 = 19
+	PortReadConfig       uint32 = 20
+)
+
+// 上行命令码（负载首字节）
+const (
+	CodeSkew          byte = 0x04
+	CodeAcceleration  byte = 0x05
+	CodeTimeSync      byte = 0x06
+	CodeManualAlarm   byte = 0x07
+	CodeAccidentAlarm byte = 0x08
+	CodeHeartbeat     byte = 0x09
+	CodeConfigReport  byte = 0x0A
+)
+
+// Direction 表示负载的传输方向
+type Direction string
+
+const (
+	Downlink Direction = "downlink"
+	Uplink   Direction = "uplink"
+)
+
+// Spec 描述一种负载：下行以 FPort 标识，上行以 Code 标识；
+// Size 为负载的最小长度（上行含命令码），Layout 为字节布局说明。
+// Since/Until 为支持该布局的协议版本范围，Until 为 0 表示沿用至最新版本；
+// 后续版本更改布局时，为同名负载新增一条描述并设置 encode 与 decode
+type Spec struct {
+	Name      string
+	Direction Direction
+	FPort     uint32
+	Code      byte
+	Size      int
+	Layout    string
+	Since     Version
+	Until     Version
+	encode    func(msg Message) ([]byte, error) // 为 nil 时使用 Message.Encode
+	decode    func(data []byte) Message
+}
+
+// Table 列出全部下行与上行负载
+var Table = []Spec{
+	{Name: "timeSync", Direction: Downlink, FPort: PortTimeSync, Size: 4, Since: V1,
+		Layout: "[自午夜起的毫秒数 uint32 BE]",
+		decode: func(b []byte) Message { return decodeTimeSync(b) }},
+	{Name: "frequency", Direction: Downlink, FPort: PortFrequency, Size: 1, Since: V1,
+		Layout: "[每分钟闪烁次数 30|60|120]",
+		decode: func(b []byte) Message { return Frequency{PerMinute: int(b[0])} }},
+	{Name: "color", Direction: Downlink, FPort: PortColor, Size: 1, Since: V1,
+		Layout: "[颜色 0|1]",
+		decode: func(b []byte) Message { return Color{Color: b[0]} }},
+	{Name: "manner", Direction: Downlink, FPort: PortManner, Size: 1, Since: V1,
+		Layout: "[亮灯方式 0|1]",
+		decode: func(b []byte) Message { return Manner{Manner: b[0]} }},
+	{Name: "level", Direction: Downlink, FPort: PortLevel, Size: 2, Since: V1,
+		Layout: "[亮度 uint16 BE]",
+		decode: func(b []byte) Message { return Level{Level: be16(b)} }},
+	{Name: "switch", Direction: Downlink, FPort: PortSwitch, Size: 1, Since: V1,
+		Layout: "[开关 0|1]",
+		decode: func(b []byte) Message { return Switch{Switch: b[0]} }},
+	{Name: "overall", Direction: Downlink, FPort: PortOverall, Size: 6, Since: V1,
+		Layout: "[颜色][频率][亮度 uint16 BE][亮灯方式][雷达]",
+		decode: func(b []byte) Message { return decodeOverall(b) }},
+	{Name: "multicastKeys", Direction: Downlink, FPort: PortMulticastKeys, Size: 36, Since: V1,
+		Layout: "[DevAddr 4B][AppSKey 16B][NwkSKey 16B]",
+		decode: func(b []byte) Message { return decodeMulticastKeys(b) }},
+	{Name: "accelerationMode", Direction: Downlink, FPort: PortAccelerationMode, Size: 1, Since: V1,
+		Layout: "[启用 0|1]",
+		decode: func(b []byte) Message { return AccelerationMode{Enable: b[0]} }},
+	{Name: "character", Direction: Downlink, FPort: PortCharacter, Size: 1, Since: V1,
+		Layout: "[字符开关 0|1]",
+		decode: func(b []byte) Message { return Character{Switch: b[0]} }},
+	{Name: "brightness", Direction: Downlink, FPort: PortBrightness, Size: 1, Since: V1,
+		Layout: "[亮度 0-255]",
+		decode: func(b []byte) Message { return Brightness{Brightness: b[0]} }},
+	{Name: "readConfig", Direction: Downlink, FPort: PortReadConfig, Size: 1, Since: V2,
+		Layout: "[0x0A]",
+		decode: func(b []byte) Message { return ReadConfig{} }},
+
+	{Name: "skew", Direction: Uplink, Code: CodeSkew, Size: 7, Since: V1,
+		Layout: "[0x04][X int16 LE][Y int16 LE][Z int16 LE]",
+		decode: func(b []byte) Message { return Skew(decodeAxes(b)) }},
+	{Name: "acceleration", Direction: Uplink, Code: CodeAcceleration, Size: 7, Since: V1,
+		Layout: "[0x05][X int16 LE][Y int16 LE][Z int16 LE]",
+		decode: func(b []byte) Message { return Acceleration(decodeAxes(b)) }},
+	{Name: "timeSyncRequest", Direction: Uplink, Code: CodeTimeSync, Size: 1, Since: V1,
+		Layout: "[0x06][可选：设备时钟，自午夜起的毫秒数 uint32 BE]",
+		decode: func(b []byte) Message { return decodeTimeSyncRequest(b) }},
+	{Name: "manualAlarm", Direction: Uplink, Code: CodeManualAlarm, Size: 1, Since: V1,
+		Layout: "[0x07]",
+		decode: func(b []byte) Message { return ManualAlarm{} }},
+	{Name: "accidentAlarm", Direction: Uplink, Code: CodeAccidentAlarm, Size: 1, Since: V1,
+		Layout: "[0x08]",
+		decode: func(b []byte) Message { return AccidentAlarm{} }},
+	{Name: "heartbeat", Direction: Uplink, Code: CodeHeartbeat, Size: 1, Since: V1,
+		Layout: "[0x09]",
+		decode: func(b []byte) Message { return Heartbeat{} }},
+	{Name: "configReport", Direction: Uplink, Code: CodeConfigReport, Size: 10, Since: V2,
+		Layout: "[0x0A][颜色][频率][亮度 uint16 BE][亮灯方式][雷达][固件主版本][次版本][修订号]",
+		decode: func(b []byte) Message { return decodeConfigReport(b) }},
+}
+
+var (
+	// ErrUnknownPayload 表示 fPort 或命令码不在 Table 中
+	ErrUnknownPayload = errors.New("unknown payload")
+	// ErrShortPayload 表示负载长度小于布局要求
+	ErrShortPayload = errors.New("payload too short")
+)
+
+// Message 是一种可编码的负载
+type Message interface {
+	// Spec 返回负载在 Table 中的描述
+	Spec() Spec
+	// Encode 按 Table 中的布局编码负载，上行负载包含命令码
+	Encode() ([]byte, error)
+}
+
+// mustSpec 返回负载在最新协议版本中的描述，供各消息类型的 Spec 方法使用
+func mustSpec(name string) Spec {
+	for _, s := range Table {
+		if s.Name == name && s.supports(LatestVersion) {
+			return s
+		}
+	}
+	panic("protocol: unknown spec " + name)
+}
+
+// DecodeDownlink 按最新协议版本解码下行负载
+func DecodeDownlink(fPort uint32, data []byte) (Message, error) {
+	return Codec{version: LatestVersion}.DecodeDownlink(fPort, data)
+}
+
+// DecodeUplink 按最新协议版本解码上行负载
+func DecodeUplink(data []byte) (Message, error) {
+	return Codec{version: LatestVersion}.DecodeUplink(data)
+}
+
+// decode 检查长度后按描述解码
+func decode(spec Spec, data []byte) (Message, error) {
+	if len(data) < spec.Size {
+		return nil, fmt.Errorf("%w: %s requires %d bytes, got %d", ErrShortPayload, spec.Name, spec.Size, len(data))
+	}
+	return spec.decode(data), nil
+}
+
+// decodeAs 解码并断言为具体类型，供各类型的 Decode 函数使用
+func decodeAs[T Message](spec Spec, data []byte) (T, error) {
+	var zero T
+	msg, err := decode(spec, data)
+	if err != nil {
+		return zero, err
+	}
+	return msg.(T), nil
+}
+
+func be16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
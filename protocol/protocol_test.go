@@ -0,0 +1,236 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	keys := MulticastKeys{DevAddr: [4]byte{0x01, 0x02, 0x03, 0x04}}
+	for i := range keys.AppSKey {
+		keys.AppSKey[i], keys.NwkSKey[i] = byte(i), byte(0xF0+i)
+	}
+
+	tests := []struct {
+		msg  Message
+		want []byte
+	}{
+		{Color{Color: 1}, []byte{0x01}},
+		{Frequency{PerMinute: 60}, []byte{0x3C}},
+		{Frequency{PerMinute: 120}, []byte{0x78}},
+		{Manner{Manner: 1}, []byte{0x01}},
+		{Level{Level: 7000}, []byte{0x1B, 0x58}},
+		{Switch{Switch: 0}, []byte{0x00}},
+		{Overall{Color: 1, Frequency: 30, Level: 2000, Manner: 0, RadarEnable: 1}, []byte{0x01, 0x1E, 0x07, 0xD0, 0x00, 0x01}},
+		{keys, nil},
+		{AccelerationMode{Enable: 1}, []byte{0x01}},
+		{Character{Switch: 1}, []byte{0x01}},
+		{Brightness{Brightness: 200}, []byte{0xC8}},
+		{TimeSync{MsSinceMidnight: 86399999}, []byte{0x05, 0x26, 0x5B, 0xFF}},
+		{ReadConfig{}, []byte{0x0A}},
+		{Skew{X: -16, Y: 32, Z: 16384}, []byte{0x04, 0xF0, 0xFF, 0x20, 0x00, 0x00, 0x40}},
+		{Acceleration{X: 1, Y: -1, Z: -16384}, []byte{0x05, 0x01, 0x00, 0xFF, 0xFF, 0x00, 0xC0}},
+		{TimeSyncRequest{}, []byte{0x06}},
+		{ManualAlarm{}, []byte{0x07}},
+		{AccidentAlarm{}, []byte{0x08}},
+		{Heartbeat{}, []byte{0x09}},
+		{ConfigReport{Overall: Overall{Color: 0, Frequency: 120, Level: 500, Manner: 1, RadarEnable: 0}, Firmware: [3]uint8{1, 2, 3}},
+			[]byte{0x0A, 0x00, 0x78, 0x01, 0xF4, 0x01, 0x00, 0x01, 0x02, 0x03}},
+	}
+
+	for _, tt := range tests {
+		spec := tt.msg.Spec()
+		data, err := tt.msg.Encode()
+		if err != nil {
+			t.Fatalf("%s: 编码失败: %v", spec.Name, err)
+		}
+		if tt.want != nil && !bytes.Equal(data, tt.want) {
+			t.Errorf("%s: 编码为 % X，期望 % X", spec.Name, data, tt.want)
+		}
+		if len(data) != spec.Size {
+			t.Errorf("%s: 编码长度 %d 与 Table 中的 %d 不一致", spec.Name, len(data), spec.Size)
+		}
+
+		var got Message
+		if spec.Direction == Downlink {
+			got, err = DecodeDownlink(spec.FPort, data)
+		} else {
+			got, err = DecodeUplink(data)
+		}
+		if err != nil {
+			t.Fatalf("%s: 解码失败: %v", spec.Name, err)
+		}
+		if !reflect.DeepEqual(got, tt.msg) {
+			t.Errorf("%s: 解码为 %+v，期望 %+v", spec.Name, got, tt.msg)
+		}
+	}
+}
+
+func TestTableUnique(t *testing.T) {
+	for v := V1; v <= LatestVersion; v++ {
+		names := make(map[string]bool)
+		ports := make(map[uint32]bool)
+		codes := make(map[byte]bool)
+		for _, s := range Table {
+			if !s.supports(v) {
+				continue
+			}
+			if names[s.Name] {
+				t.Errorf("%s: 重复的负载名称 %s", v, s.Name)
+			}
+			names[s.Name] = true
+			switch s.Direction {
+			case Downlink:
+				if ports[s.FPort] {
+					t.Errorf("%s: 重复的下行 fPort %d", v, s.FPort)
+				}
+				ports[s.FPort] = true
+			case Uplink:
+				if codes[s.Code] {
+					t.Errorf("%s: 重复的上行命令码 0x%02X", v, s.Code)
+				}
+				codes[s.Code] = true
+			}
+		}
+	}
+}
+
+func TestCodecVersions(t *testing.T) {
+	v1, err := ForVersion(V1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := v1.Encode(ReadConfig{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("V1 编码读取配置返回 %v，期望 ErrUnsupported", err)
+	}
+	if _, err := v1.DecodeUplink([]byte{0x0A, 0, 30, 0, 0, 0, 0, 1, 0, 0}); !errors.Is(err, ErrUnknownPayload) {
+		t.Errorf("V1 解码配置应答返回 %v，期望 ErrUnknownPayload", err)
+	}
+	fPort, data, err := v1.Encode(Level{Level: 500})
+	if err != nil || fPort != PortLevel || !bytes.Equal(data, []byte{0x01, 0xF4}) {
+		t.Errorf("V1 编码亮度为 %d % X %v", fPort, data, err)
+	}
+
+	v2, _ := ForVersion(V2)
+	if fPort, _, err := v2.Encode(ReadConfig{}); err != nil || fPort != PortReadConfig {
+		t.Errorf("V2 编码读取配置为 %d %v", fPort, err)
+	}
+	if _, err := ForVersion(Version(99)); err == nil {
+		t.Error("未知版本应返回错误")
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+		ok   bool
+	}{
+		{"1", V1, true},
+		{"v2", V2, true},
+		{" V2 ", V2, true},
+		{"3", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseVersion(%q) = %v, %v，期望 %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := DecodeDownlink(99, []byte{0x00}); !errors.Is(err, ErrUnknownPayload) {
+		t.Errorf("未知 fPort 返回 %v，期望 ErrUnknownPayload", err)
+	}
+	if _, err := DecodeUplink([]byte{0xFF}); !errors.Is(err, ErrUnknownPayload) {
+		t.Errorf("未知命令码返回 %v，期望 ErrUnknownPayload", err)
+	}
+	if _, err := DecodeAcceleration([]byte{0x05, 0x01}); !errors.Is(err, ErrShortPayload) {
+		t.Errorf("加速度数据不足返回 %v，期望 ErrShortPayload", err)
+	}
+	if _, err := DecodeUplink(nil); !errors.Is(err, ErrShortPayload) {
+		t.Errorf("空负载返回 %v，期望 ErrShortPayload", err)
+	}
+	if _, err := (Frequency{PerMinute: 45}).Encode(); err == nil {
+		t.Error("不支持的频率应编码失败")
+	}
+}
+
+func TestNewTimeSync(t *testing.T) {
+	cst := time.FixedZone("CST", 8*60*60)
+	ts := NewTimeSync(time.Date(2024, 3, 1, 0, 0, 1, 500_000_000, cst))
+	if ts.MsSinceMidnight != 1500 {
+		t.Errorf("MsSinceMidnight = %d，期望 1500", ts.MsSinceMidnight)
+	}
+}
+
+func TestTimeSyncRequestClock(t *testing.T) {
+	clock := uint32(3_600_000)
+	data, _ := TimeSyncRequest{DeviceClock: &clock}.Encode()
+	if !bytes.Equal(data, []byte{0x06, 0x00, 0x36, 0xEE, 0x80}) {
+		t.Fatalf("编码为 % X", data)
+	}
+	msg, err := DecodeUplink(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.(TimeSyncRequest).DeviceClock; got == nil || *got != clock {
+		t.Errorf("DeviceClock = %v，期望 %d", got, clock)
+	}
+}
+
+func TestNewTimeSyncMessage(t *testing.T) {
+	// 2024-01-01T00:00:00Z 对应的 GPS 时间为 1388102418 秒
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := FromGPSTime(1388102418 * time.Second); !got.Equal(at) {
+		t.Errorf("FromGPSTime = %v，期望 %v", got, at)
+	}
+
+	tests := []struct {
+		format TimeFormat
+		want   Message
+	}{
+		{FormatMsSinceMidnight, TimeSync{MsSinceMidnight: 8 * 60 * 60 * 1000}},
+		{FormatUnixSeconds, UnixTimeSync{Seconds: 1704067200}},
+		{FormatGPSSeconds, GPSTimeSync{Seconds: 1388102418}},
+	}
+	cst := time.FixedZone("CST", 8*60*60)
+	for _, tt := range tests {
+		got, err := NewTimeSyncMessage(at.In(cst), tt.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: 得到 %+v，期望 %+v", tt.format, got, tt.want)
+		}
+		if fPort, data, err := (Codec{version: V1}).Encode(got); err != nil || fPort != PortTimeSync || len(data) != 4 {
+			t.Errorf("%s: 编码为 fPort %d % X (%v)", tt.format, fPort, data, err)
+		}
+	}
+	if _, err := ParseTimeFormat("iso8601"); err == nil {
+		t.Error("未知时间格式应解析失败")
+	}
+}
+
+func TestNewTimeSyncRollover(t *testing.T) {
+	cst := time.FixedZone("CST", 8*60*60)
+	// 23:59:59.9 加上补偿的 1.2 秒后落在次日，应答应为次日自午夜起的毫秒数
+	target := time.Date(2024, 3, 1, 23, 59, 59, 900_000_000, cst).Add(1200 * time.Millisecond)
+	if ts := NewTimeSync(target); ts.MsSinceMidnight != 1100 {
+		t.Errorf("跨午夜 MsSinceMidnight = %d，期望 1100", ts.MsSinceMidnight)
+	}
+
+	// 夏令时结束当天有 25 小时，按墙上时间计算仍不超过一天
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	if ts := NewTimeSync(time.Date(2024, 10, 27, 23, 59, 59, 0, berlin)); ts.MsSinceMidnight != 86399000 {
+		t.Errorf("夏令时切换当天 MsSinceMidnight = %d，期望 86399000", ts.MsSinceMidnight)
+	}
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeFormat 是时间同步应答负载的时间格式，由设备配置文件决定
+type TimeFormat string
+
+const (
+	// FormatMsSinceMidnight 为设备所在时区自午夜起的毫秒数，第一代固件使用的格式
+	FormatMsSinceMidnight TimeFormat = "ms_since_midnight"
+	// FormatUnixSeconds 为 Unix 秒，与时区无关
+	FormatUnixSeconds TimeFormat = "unix_seconds"
+	// FormatGPSSeconds 为自 GPS 零点起的秒数，与时区无关
+	FormatGPSSeconds TimeFormat = "gps_seconds"
+)
+
+// ParseTimeFormat 解析时间格式，空字符串视为 FormatMsSinceMidnight
+func ParseTimeFormat(s string) (TimeFormat, error) {
+	switch f := TimeFormat(s); f {
+	case "":
+		return FormatMsSinceMidnight, nil
+	case FormatMsSinceMidnight, FormatUnixSeconds, FormatGPSSeconds:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown time format: %q", s)
+}
+
+// NewTimeSyncMessage 按格式生成 t 时刻的时间同步应答，自午夜起的毫秒数按 t 所在时区计算
+func NewTimeSyncMessage(t time.Time, format TimeFormat) (Message, error) {
+	switch format {
+	case FormatMsSinceMidnight, "":
+		return NewTimeSync(t), nil
+	case FormatUnixSeconds:
+		return UnixTimeSync{Seconds: uint32(t.Unix())}, nil
+	case FormatGPSSeconds:
+		return GPSTimeSync{Seconds: uint32(ToGPSTime(t) / time.Second)}, nil
+	}
+	return nil, fmt.Errorf("unknown time format: %q", format)
+}
+
+// GPSEpoch 为 GPS 时间零点
+var GPSEpoch = time.Date(1980, 1, 6, 0, 0, 0, 0, time.UTC)
+
+// GPSLeapSeconds 为 GPS 时间领先 UTC 的闰秒数，自 2017 年起为 18 秒
+const GPSLeapSeconds = 18 * time.Second
+
+// FromGPSTime 将自 GPS 零点起的时长换算为 UTC 时间
+func FromGPSTime(d time.Duration) time.Time {
+	return GPSEpoch.Add(d - GPSLeapSeconds)
+}
+
+// ToGPSTime 返回 t 自 GPS 零点起的时长
+func ToGPSTime(t time.Time) time.Duration {
+	return t.Sub(GPSEpoch) + GPSLeapSeconds
+}
@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// AccelerationScale 是加速度原始值到 g 的换算系数（±2g 量程下 0.061 mg/LSB）
+const AccelerationScale = 0.061 / 1000
+
+// Axes 是一次三轴采样的原始值
+type Axes struct {
+	X, Y, Z int16
+}
+
+// G 返回换算为 g 的三轴值
+func (a Axes) G() (x, y, z float64) {
+	return float64(a.X) * AccelerationScale, float64(a.Y) * AccelerationScale, float64(a.Z) * AccelerationScale
+}
+
+func (a Axes) encode(code byte) []byte {
+	payload := []byte{code}
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(a.X))
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(a.Y))
+	return binary.LittleEndian.AppendUint16(payload, uint16(a.Z))
+}
+
+func decodeAxes(b []byte) Axes {
+	return Axes{
+		X: int16(binary.LittleEndian.Uint16(b[1:])),
+		Y: int16(binary.LittleEndian.Uint16(b[3:])),
+		Z: int16(binary.LittleEndian.Uint16(b[5:])),
+	}
+}
+
+// Skew 偏移上报 (0x04)，为灯体静止时的三轴重力分量
+type Skew Axes
+
+func (Skew) Spec() Spec { return mustSpec("skew") }
+
+func (m Skew) Encode() ([]byte, error) { return Axes(m).encode(CodeSkew), nil }
+
+// DecodeSkew 解码偏移上报
+func DecodeSkew(data []byte) (Skew, error) { return decodeAs[Skew](Skew{}.Spec(), data) }
+
+// Acceleration 三轴加速度上报 (0x05)
+type Acceleration Axes
+
+func (Acceleration) Spec() Spec { return mustSpec("acceleration") }
+
+func (m Acceleration) Encode() ([]byte, error) { return Axes(m).encode(CodeAcceleration), nil }
+
+// DecodeAcceleration 解码三轴加速度上报
+func DecodeAcceleration(data []byte) (Acceleration, error) {
+	return decodeAs[Acceleration](Acceleration{}.Spec(), data)
+}
+
+// TimeSyncRequest 时间同步请求 (0x06)。固件可在命令码后附带发送时刻的本机时钟（自午夜起的毫秒数），
+// 用于测量时钟漂移，未附带时 DeviceClock 为 nil
+type TimeSyncRequest struct {
+	DeviceClock *uint32
+}
+
+func (TimeSyncRequest) Spec() Spec { return mustSpec("timeSyncRequest") }
+
+func (m TimeSyncRequest) Encode() ([]byte, error) {
+	data := []byte{CodeTimeSync}
+	if m.DeviceClock != nil {
+		data = binary.BigEndian.AppendUint32(data, *m.DeviceClock)
+	}
+	return data, nil
+}
+
+func decodeTimeSyncRequest(b []byte) TimeSyncRequest {
+	if len(b) < 5 {
+		return TimeSyncRequest{}
+	}
+	clock := binary.BigEndian.Uint32(b[1:])
+	return TimeSyncRequest{DeviceClock: &clock}
+}
+
+// ManualAlarm 人工报警 (0x07)
+type ManualAlarm struct{}
+
+func (ManualAlarm) Spec() Spec { return mustSpec("manualAlarm") }
+
+func (ManualAlarm) Encode() ([]byte, error) { return []byte{CodeManualAlarm}, nil }
+
+// AccidentAlarm 事故报警 (0x08)
+type AccidentAlarm struct{}
+
+func (AccidentAlarm) Spec() Spec { return mustSpec("accidentAlarm") }
+
+func (AccidentAlarm) Encode() ([]byte, error) { return []byte{CodeAccidentAlarm}, nil }
+
+// Heartbeat 心跳 (0x09)
+type Heartbeat struct{}
+
+func (Heartbeat) Spec() Spec { return mustSpec("heartbeat") }
+
+func (Heartbeat) Encode() ([]byte, error) { return []byte{CodeHeartbeat}, nil }
+
+// ConfigReport 设备配置应答 (0x0A)
+type ConfigReport struct {
+	Overall
+	Firmware [3]uint8 // 主版本、次版本、修订号
+}
+
+func (ConfigReport) Spec() Spec { return mustSpec("configReport") }
+
+func (m ConfigReport) Encode() ([]byte, error) {
+	overall, err := m.Overall.Encode()
+	if err != nil {
+		return nil, err
+	}
+	payload := append([]byte{CodeConfigReport}, overall...)
+	return append(payload, m.Firmware[:]...), nil
+}
+
+// FirmwareVersion 返回形如 1.2.3 的固件版本
+func (m ConfigReport) FirmwareVersion() string {
+	return fmt.Sprintf("%d.%d.%d", m.Firmware[0], m.Firmware[1], m.Firmware[2])
+}
+
+// DecodeConfigReport 解码设备配置应答
+func DecodeConfigReport(data []byte) (ConfigReport, error) {
+	return decodeAs[ConfigReport](ConfigReport{}.Spec(), data)
+}
+
+func decodeConfigReport(b []byte) ConfigReport {
+	return ConfigReport{Overall: decodeOverall(b[1:7]), Firmware: [3]uint8{b[7], b[8], b[9]}}
+}
@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version 是灯具固件的协议版本，不同版本支持的负载及其布局由 Table 中的 Since/Until 决定
+type Version int
+
+const (
+	// V1 为第一代固件，不支持读取配置
+	V1 Version = 1
+	// V2 增加读取配置命令与配置应答
+	V2 Version = 2
+
+	// LatestVersion 为当前最新的协议版本
+	LatestVersion = V2
+)
+
+// ErrUnsupported 表示负载不被设备的协议版本支持
+var ErrUnsupported = errors.New("not supported by protocol version")
+
+// ParseVersion 解析 "2"、"v2" 形式的协议版本
+func ParseVersion(s string) (Version, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "v"))
+	if err != nil || !Version(n).Known() {
+		return 0, fmt.Errorf("unknown protocol version: %q", s)
+	}
+	return Version(n), nil
+}
+
+// Known 判断是否为已定义的协议版本
+func (v Version) Known() bool {
+	return v >= V1 && v <= LatestVersion
+}
+
+func (v Version) String() string {
+	return "v" + strconv.Itoa(int(v))
+}
+
+// supports 判断负载描述是否适用于版本 v
+func (s Spec) supports(v Version) bool {
+	return v >= s.Since && (s.Until == 0 || v <= s.Until)
+}
+
+// Codec 按某一协议版本编解码负载
+type Codec struct {
+	version Version
+}
+
+// ForVersion 返回指定协议版本的编解码器
+func ForVersion(v Version) (Codec, error) {
+	if !v.Known() {
+		return Codec{}, fmt.Errorf("unknown protocol version: %d", v)
+	}
+	return Codec{version: v}, nil
+}
+
+// Version 返回编解码器的协议版本
+func (c Codec) Version() Version {
+	return c.version
+}
+
+// spec 查找该版本下的负载描述
+func (c Codec) spec(dir Direction, fPort uint32, code byte) (Spec, bool) {
+	for _, s := range Table {
+		if s.Direction == dir && s.FPort == fPort && s.Code == code && s.supports(c.version) {
+			return s, true
+		}
+	}
+	return Spec{}, false
+}
+
+// Supports 判断该版本是否支持此负载
+func (c Codec) Supports(msg Message) bool {
+	_, ok := c.lookupMessage(msg)
+	return ok
+}
+
+func (c Codec) lookupMessage(msg Message) (Spec, bool) {
+	name := msg.Spec().Name
+	for _, s := range Table {
+		if s.Name == name && s.supports(c.version) {
+			return s, true
+		}
+	}
+	return Spec{}, false
+}
+
+// Encode 按该版本的布局编码负载，返回下发所用的 fPort
+func (c Codec) Encode(msg Message) (uint32, []byte, error) {
+	spec, ok := c.lookupMessage(msg)
+	if !ok {
+		return 0, nil, fmt.Errorf("%s is %w %s", msg.Spec().Name, ErrUnsupported, c.version)
+	}
+	var data []byte
+	var err error
+	if spec.encode != nil {
+		data, err = spec.encode(msg)
+	} else {
+		data, err = msg.Encode()
+	}
+	return spec.FPort, data, err
+}
+
+// DecodeDownlink 按该版本解码下行负载
+func (c Codec) DecodeDownlink(fPort uint32, data []byte) (Message, error) {
+	spec, ok := c.spec(Downlink, fPort, 0)
+	if !ok {
+		return nil, fmt.Errorf("%w: fPort %d in protocol %s", ErrUnknownPayload, fPort, c.version)
+	}
+	return decode(spec, data)
+}
+
+// DecodeUplink 按该版本解码上行负载
+func (c Codec) DecodeUplink(data []byte) (Message, error) {
+	if len(data) == 0 {
+		return nil, ErrShortPayload
+	}
+	spec, ok := c.spec(Uplink, 0, data[0])
+	if !ok {
+		return nil, fmt.Errorf("%w: command code 0x%02X in protocol %s", ErrUnknownPayload, data[0], c.version)
+	}
+	return decode(spec, data)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/integration"
+	"github.com/rs/zerolog/log"
+)
+
+// protocolVersionTag 是 ChirpStack 设备或设备配置文件中声明协议版本的标签名
+const protocolVersionTag = "protocolVersion"
+
+// protocolVersion 返回设备的协议版本：优先使用注册表中的声明，
+// 其次是设备标签 protocolVersion 中最近一次上报的版本，最后使用配置的默认版本
+func (h *Handler) protocolVersion(devEUI string) protocol.Version {
+	if stakeNo, ok := h.registry.StakeNo(devEUI); ok {
+		if d, ok := h.registry.Get(stakeNo); ok && d.ProtocolVersion != 0 {
+			return protocol.Version(d.ProtocolVersion)
+		}
+	}
+	if st, ok := h.state.Get(devEUI); ok && st.ProtocolVersion != 0 {
+		return protocol.Version(st.ProtocolVersion)
+	}
+	return protocol.Version(h.config.ProtocolVersion)
+}
+
+// codecFor 返回设备协议版本对应的编解码器，版本无效时退回默认版本
+func (h *Handler) codecFor(devEUI string) protocol.Codec {
+	codec, err := protocol.ForVersion(h.protocolVersion(devEUI))
+	if err != nil {
+		log.Warn().Err(err).Str("devEUI", devEUI).Int("default", h.config.ProtocolVersion).Msg("设备协议版本无效，使用默认版本")
+		codec, _ = protocol.ForVersion(protocol.Version(h.config.ProtocolVersion))
+	}
+	return codec
+}
+
+// learnProtocolVersion 记录事件 deviceInfo 标签中声明的协议版本，标签被移除时清除已记录的版本。
+// ChirpStack 会将设备配置文件的标签合并进 deviceInfo.tags
+func (h *Handler) learnProtocolVersion(info *integration.DeviceInfo) {
+	devEUI := info.GetDevEui()
+	raw, ok := info.GetTags()[protocolVersionTag]
+	if !ok {
+		if st, ok := h.state.Get(devEUI); ok && st.ProtocolVersion != 0 {
+			h.state.Update(devEUI, func(st *services.DeviceState) {
+				st.ProtocolVersion = 0
+			})
+			log.Info().Str("devEUI", devEUI).Msg("设备标签已不再声明协议版本，改用注册表或默认版本")
+		}
+		return
+	}
+	v, err := protocol.ParseVersion(raw)
+	if err != nil {
+		log.Warn().Err(err).Str("devEUI", devEUI).Msg("设备标签中的协议版本无效，已忽略")
+		return
+	}
+	if st, ok := h.state.Get(devEUI); ok && st.ProtocolVersion == int(v) {
+		return
+	}
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.ProtocolVersion = int(v)
+	})
+	log.Info().Str("devEUI", devEUI).Stringer("protocolVersion", v).Msg("设备协议版本已更新")
+}
+
+// encodeFor 按设备的协议版本编码下行负载，设备固件不支持时返回 UnsupportedCommand 错误
+func (h *Handler) encodeFor(devEUI string, msg protocol.Message) (uint32, []byte, error) {
+	codec := h.codecFor(devEUI)
+	fPort, data, err := codec.Encode(msg)
+	if errors.Is(err, protocol.ErrUnsupported) {
+		return 0, nil, newUnsupportedError("%s (device %s)", err, h.stakeNoOf(devEUI))
+	}
+	if err != nil {
+		return 0, nil, newValidationError("%s", err)
+	}
+	return fPort, data, nil
+}
+
+// encodeForGroup 按多播组内已登记设备的协议版本编码下行负载。
+// 多播只能下发一份负载，因此组内任一设备不支持该命令，或各版本编码结果不一致时均拒绝下发；
+// 组内没有已登记设备时使用默认版本
+func (h *Handler) encodeForGroup(group string, msg protocol.Message) (uint32, []byte, error) {
+	versions := make(map[protocol.Version][]string)
+	for _, d := range h.registry.List(services.DeviceFilter{Group: group}) {
+		v := h.codecFor(d.DevEUI).Version()
+		versions[v] = append(versions[v], d.StakeNo)
+	}
+	if len(versions) == 0 {
+		versions[protocol.Version(h.config.ProtocolVersion)] = nil
+	}
+
+	order := make([]protocol.Version, 0, len(versions))
+	for v := range versions {
+		order = append(order, v)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	var fPort uint32
+	var data []byte
+	for i, v := range order {
+		codec, err := protocol.ForVersion(v)
+		if err != nil {
+			return 0, nil, newValidationError("%s", err)
+		}
+		p, d, err := codec.Encode(msg)
+		if errors.Is(err, protocol.ErrUnsupported) {
+			return 0, nil, newUnsupportedError("%s (devices in group %s: %s)", err, group, strings.Join(versions[v], ", "))
+		}
+		if err != nil {
+			return 0, nil, newValidationError("%s", err)
+		}
+		if i > 0 && (p != fPort || !bytes.Equal(d, data)) {
+			return 0, nil, newUnsupportedError("%s uses different layouts in protocol %s and %s; group %s must be split by firmware", msg.Spec().Name, order[0], v, group)
+		}
+		fPort, data = p, d
+	}
+	return fPort, data, nil
+}
+
+// sendMessage 按设备协议版本编码并发送单播下行，返回队列项 ID 与实际下发的负载
+func (h *Handler) sendMessage(devEUI string, confirmed bool, msg protocol.Message) (string, []byte, error) {
+	fPort, data, err := h.encodeFor(devEUI, msg)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := h.sendDownlink(devEUI, fPort, confirmed, data)
+	return id, data, err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// configWaiters 保存正在等待设备配置应答的调用方
+type configWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan services.ReportedConfig]struct{}
+}
+
+func newConfigWaiters() *configWaiters {
+	return &configWaiters{waiters: make(map[string]map[chan services.ReportedConfig]struct{})}
+}
+
+// add 登记一个等待 devEUI 应答的通道，需在下发读取命令之前调用
+func (w *configWaiters) add(devEUI string) chan services.ReportedConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan services.ReportedConfig, 1)
+	if w.waiters[devEUI] == nil {
+		w.waiters[devEUI] = make(map[chan services.ReportedConfig]struct{})
+	}
+	w.waiters[devEUI][ch] = struct{}{}
+	return ch
+}
+
+// remove 注销等待通道
+func (w *configWaiters) remove(devEUI string, ch chan services.ReportedConfig) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.waiters[devEUI], ch)
+	if len(w.waiters[devEUI]) == 0 {
+		delete(w.waiters, devEUI)
+	}
+}
+
+// notify 将设备应答交给所有等待者
+func (w *configWaiters) notify(devEUI string, reported services.ReportedConfig) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.waiters[devEUI] {
+		select {
+		case ch <- reported:
+		default:
+		}
+	}
+}
+
+// handleConfigReport 处理设备的配置应答 (0x0A)，应答是对读取配置命令（fPort 20）的响应
+func handleConfigReport(h *Handler, devEUI string, msg protocol.Message, uplink *UplinkEvent) error {
+	report := msg.(protocol.ConfigReport)
+
+	reported := services.ReportedConfig{
+		Color:       int(report.Color),
+		Frequency:   report.Frequency,
+		Level:       int(report.Level),
+		Manner:      int(report.Manner),
+		RadarEnable: int(report.RadarEnable),
+		Firmware:    report.FirmwareVersion(),
+		At:          uplinkTime(uplink),
+	}
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.Reported = &reported
+	})
+	// 设备自行上报的配置即为实际生效的配置
+	h.state.UpdateConfig(devEUI, func(c *services.LightConfig) {
+		c.Color, c.Frequency, c.Level = &reported.Color, &reported.Frequency, &reported.Level
+		c.Manner, c.RadarEnable = &reported.Manner, &reported.RadarEnable
+	})
+	h.configWaiters.notify(devEUI, reported)
+
+	log.Info().
+		Str("devEUI", devEUI).
+		Int("color", reported.Color).
+		Int("frequency", reported.Frequency).
+		Int("level", reported.Level).
+		Int("manner", reported.Manner).
+		Int("radarEnable", reported.RadarEnable).
+		Str("firmware", reported.Firmware).
+		Msg("收到设备配置应答")
+	return nil
+}
+
+// readConfigTimeout 解析 timeout 查询参数，未指定时使用配置的默认值
+func (h *Handler) readConfigTimeout(c *gin.Context) (time.Duration, bool) {
+	raw := c.Query("timeout")
+	if raw == "" {
+		return h.config.ReadConfigTimeout, true
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		respondValidationError(c, "Invalid timeout: "+raw)
+		return 0, false
+	}
+	if timeout > h.config.ReadConfigMaxTimeout {
+		respondValidationError(c, "timeout must not exceed "+h.config.ReadConfigMaxTimeout.String())
+		return 0, false
+	}
+	return timeout, true
+}
+
+// awaitConfigReports 等待下发成功的设备应答配置，超时未应答的设备标记为 504，
+// 调用方在应答前断开连接时标记为 499
+func (h *Handler) awaitConfigReports(ctx context.Context, results []StakeResult, waiters []chan services.ReportedConfig) {
+	for i, ch := range waiters {
+		if ch == nil || results[i].Code != http.StatusOK {
+			continue
+		}
+		select {
+		case reported := <-ch:
+			results[i].Config = &reported
+		case <-ctx.Done():
+			results[i].Code, results[i].Reason = errorStatus(ctx.Err())
+			results[i].Error = "no configuration report received before timeout"
+			if errors.Is(ctx.Err(), context.Canceled) {
+				results[i].Error = "request canceled before configuration report was received"
+			}
+		}
+	}
+}
+
+// handleReadConfig 向一批桩号下发读取配置命令并等待应答。读取配置命令自协议 V2 起支持，
+// 未在注册表或设备标签 protocolVersion 中声明版本的设备按默认版本 protocol_version（默认 V1）处理，
+// 对这些设备返回 400 UnsupportedCommand，不会下发
+func (h *Handler) handleReadConfig(c *gin.Context) {
+	timeout, ok := h.readConfigTimeout(c)
+	if !ok {
+		return
+	}
+	var commands []ReadConfigCommand
+	if !bindUnicastCommands(c, &commands) {
+		return
+	}
+
+	downlinks := make([]unicastDownlink, 0, len(commands))
+	waiters := make([]chan services.ReportedConfig, len(commands))
+	for i, cmd := range commands {
+		if devEUI, err := h.resolveDevEUI(cmd.StakeNo); err == nil {
+			waiters[i] = h.configWaiters.add(devEUI)
+			defer h.configWaiters.remove(devEUI, waiters[i])
+		}
+		downlinks = append(downlinks, unicastDownlink{
+			StakeNo:   cmd.StakeNo,
+			Confirmed: cmd.Confirmed,
+			Message:   protocol.ReadConfig{},
+			Name:      "读取配置",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	results := h.sendUnicastBatch(downlinks)
+	h.awaitConfigReports(ctx, results, waiters)
+	respondBatch(c, results, "Configuration read successfully.")
+}
+
+// handleMulticastReadConfig 向多播组下发读取配置命令，并等待组内已登记设备的应答。
+// 组内任一设备的协议版本低于 V2 时拒绝下发
+func (h *Handler) handleMulticastReadConfig(c *gin.Context) {
+	timeout, ok := h.readConfigTimeout(c)
+	if !ok {
+		return
+	}
+	var cmd MulticastReadConfigCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		respondValidationError(c, "Invalid request: "+err.Error())
+		return
+	}
+	multicastGroupID, found := h.groups.Lookup(cmd.GroupID)
+	if !found {
+		respondValidationError(c, "Unknown groupId: "+cmd.GroupID)
+		return
+	}
+	members := h.registry.List(services.DeviceFilter{Group: cmd.GroupID})
+	if len(members) == 0 {
+		respondValidationError(c, "No registered devices in group: "+cmd.GroupID)
+		return
+	}
+
+	results := make([]StakeResult, len(members))
+	waiters := make([]chan services.ReportedConfig, len(members))
+	for i, d := range members {
+		results[i] = StakeResult{StakeNo: d.StakeNo, Code: http.StatusOK, Reason: reasonOK}
+		waiters[i] = h.configWaiters.add(d.DevEUI)
+		defer h.configWaiters.remove(d.DevEUI, waiters[i])
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	fCnt, err := h.enqueueMulticast(cmd.GroupID, multicastGroupID, protocol.ReadConfig{})
+	if err != nil {
+		log.Error().Err(err).Str("multicastGroupID", multicastGroupID).Msg("发送多播读取配置失败")
+		respondError(c, err, "Failed to enqueue multicast downlink.")
+		return
+	}
+	log.Info().Str("groupId", cmd.GroupID).Str("multicastUUID", multicastGroupID).Int("devices", len(members)).Uint32("fCnt", fCnt).Msg("多播读取配置已入队")
+
+	h.awaitConfigReports(ctx, results, waiters)
+	respondBatch(c, results, "Configuration read successfully.")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleReadConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const v2 = "0000000000000001" // K1，设备标签声明为 V2
+	tests := []struct {
+		name       string
+		stakeNo    string
+		timeout    string
+		report     bool // 下行送达后设备应答配置
+		cancel     bool // 调用方在应答前断开连接
+		wantCode   int
+		wantReason string
+		wantSent   int
+	}{
+		{"收到应答", "K1", "5s", true, false, http.StatusOK, reasonOK, 1},
+		{"等待应答超时", "K1", "50ms", false, false, http.StatusGatewayTimeout, "DeadlineExceeded", 1},
+		{"调用方断开连接", "K1", "5s", false, true, statusClientClosedRequest, "Canceled", 1},
+		{"未声明版本的设备按 V1 处理，不支持读取配置", "K2", "5s", false, false, http.StatusBadRequest, reasonUnsupported, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, fake := newUnicastTestHandler(t, nil)
+			h.configWaiters = newConfigWaiters()
+			h.config.ReadConfigMaxTimeout = time.Minute
+			h.state.Update(v2, func(st *services.DeviceState) { st.ProtocolVersion = 2 })
+
+			router := gin.New()
+			router.POST("/read-config", h.handleReadConfig)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/read-config?timeout="+tt.timeout, strings.NewReader(`[{"stakeNo": "`+tt.stakeNo+`"}]`))
+
+			// 下行入队后模拟设备应答或调用方断开
+			go func() {
+				for {
+					fake.mu.Lock()
+					sent := len(fake.unicast)
+					fake.mu.Unlock()
+					if sent > 0 {
+						break
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(time.Millisecond):
+					}
+				}
+				switch {
+				case tt.report:
+					report := protocol.ConfigReport{Overall: protocol.Overall{Color: 1, Frequency: 60, Level: 2000}, Firmware: [3]uint8{2, 1, 0}}
+					_ = handleConfigReport(h, v2, report, &UplinkEvent{})
+				case tt.cancel:
+					cancel()
+				}
+			}()
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var resp struct {
+				Data []StakeResult `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantCode || len(resp.Data) != 1 || resp.Data[0].Reason != tt.wantReason {
+				t.Fatalf("响应 %d %s，期望 %d %s", w.Code, w.Body, tt.wantCode, tt.wantReason)
+			}
+			if got := resp.Data[0].Config; tt.report && (got == nil || got.Level != 2000 || got.Firmware != "2.1.0") {
+				t.Fatalf("应答的配置 %+v", got)
+			}
+			if len(fake.unicast) != tt.wantSent {
+				t.Fatalf("ChirpStack 收到 %d 条下行，期望 %d 条", len(fake.unicast), tt.wantSent)
+			}
+			if len(h.configWaiters.waiters) != 0 {
+				t.Fatalf("请求结束后仍有 %d 个等待者", len(h.configWaiters.waiters))
+			}
+		})
+	}
+}
+
+func TestReadConfigTimeoutParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h, fake := newUnicastTestHandler(t, nil)
+	h.configWaiters = newConfigWaiters()
+	h.config.ReadConfigMaxTimeout = time.Minute
+	router := gin.New()
+	router.POST("/read-config", h.handleReadConfig)
+
+	for _, timeout := range []string{"abc", "0s", "-1s", "2m"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/read-config?timeout="+timeout, strings.NewReader(`[{"stakeNo": "K1"}]`)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("timeout=%s: 状态码 %d，期望 400", timeout, w.Code)
+		}
+	}
+	if len(fake.unicast) != 0 {
+		t.Fatalf("timeout 无效时仍发送了 %d 条下行", len(fake.unicast))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// 对账状态
+const (
+	reconcileInSync     = "in_sync"
+	reconcilePending    = "pending"     // 仍有配置下行在投递中
+	reconcileOutOfSync  = "out_of_sync" // 已送达配置与期望配置不一致
+	reconcileIncomplete = "incomplete"  // 不一致，但缺少整体设置所需的配置项，无法自动补发
+)
+
+// isOverallSetting 判断命令中的配置是否被整体设置覆盖，即可以通过补发整体设置对账
+func isOverallSetting(msg protocol.Message) bool {
+	switch msg.(type) {
+	case protocol.Frequency, protocol.Color, protocol.Manner, protocol.Level, protocol.Overall:
+		return true
+	}
+	return false
+}
+
+// recordDesired 记录单播下发的期望配置
+func (h *Handler) recordDesired(devEUI string, msg protocol.Message) {
+	if !isOverallSetting(msg) {
+		return
+	}
+	var lc services.LightConfig
+	applyDownlinkConfig(&lc, msg)
+	if err := h.desired.SetDevice(devEUI, lc); err != nil {
+		log.Error().Err(err).Str("devEUI", devEUI).Msg("保存期望配置失败")
+	}
+}
+
+// recordGroupDesired 记录多播组下发的期望配置
+func (h *Handler) recordGroupDesired(group string, msg protocol.Message) {
+	if !isOverallSetting(msg) {
+		return
+	}
+	var lc services.LightConfig
+	applyDownlinkConfig(&lc, msg)
+	if err := h.desired.SetGroup(group, lc); err != nil {
+		log.Error().Err(err).Str("groupId", group).Msg("保存多播组期望配置失败")
+	}
+}
+
+// reconcileStatus 比较设备的期望配置与已送达配置，同时返回补发所需的完整整体设置
+func (h *Handler) reconcileStatus(devEUI string) (ReconcileStatus, services.LightConfig) {
+	stakeNo := h.stakeNoOf(devEUI)
+	var groups []string
+	if d, ok := h.registry.Get(stakeNo); ok {
+		groups = d.MulticastGroups
+	}
+
+	state, _ := h.state.Get(devEUI)
+	desired := h.desired.Effective(devEUI, groups)
+	status := ReconcileStatus{StakeNo: stakeNo, DevEUI: devEUI, Status: reconcileInSync}
+
+	for _, rec := range h.tracker.Pending(devEUI) {
+		if msg, ok := h.decodeDownlinkRecord(rec); ok && isOverallSetting(msg) {
+			status.PendingDownlinks = append(status.PendingDownlinks, rec.ID)
+		}
+	}
+	if len(status.PendingDownlinks) > 0 {
+		status.Status = reconcilePending
+		return status, services.LightConfig{}
+	}
+
+	status.Mismatched = desired.Diff(state.Config)
+	if len(status.Mismatched) == 0 {
+		return status, services.LightConfig{}
+	}
+	resolved, missing := desired.Resolve(state.Config)
+	status.Missing = missing
+	if len(missing) > 0 {
+		status.Status = reconcileIncomplete
+	} else {
+		status.Status = reconcileOutOfSync
+	}
+	return status, resolved
+}
+
+// reconcile 在设备入网或心跳时检查其是否错过了配置变更，如有则以确认帧补发整体设置
+func (h *Handler) reconcile(devEUI string, trigger string) {
+	status, resolved := h.reconcileStatus(devEUI)
+	switch status.Status {
+	case reconcileInSync, reconcilePending:
+		return
+	case reconcileIncomplete:
+		log.Warn().Str("devEUI", devEUI).Str("trigger", trigger).Strs("mismatched", status.Mismatched).Strs("missing", status.Missing).Msg("设备配置与期望不一致，但缺少整体设置所需的配置项，无法补发")
+		return
+	}
+
+	overall := protocol.Overall{
+		Color:       uint8(*resolved.Color),
+		Frequency:   *resolved.Frequency,
+		Level:       uint16(*resolved.Level),
+		Manner:      uint8(*resolved.Manner),
+		RadarEnable: uint8(*resolved.RadarEnable),
+	}
+	id, payload, err := h.sendMessage(devEUI, true, overall)
+	if err != nil {
+		log.Error().Err(err).Str("devEUI", devEUI).Str("trigger", trigger).Hex("payload", payload).Msg("补发整体设置失败")
+		return
+	}
+	log.Info().Str("devEUI", devEUI).Str("trigger", trigger).Strs("mismatched", status.Mismatched).Hex("payload", payload).Str("downlinkID", id).Msg("设备错过了配置变更，已补发整体设置")
+}
+
+// handleReconciliationReport 列出已登记设备的对账状态，可按多播组和状态筛选
+func (h *Handler) handleReconciliationReport(c *gin.Context) {
+	group := c.Query("group")
+	want := c.Query("status")
+
+	summary := map[string]int{reconcileInSync: 0, reconcilePending: 0, reconcileOutOfSync: 0, reconcileIncomplete: 0}
+	items := make([]ReconcileStatus, 0)
+	for _, d := range h.registry.List(services.DeviceFilter{Group: group}) {
+		status, _ := h.reconcileStatus(d.DevEUI)
+		summary[status.Status]++
+		if want == "" || want == status.Status {
+			items = append(items, status)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": gin.H{"summary": summary, "devices": items}})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"chirpstack-httpserver/protocol"
+)
+
+func TestReconcileAfterMulticast(t *testing.T) {
+	h, fake := newUnicastTestHandler(t, nil)
+	for _, stakeNo := range []string{"K1", "K2"} {
+		if err := h.registry.SetGroupMember(stakeNo, "g1", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overall := protocol.Overall{Color: 1, Frequency: 60, Level: 2000, Manner: 1, RadarEnable: 0}
+	if _, err := h.enqueueMulticast("g1", "uuid-1", overall); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.multicast) != 1 {
+		t.Fatalf("ChirpStack 收到 %d 条多播下行，期望 1 条", len(fake.multicast))
+	}
+
+	// 多播入队后暂记为已送达，组内设备不会因此被逐台单播补发
+	for _, devEUI := range []string{"0000000000000001", "0000000000000002"} {
+		if status, _ := h.reconcileStatus(devEUI); status.Status != reconcileInSync {
+			t.Fatalf("%s 对账状态 %s，期望 %s", devEUI, status.Status, reconcileInSync)
+		}
+		h.reconcile(devEUI, "heartbeat")
+	}
+	if len(fake.unicast) != 0 {
+		t.Fatalf("多播后补发了 %d 条单播下行", len(fake.unicast))
+	}
+
+	// 之后按设备设置的新配置未送达时，只有该设备需要补发
+	h.recordDesired("0000000000000001", protocol.Level{Level: 500})
+	if status, _ := h.reconcileStatus("0000000000000001"); status.Status != reconcileOutOfSync {
+		t.Fatalf("K1 对账状态 %s，期望 %s", status.Status, reconcileOutOfSync)
+	}
+	if status, _ := h.reconcileStatus("0000000000000002"); status.Status != reconcileInSync {
+		t.Fatalf("K2 对账状态 %s，期望 %s", status.Status, reconcileInSync)
+	}
+}
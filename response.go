@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 响应中 reason 字段的取值，用于区分失败类型
+const (
+	reasonOK              = "OK"
+	reasonValidationError = "ValidationError"
+	reasonUnknownStake    = "UnknownStake"
+	reasonUnknownGroup    = "UnknownGroup"
+	reasonConflict        = "Conflict"
+	reasonUnsupported     = "UnsupportedCommand"
+	reasonInternal        = "Internal"
+)
+
+// statusClientClosedRequest 表示调用方在结果返回前断开了连接，与 grpc-gateway 对 Canceled 的映射一致
+const statusClientClosedRequest = 499
+
+// requestError 表示在发往 ChirpStack 之前就已确定的失败，如参数错误或桩号未登记
+type requestError struct {
+	status int
+	reason string
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+// newValidationError 创建一个参数校验错误
+func newValidationError(format string, args ...any) error {
+	return &requestError{status: http.StatusBadRequest, reason: reasonValidationError, msg: fmt.Sprintf(format, args...)}
+}
+
+// newUnknownStakeError 创建一个桩号未登记错误
+func newUnknownStakeError(stakeNo string) error {
+	return &requestError{status: http.StatusNotFound, reason: reasonUnknownStake, msg: "unknown stakeNo: " + stakeNo}
+}
+
+// newUnsupportedError 创建一个设备固件不支持该命令的错误
+func newUnsupportedError(format string, args ...any) error {
+	return &requestError{status: http.StatusBadRequest, reason: reasonUnsupported, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorStatus 将下行失败原因映射为 HTTP 状态码和 reason，
+// gRPC 状态码的映射与 grpc-gateway 的约定保持一致
+func errorStatus(err error) (int, string) {
+	var re *requestError
+	if errors.As(err, &re) {
+		return re.status, re.reason
+	}
+	if errors.Is(err, services.ErrDeviceNotFound) {
+		return http.StatusNotFound, reasonUnknownStake
+	}
+	if errors.Is(err, services.ErrDeviceConflict) || errors.Is(err, services.ErrGroupConflict) {
+		return http.StatusConflict, reasonConflict
+	}
+	if errors.Is(err, services.ErrGroupNotFound) {
+		return http.StatusNotFound, reasonUnknownGroup
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, codes.DeadlineExceeded.String()
+	}
+	if errors.Is(err, context.Canceled) {
+		return statusClientClosedRequest, codes.Canceled.String()
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return http.StatusInternalServerError, reasonInternal
+	}
+	switch st.Code() {
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest, st.Code().String()
+	case codes.NotFound:
+		return http.StatusNotFound, st.Code().String()
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict, st.Code().String()
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized, st.Code().String()
+	case codes.PermissionDenied:
+		return http.StatusForbidden, st.Code().String()
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests, st.Code().String()
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout, st.Code().String()
+	case codes.Canceled:
+		return statusClientClosedRequest, st.Code().String()
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable, st.Code().String()
+	case codes.Unimplemented:
+		return http.StatusNotImplemented, st.Code().String()
+	default:
+		return http.StatusBadGateway, st.Code().String()
+	}
+}
+
+// errorMessage 提取错误描述，gRPC 错误只保留服务端的 message
+func errorMessage(err error) string {
+	if st, ok := status.FromError(err); ok {
+		return st.Message()
+	}
+	return err.Error()
+}
+
+// respondValidationError 写回请求体校验失败的响应
+func respondValidationError(c *gin.Context, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{"code": 400, "reason": reasonValidationError, "message": message})
+}
+
+// respondError 写回单条命令失败的响应
+func respondError(c *gin.Context, err error, message string) {
+	code, reason := errorStatus(err)
+	c.JSON(code, gin.H{"code": code, "reason": reason, "message": message + " " + errorMessage(err)})
+}
+
+// batchResult 是批量命令中单项的结果
+type batchResult interface {
+	resultCode() int
+}
+
+func (r StakeResult) resultCode() int { return r.Code }
+
+func (r GroupResult) resultCode() int { return r.Code }
+
+// respondBatch 汇总批量命令的结果：全部成功返回 200，全部失败且原因一致时返回对应状态码，
+// 原因不一致时返回 502，部分成功返回 207
+func respondBatch[T batchResult](c *gin.Context, results []T, successMsg string) {
+	failed := 0
+	code := 0
+	for _, r := range results {
+		if r.resultCode() == http.StatusOK {
+			continue
+		}
+		failed++
+		if code == 0 {
+			code = r.resultCode()
+		} else if code != r.resultCode() {
+			code = http.StatusBadGateway
+		}
+	}
+
+	switch {
+	case failed == 0:
+		c.JSON(http.StatusOK, gin.H{"code": 200, "message": successMsg, "data": results})
+	case failed < len(results):
+		message := fmt.Sprintf("%d of %d commands failed.", failed, len(results))
+		c.JSON(http.StatusMultiStatus, gin.H{"code": http.StatusMultiStatus, "message": message, "data": results})
+	default:
+		c.JSON(code, gin.H{"code": code, "message": "All commands failed.", "data": results})
+	}
+}
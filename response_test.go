@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   int
+		wantReason string
+	}{
+		{"参数错误", newValidationError("bad color"), http.StatusBadRequest, reasonValidationError},
+		{"桩号未登记", newUnknownStakeError("K1"), http.StatusNotFound, reasonUnknownStake},
+		{"固件不支持", newUnsupportedError("read config"), http.StatusBadRequest, reasonUnsupported},
+		{"注册表中不存在", fmt.Errorf("get: %w", services.ErrDeviceNotFound), http.StatusNotFound, reasonUnknownStake},
+		{"注册表冲突", services.ErrDeviceConflict, http.StatusConflict, reasonConflict},
+		{"多播组冲突", services.ErrGroupConflict, http.StatusConflict, reasonConflict},
+		{"多播组不存在", services.ErrGroupNotFound, http.StatusNotFound, reasonUnknownGroup},
+		{"等待超时", context.DeadlineExceeded, http.StatusGatewayTimeout, "DeadlineExceeded"},
+		{"未知错误", errors.New("boom"), http.StatusInternalServerError, reasonInternal},
+		{"gRPC InvalidArgument", status.Error(codes.InvalidArgument, ""), http.StatusBadRequest, "InvalidArgument"},
+		{"gRPC FailedPrecondition", status.Error(codes.FailedPrecondition, ""), http.StatusBadRequest, "FailedPrecondition"},
+		{"gRPC NotFound", status.Error(codes.NotFound, ""), http.StatusNotFound, "NotFound"},
+		{"gRPC AlreadyExists", status.Error(codes.AlreadyExists, ""), http.StatusConflict, "AlreadyExists"},
+		{"gRPC Unauthenticated", status.Error(codes.Unauthenticated, ""), http.StatusUnauthorized, "Unauthenticated"},
+		{"gRPC PermissionDenied", status.Error(codes.PermissionDenied, ""), http.StatusForbidden, "PermissionDenied"},
+		{"gRPC ResourceExhausted", status.Error(codes.ResourceExhausted, ""), http.StatusTooManyRequests, "ResourceExhausted"},
+		{"gRPC DeadlineExceeded", status.Error(codes.DeadlineExceeded, ""), http.StatusGatewayTimeout, "DeadlineExceeded"},
+		{"gRPC Unavailable", status.Error(codes.Unavailable, ""), http.StatusServiceUnavailable, "Unavailable"},
+		{"gRPC Unimplemented", status.Error(codes.Unimplemented, ""), http.StatusNotImplemented, "Unimplemented"},
+		{"gRPC Internal", status.Error(codes.Internal, ""), http.StatusBadGateway, "Internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := errorStatus(tt.err)
+			if code != tt.wantCode || reason != tt.wantReason {
+				t.Fatalf("errorStatus = %d %s，期望 %d %s", code, reason, tt.wantCode, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	if got := errorMessage(status.Error(codes.NotFound, "object does not exist")); got != "object does not exist" {
+		t.Errorf("gRPC 错误描述 %q，期望只保留服务端的 message", got)
+	}
+	if got := errorMessage(newUnknownStakeError("K1")); got != "unknown stakeNo: K1" {
+		t.Errorf("错误描述 %q", got)
+	}
+}
+
+func TestRespondBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ok := StakeResult{StakeNo: "K1", Code: http.StatusOK, Reason: reasonOK}
+	notFound := StakeResult{StakeNo: "K2", Code: http.StatusNotFound, Reason: reasonUnknownStake}
+	unavailable := StakeResult{StakeNo: "K3", Code: http.StatusServiceUnavailable, Reason: "Unavailable"}
+	tests := []struct {
+		name        string
+		results     []StakeResult
+		wantCode    int
+		wantMessage string
+	}{
+		{"全部成功", []StakeResult{ok, ok}, http.StatusOK, "done"},
+		{"部分成功", []StakeResult{ok, notFound, unavailable}, http.StatusMultiStatus, "2 of 3 commands failed."},
+		{"全部失败且原因一致", []StakeResult{notFound, notFound}, http.StatusNotFound, "All commands failed."},
+		{"全部失败且原因不一致", []StakeResult{notFound, unavailable}, http.StatusBadGateway, "All commands failed."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			respondBatch(c, tt.results, "done")
+
+			var resp struct {
+				Code    int           `json:"code"`
+				Message string        `json:"message"`
+				Data    []StakeResult `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantCode || resp.Code != tt.wantCode || resp.Message != tt.wantMessage {
+				t.Fatalf("响应 %d %+v，期望 %d %q", w.Code, resp, tt.wantCode, tt.wantMessage)
+			}
+			if len(resp.Data) != len(tt.results) {
+				t.Fatalf("返回 %d 条结果，期望 %d 条", len(resp.Data), len(tt.results))
+			}
+		})
+	}
+}
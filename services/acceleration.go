@@ -0,0 +1,137 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+// ErrTooManySamples 表示查询范围内的原始采样数超过上限，需缩小范围或降采样
+var ErrTooManySamples = errors.New("too many samples in range")
+
+// AxisStats 是一个时间桶内单轴加速度的统计值，单位为 g
+type AxisStats struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+// AccelerationBucket 是降采样后的一个时间桶，PeakMagnitude 为桶内合加速度的峰值
+type AccelerationBucket struct {
+	Start         time.Time `json:"start"`
+	Count         int       `json:"count"`
+	X             AxisStats `json:"x"`
+	Y             AxisStats `json:"y"`
+	Z             AxisStats `json:"z"`
+	PeakMagnitude float64   `json:"peakMagnitude"`
+}
+
+// Magnitude 返回采样的合加速度
+func (s AccelerationSample) Magnitude() float64 {
+	return math.Sqrt(s.X*s.X + s.Y*s.Y + s.Z*s.Z)
+}
+
+// AccelerationStore 以 DevEUI 分桶保存加速度时间序列，数据位于 dataDir 下的 acceleration.db，
+// 值为 X/Y/Z 三个 float64
+type AccelerationStore struct {
+	*timeSeries
+}
+
+// NewAccelerationStore 打开加速度时间序列库，retention 为采样的保留时长，0 表示永久保留
+func NewAccelerationStore(dataDir string, retention time.Duration) (*AccelerationStore, error) {
+	ts, err := openTimeSeries(filepath.Join(dataDir, "acceleration.db"), "加速度", retention)
+	if err != nil {
+		return nil, err
+	}
+	return &AccelerationStore{timeSeries: ts}, nil
+}
+
+func encodeSample(sample AccelerationSample) []byte {
+	v := make([]byte, 0, 24)
+	for _, f := range []float64{sample.X, sample.Y, sample.Z} {
+		v = binary.BigEndian.AppendUint64(v, math.Float64bits(f))
+	}
+	return v
+}
+
+func decodeSample(at time.Time, v []byte) AccelerationSample {
+	f := func(i int) float64 { return math.Float64frombits(binary.BigEndian.Uint64(v[i*8:])) }
+	return AccelerationSample{X: f(0), Y: f(1), Z: f(2), At: at}
+}
+
+// Append 写入一次采样
+func (s *AccelerationStore) Append(devEUI string, sample AccelerationSample) error {
+	return s.put(devEUI, sample.At, encodeSample(sample))
+}
+
+// eachSample 按时间顺序遍历 [from, to) 内的采样
+func (s *AccelerationStore) eachSample(devEUI string, from, to time.Time, fn func(AccelerationSample) error) error {
+	return s.each(devEUI, from, to, func(at time.Time, v []byte) error {
+		return fn(decodeSample(at, v))
+	})
+}
+
+// Samples 返回 [from, to) 内的原始采样，数量超过 limit 时返回 ErrTooManySamples
+func (s *AccelerationStore) Samples(devEUI string, from, to time.Time, limit int) ([]AccelerationSample, error) {
+	samples := make([]AccelerationSample, 0)
+	err := s.eachSample(devEUI, from, to, func(sample AccelerationSample) error {
+		if limit > 0 && len(samples) >= limit {
+			return fmt.Errorf("%w: more than %d", ErrTooManySamples, limit)
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	return samples, err
+}
+
+// Downsample 将 [from, to) 内的采样按 interval 分桶统计，空桶不返回
+func (s *AccelerationStore) Downsample(devEUI string, from, to time.Time, interval time.Duration) ([]AccelerationBucket, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid downsampling interval: %s", interval)
+	}
+	buckets := make([]AccelerationBucket, 0)
+	var cur *AccelerationBucket
+	var sumX, sumY, sumZ float64
+
+	finish := func() {
+		if cur == nil {
+			return
+		}
+		n := float64(cur.Count)
+		cur.X.Mean, cur.Y.Mean, cur.Z.Mean = sumX/n, sumY/n, sumZ/n
+		buckets = append(buckets, *cur)
+	}
+
+	err := s.eachSample(devEUI, from, to, func(sample AccelerationSample) error {
+		start := from.Add(sample.At.Sub(from).Truncate(interval))
+		if cur == nil || !cur.Start.Equal(start) {
+			finish()
+			cur = &AccelerationBucket{
+				Start: start,
+				X:     AxisStats{Min: sample.X, Max: sample.X},
+				Y:     AxisStats{Min: sample.Y, Max: sample.Y},
+				Z:     AxisStats{Min: sample.Z, Max: sample.Z},
+			}
+			sumX, sumY, sumZ = 0, 0, 0
+		}
+		cur.Count++
+		sumX, sumY, sumZ = sumX+sample.X, sumY+sample.Y, sumZ+sample.Z
+		for _, a := range []struct {
+			stats *AxisStats
+			v     float64
+		}{{&cur.X, sample.X}, {&cur.Y, sample.Y}, {&cur.Z, sample.Z}} {
+			a.stats.Min = math.Min(a.stats.Min, a.v)
+			a.stats.Max = math.Max(a.stats.Max, a.v)
+		}
+		cur.PeakMagnitude = math.Max(cur.PeakMagnitude, sample.Magnitude())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	finish()
+	return buckets, nil
+}
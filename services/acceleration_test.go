@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestAccelerationStore(t *testing.T) *AccelerationStore {
+	t.Helper()
+
+	store, err := NewAccelerationStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestAccelerationSamples(t *testing.T) {
+	store := newTestAccelerationStore(t)
+	const devEUI = "0000000000000001"
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, x := range []float64{0.1, 0.2, 0.3, 0.4} {
+		if err := store.Append(devEUI, AccelerationSample{X: x, Z: 1, At: start.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Append("0000000000000002", AccelerationSample{X: 9, At: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		devEUI   string
+		from, to time.Time
+		limit    int
+		wantX    []float64
+		wantErr  error
+	}{
+		{"全部采样", devEUI, start, start.Add(time.Hour), 0, []float64{0.1, 0.2, 0.3, 0.4}, nil},
+		{"包含 from、不包含 to", devEUI, start.Add(time.Minute), start.Add(3 * time.Minute), 0, []float64{0.2, 0.3}, nil},
+		{"范围内无采样", devEUI, start.Add(time.Hour), start.Add(2 * time.Hour), 0, []float64{}, nil},
+		{"设备无采样", "00000000000000ff", start, start.Add(time.Hour), 0, []float64{}, nil},
+		{"恰好达到上限", devEUI, start, start.Add(time.Hour), 4, []float64{0.1, 0.2, 0.3, 0.4}, nil},
+		{"超过上限", devEUI, start, start.Add(time.Hour), 3, nil, ErrTooManySamples},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, err := store.Samples(tt.devEUI, tt.from, tt.to, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("错误 %v，期望 %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if len(samples) != len(tt.wantX) {
+				t.Fatalf("返回 %d 条采样，期望 %d 条", len(samples), len(tt.wantX))
+			}
+			for i, s := range samples {
+				if s.X != tt.wantX[i] || s.Z != 1 || s.At.IsZero() {
+					t.Errorf("第 %d 条采样 %+v，期望 x=%v", i, s, tt.wantX[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAccelerationSameTimestamp(t *testing.T) {
+	store := newTestAccelerationStore(t)
+	const devEUI = "0000000000000001"
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 同一时间的多条采样都应保留，且按写入顺序排在下一纳秒的采样之前
+	for _, s := range []AccelerationSample{{X: 1, At: at}, {X: 2, At: at}, {X: 3, At: at.Add(time.Nanosecond)}, {X: 4, At: at}} {
+		if err := store.Append(devEUI, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err := store.Samples(devEUI, at, at.Add(time.Second), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 2, 4, 3}
+	if len(samples) != len(want) {
+		t.Fatalf("返回 %d 条采样，期望 %d 条", len(samples), len(want))
+	}
+	for i, s := range samples {
+		if s.X != want[i] {
+			t.Errorf("第 %d 条采样 x=%v，期望 %v", i, s.X, want[i])
+		}
+	}
+	if !samples[0].At.Equal(at) || !samples[2].At.Equal(at) {
+		t.Errorf("同一时间采样的时间被改写: %v %v", samples[0].At, samples[2].At)
+	}
+
+	// 不包含 to：结束于该时间的查询不应返回带序号的采样
+	if n, err := store.Count(devEUI, at.Add(-time.Second), at); err != nil || n != 0 {
+		t.Fatalf("[at-1s, at) 内有 %d 条采样，期望 0 条", n)
+	}
+	// 清理早于下一纳秒的采样时一并删除带序号的采样
+	if removed, err := store.Prune(at.Add(time.Nanosecond)); err != nil || removed != 3 {
+		t.Fatalf("清理了 %d 条采样，期望 3 条", removed)
+	}
+}
+
+func TestAccelerationDownsample(t *testing.T) {
+	store := newTestAccelerationStore(t)
+	const devEUI = "0000000000000001"
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, s := range []AccelerationSample{
+		{X: 0, Y: 0, Z: 1, At: start},
+		{X: 0.6, Y: -0.2, Z: 0.8, At: start.Add(10 * time.Second)},
+		{X: 0.3, Y: 0.1, Z: 0.9, At: start.Add(50 * time.Second)},
+		{X: 0, Y: 2, Z: 0, At: start.Add(3*time.Minute + time.Second)},
+	} {
+		if err := store.Append(devEUI, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets, err := store.Downsample(devEUI, start, start.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 空桶不返回
+	if len(buckets) != 2 {
+		t.Fatalf("返回 %d 个时间桶，期望 2 个: %+v", len(buckets), buckets)
+	}
+
+	b := buckets[0]
+	if !b.Start.Equal(start) || b.Count != 3 {
+		t.Fatalf("第 1 个时间桶 %v 含 %d 条采样，期望 %v 含 3 条", b.Start, b.Count, start)
+	}
+	for _, a := range []struct {
+		name           string
+		got, wantStats AxisStats
+	}{
+		{"x", b.X, AxisStats{Min: 0, Max: 0.6, Mean: 0.3}},
+		{"y", b.Y, AxisStats{Min: -0.2, Max: 0.1, Mean: -0.1 / 3}},
+		{"z", b.Z, AxisStats{Min: 0.8, Max: 1, Mean: 0.9}},
+	} {
+		if math.Abs(a.got.Min-a.wantStats.Min) > 1e-9 || math.Abs(a.got.Max-a.wantStats.Max) > 1e-9 || math.Abs(a.got.Mean-a.wantStats.Mean) > 1e-9 {
+			t.Errorf("%s 轴统计 %+v，期望 %+v", a.name, a.got, a.wantStats)
+		}
+	}
+	if want := math.Sqrt(0.6*0.6 + 0.2*0.2 + 0.8*0.8); math.Abs(b.PeakMagnitude-want) > 1e-9 {
+		t.Errorf("合加速度峰值 %v，期望 %v", b.PeakMagnitude, want)
+	}
+
+	if b := buckets[1]; !b.Start.Equal(start.Add(3*time.Minute)) || b.Count != 1 || b.PeakMagnitude != 2 {
+		t.Errorf("第 2 个时间桶 %+v，期望从 %v 开始、含 1 条采样、峰值 2", b, start.Add(3*time.Minute))
+	}
+
+	// 时间桶以 from 为起点对齐
+	buckets, err = store.Downsample(devEUI, start.Add(30*time.Second), start.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 || !buckets[0].Start.Equal(start.Add(30*time.Second)) || buckets[0].Count != 1 {
+		t.Fatalf("从 %v 起降采样结果 %+v", start.Add(30*time.Second), buckets)
+	}
+
+	if _, err := store.Downsample(devEUI, start, start.Add(time.Hour), 0); err == nil {
+		t.Fatal("间隔为 0 时未返回错误")
+	}
+}
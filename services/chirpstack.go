@@ -72,8 +72,8 @@ func (c *ChirpStackClient) SendDownlink(devEUI string, fPort uint32, confirmed b
 	return resp.Id, nil
 }
 
-// EnqueueMulticast 发送多播下行消息
-func (c *ChirpStackClient) EnqueueMulticast(multicastGroupID string, fPort uint32, data []byte) (string, error) {
+// EnqueueMulticast 发送多播下行消息，返回该下行使用的多播帧计数
+func (c *ChirpStackClient) EnqueueMulticast(multicastGroupID string, fPort uint32, data []byte) (uint32, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
 	defer cancel()
 
@@ -84,10 +84,92 @@ func (c *ChirpStackClient) EnqueueMulticast(multicastGroupID string, fPort uint3
 			Data:             data,
 		},
 	}
-	_, err := c.multicastClient.Enqueue(ctx, req)
+	resp, err := c.multicastClient.Enqueue(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.FCnt, nil
+}
+
+// GetMulticastGroup 查询多播组的类型、数据速率与 Class B ping 时隙周期等参数
+func (c *ChirpStackClient) GetMulticastGroup(multicastGroupID string) (*api.MulticastGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
+	defer cancel()
+
+	resp, err := c.multicastClient.Get(ctx, &api.GetMulticastGroupRequest{Id: multicastGroupID})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetMulticastGroup(), nil
+}
+
+// CreateMulticastGroup 在 ChirpStack 中创建多播组，返回其 UUID
+func (c *ChirpStackClient) CreateMulticastGroup(g *api.MulticastGroup) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
+	defer cancel()
+
+	resp, err := c.multicastClient.Create(ctx, &api.CreateMulticastGroupRequest{MulticastGroup: g})
 	if err != nil {
 		return "", err
 	}
+	return resp.GetId(), nil
+}
+
+// UpdateMulticastGroup 以 g 替换 ChirpStack 中 g.Id 对应多播组的设置
+func (c *ChirpStackClient) UpdateMulticastGroup(g *api.MulticastGroup) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
+	defer cancel()
+
+	_, err := c.multicastClient.Update(ctx, &api.UpdateMulticastGroupRequest{MulticastGroup: g})
+	return err
+}
+
+// DeleteMulticastGroup 删除 ChirpStack 中的多播组
+func (c *ChirpStackClient) DeleteMulticastGroup(multicastGroupID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
+	defer cancel()
+
+	_, err := c.multicastClient.Delete(ctx, &api.DeleteMulticastGroupRequest{Id: multicastGroupID})
+	return err
+}
+
+// ListMulticastGroups 分页列出应用下的全部多播组
+func (c *ChirpStackClient) ListMulticastGroups(applicationID string) ([]*api.MulticastGroupListItem, error) {
+	const pageSize = 100
+	var items []*api.MulticastGroupListItem
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
+		resp, err := c.multicastClient.List(ctx, &api.ListMulticastGroupsRequest{
+			ApplicationId: applicationID,
+			Limit:         pageSize,
+			Offset:        uint32(len(items)),
+		})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resp.GetResult()...)
+		if len(resp.GetResult()) < pageSize || uint32(len(items)) >= resp.GetTotalCount() {
+			return items, nil
+		}
+	}
+}
+
+// AddDeviceToMulticastGroup 将设备加入 ChirpStack 多播组
+func (c *ChirpStackClient) AddDeviceToMulticastGroup(multicastGroupID, devEUI string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
+	defer cancel()
+
+	_, err := c.multicastClient.AddDevice(ctx, &api.AddDeviceToMulticastGroupRequest{MulticastGroupId: multicastGroupID, DevEui: devEUI})
+	return err
+}
+
+// RemoveDeviceFromMulticastGroup 将设备移出 ChirpStack 多播组
+func (c *ChirpStackClient) RemoveDeviceFromMulticastGroup(multicastGroupID, devEUI string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.GRPCTimeout)
+	defer cancel()
 
-	return "muticast enqueue succeed", nil
+	_, err := c.multicastClient.RemoveDevice(ctx, &api.RemoveDeviceFromMulticastGroupRequest{MulticastGroupId: multicastGroupID, DevEui: devEUI})
+	return err
 }
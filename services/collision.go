@@ -0,0 +1,85 @@
+package services
+
+import (
+	"math"
+	"time"
+
+	"chirpstack-httpserver/config"
+)
+
+// 碰撞的触发条件
+const (
+	CollisionMagnitude = "magnitude"
+	CollisionJerk      = "jerk"
+)
+
+// AccelerationBaseline 是设备静止时的加速度（即重力在三轴上的分量），单位为 g。
+// 前 baseline_samples 次采样取算术平均，之后以指数移动平均跟随灯体的缓慢变化
+type AccelerationBaseline struct {
+	X         float64   `json:"x"`
+	Y         float64   `json:"y"`
+	Z         float64   `json:"z"`
+	Samples   int       `json:"samples"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CollisionCheck 是一次采样的碰撞检测结果
+type CollisionCheck struct {
+	Deviation float64 // 偏离基线的合加速度，基线尚未建立时为 0
+	Jerk      float64 // 与上一次采样之间的加加速度（g/s），上一次采样不可用时为 0
+	Trigger   string  // 触发条件，未检测到碰撞时为空
+}
+
+// Collision 判断是否检测到碰撞
+func (c CollisionCheck) Collision() bool {
+	return c.Trigger != ""
+}
+
+// CollisionDetector 根据设备基线与阈值判断加速度采样是否为碰撞
+type CollisionDetector struct {
+	cfg config.CollisionConfig
+}
+
+// NewCollisionDetector 创建碰撞检测器
+func NewCollisionDetector(cfg config.CollisionConfig) *CollisionDetector {
+	return &CollisionDetector{cfg: cfg}
+}
+
+// Check 以基线和上一次采样检测 sample，返回检测结果与更新后的基线。
+// 判定为碰撞的采样不计入基线，以免冲击后的数据污染基线
+func (d *CollisionDetector) Check(baseline AccelerationBaseline, prev *AccelerationSample, sample AccelerationSample) (CollisionCheck, AccelerationBaseline) {
+	var check CollisionCheck
+
+	if baseline.Samples >= d.cfg.BaselineSamples && baseline.Samples > 0 {
+		check.Deviation = distance(sample.X-baseline.X, sample.Y-baseline.Y, sample.Z-baseline.Z)
+		if d.cfg.MagnitudeThreshold > 0 && check.Deviation > d.cfg.MagnitudeThreshold {
+			check.Trigger = CollisionMagnitude
+		}
+	}
+	if prev != nil {
+		dt := sample.At.Sub(prev.At)
+		if dt > 0 && (d.cfg.JerkWindow <= 0 || dt <= d.cfg.JerkWindow) {
+			check.Jerk = distance(sample.X-prev.X, sample.Y-prev.Y, sample.Z-prev.Z) / dt.Seconds()
+			if check.Trigger == "" && d.cfg.JerkThreshold > 0 && check.Jerk > d.cfg.JerkThreshold {
+				check.Trigger = CollisionJerk
+			}
+		}
+	}
+
+	if !check.Collision() {
+		alpha := d.cfg.BaselineAlpha
+		if baseline.Samples < d.cfg.BaselineSamples || alpha <= 0 || alpha > 1 {
+			alpha = 1 / float64(baseline.Samples+1)
+		}
+		baseline.X += alpha * (sample.X - baseline.X)
+		baseline.Y += alpha * (sample.Y - baseline.Y)
+		baseline.Z += alpha * (sample.Z - baseline.Z)
+		baseline.Samples++
+		baseline.UpdatedAt = sample.At
+	}
+	return check, baseline
+}
+
+func distance(x, y, z float64) float64 {
+	return math.Sqrt(x*x + y*y + z*z)
+}
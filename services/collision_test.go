@@ -0,0 +1,136 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/config"
+)
+
+func TestCollisionDetectorBaseline(t *testing.T) {
+	d := NewCollisionDetector(config.CollisionConfig{MagnitudeThreshold: 0.5, BaselineAlpha: 0.5, BaselineSamples: 3})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 前 baseline_samples 次采样取算术平均，即使偏离很大也不判定为碰撞
+	var baseline AccelerationBaseline
+	for i, z := range []float64{0.7, 1.0, 1.3} {
+		var check CollisionCheck
+		check, baseline = d.Check(baseline, nil, AccelerationSample{Z: z, At: start.Add(time.Duration(i) * time.Minute)})
+		if check.Collision() || check.Deviation != 0 {
+			t.Fatalf("基线建立期间第 %d 次采样: %+v，期望不检测", i, check)
+		}
+	}
+	if baseline.Samples != 3 || math.Abs(baseline.Z-1) > 1e-9 {
+		t.Fatalf("基线 %+v，期望 3 次采样的平均 z=1", baseline)
+	}
+
+	// 之后以 baseline_alpha 做指数移动平均
+	_, baseline = d.Check(baseline, nil, AccelerationSample{Z: 1.2, At: start.Add(time.Hour)})
+	if baseline.Samples != 4 || math.Abs(baseline.Z-1.1) > 1e-9 || !baseline.UpdatedAt.Equal(start.Add(time.Hour)) {
+		t.Fatalf("基线 %+v，期望 z=1.1", baseline)
+	}
+}
+
+func TestCollisionDetectorCheck(t *testing.T) {
+	cfg := config.CollisionConfig{
+		MagnitudeThreshold: 0.5,
+		JerkThreshold:      20,
+		JerkWindow:         time.Second,
+		BaselineAlpha:      0.1,
+		BaselineSamples:    3,
+	}
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ready := AccelerationBaseline{Z: 1, Samples: 3}
+	prev := func(z float64, ago time.Duration) *AccelerationSample {
+		return &AccelerationSample{Z: z, At: at.Add(-ago)}
+	}
+
+	tests := []struct {
+		name        string
+		cfg         config.CollisionConfig
+		baseline    AccelerationBaseline
+		prev        *AccelerationSample
+		sample      AccelerationSample
+		wantTrigger string
+		wantJerk    float64
+	}{
+		{
+			name:     "偏离未超过阈值",
+			baseline: ready,
+			sample:   AccelerationSample{X: 0.3, Z: 1, At: at},
+		},
+		{
+			name:        "偏离超过阈值",
+			baseline:    ready,
+			sample:      AccelerationSample{X: 0.6, Z: 1, At: at},
+			wantTrigger: CollisionMagnitude,
+		},
+		{
+			name:     "基线未建立时不按偏离判定",
+			baseline: AccelerationBaseline{Z: 1, Samples: 2},
+			sample:   AccelerationSample{X: 2, Z: 1, At: at},
+		},
+		{
+			name:        "加加速度超过阈值",
+			baseline:    AccelerationBaseline{Z: 1, Samples: 2},
+			prev:        prev(1, 100*time.Millisecond),
+			sample:      AccelerationSample{Z: 4, At: at},
+			wantTrigger: CollisionJerk,
+			wantJerk:    30,
+		},
+		{
+			name:     "加加速度未超过阈值",
+			baseline: ready,
+			prev:     prev(1, 100*time.Millisecond),
+			sample:   AccelerationSample{Z: 1.2, At: at},
+			wantJerk: 2,
+		},
+		{
+			name:     "上一次采样超出时间窗口时不计算加加速度",
+			baseline: AccelerationBaseline{Z: 1, Samples: 2},
+			prev:     prev(1, 2*time.Second),
+			sample:   AccelerationSample{Z: 4, At: at},
+		},
+		{
+			name:        "两项均超过阈值时按偏离上报",
+			baseline:    ready,
+			prev:        prev(1, 100*time.Millisecond),
+			sample:      AccelerationSample{Z: 4, At: at},
+			wantTrigger: CollisionMagnitude,
+			wantJerk:    30,
+		},
+		{
+			name:     "阈值为 0 表示不启用该条件",
+			cfg:      config.CollisionConfig{BaselineSamples: 3},
+			baseline: ready,
+			prev:     prev(1, 100*time.Millisecond),
+			sample:   AccelerationSample{Z: 4, At: at},
+			wantJerk: 30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cfg
+			if tt.cfg != (config.CollisionConfig{}) {
+				c = tt.cfg
+			}
+			check, baseline := NewCollisionDetector(c).Check(tt.baseline, tt.prev, tt.sample)
+			if check.Trigger != tt.wantTrigger {
+				t.Errorf("trigger = %q，期望 %q", check.Trigger, tt.wantTrigger)
+			}
+			if math.Abs(check.Jerk-tt.wantJerk) > 1e-6 {
+				t.Errorf("jerk = %v，期望 %v", check.Jerk, tt.wantJerk)
+			}
+
+			// 判定为碰撞的采样不计入基线
+			if check.Collision() {
+				if baseline != tt.baseline {
+					t.Errorf("碰撞采样改变了基线: %+v", baseline)
+				}
+			} else if baseline.Samples != tt.baseline.Samples+1 {
+				t.Errorf("基线采样数 %d，期望 %d", baseline.Samples, tt.baseline.Samples+1)
+			}
+		})
+	}
+}
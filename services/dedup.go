@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UplinkVerdict 是去重层对一条上行的判定结果
+type UplinkVerdict string
+
+const (
+	// UplinkAccepted 新的上行，可以继续处理
+	UplinkAccepted UplinkVerdict = "accepted"
+	// UplinkDuplicate 窗口内已处理过的上行（Webhook 重推或设备重传）
+	UplinkDuplicate UplinkVerdict = "duplicate"
+	// UplinkReplay 帧计数器小幅回退且不在去重窗口内，疑似重放
+	UplinkReplay UplinkVerdict = "replay"
+	// UplinkCounterReset 帧计数器大幅回退，按设备重新入网处理（入网事件丢失或未被处理），可以继续处理
+	UplinkCounterReset UplinkVerdict = "reset"
+)
+
+// UplinkDeduplicator 按 DevEUI + fCnt 以及 deduplicationId 对上行去重，
+// 并拒绝帧计数器小幅回退的上行。设备重新入网后需调用 Reset；入网事件丢失时，
+// 回退后的帧计数器离 0 比离上一次的值更近即视为计数器已归零，不会一直拒绝该设备的上行
+type UplinkDeduplicator struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time // 去重键 -> 首次出现时间
+	lastFCnt  map[string]uint32    // DevEUI -> 最近接受的帧计数器
+	lastPrune time.Time
+}
+
+// NewUplinkDeduplicator 创建去重器，window 为去重窗口
+func NewUplinkDeduplicator(window time.Duration) *UplinkDeduplicator {
+	return &UplinkDeduplicator{
+		window:   window,
+		seen:     make(map[string]time.Time),
+		lastFCnt: make(map[string]uint32),
+	}
+}
+
+// Check 判定一条上行是否需要处理，被接受的上行会被记录下来
+func (d *UplinkDeduplicator) Check(devEUI string, fCnt uint32, deduplicationID string) UplinkVerdict {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.prune(now)
+
+	frameKey := devEUI + "/" + strconv.FormatUint(uint64(fCnt), 10)
+	if _, ok := d.seen[frameKey]; ok {
+		return UplinkDuplicate
+	}
+	if deduplicationID != "" {
+		if _, ok := d.seen[deduplicationID]; ok {
+			return UplinkDuplicate
+		}
+	}
+	verdict := UplinkAccepted
+	if last, ok := d.lastFCnt[devEUI]; ok && fCnt <= last {
+		if last-fCnt <= fCnt {
+			return UplinkReplay
+		}
+		d.reset(devEUI)
+		verdict = UplinkCounterReset
+	}
+
+	d.seen[frameKey] = now
+	if deduplicationID != "" {
+		d.seen[deduplicationID] = now
+	}
+	d.lastFCnt[devEUI] = fCnt
+	return verdict
+}
+
+// Reset 清除设备的帧计数器记录，设备重新入网后帧计数器从 0 开始
+func (d *UplinkDeduplicator) Reset(devEUI string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.reset(devEUI)
+}
+
+// reset 清除设备的帧计数器与去重记录，调用方需持有锁
+func (d *UplinkDeduplicator) reset(devEUI string) {
+	delete(d.lastFCnt, devEUI)
+	prefix := devEUI + "/"
+	for key := range d.seen {
+		if strings.HasPrefix(key, prefix) {
+			delete(d.seen, key)
+		}
+	}
+}
+
+// prune 清理超出去重窗口的记录，每个窗口最多执行一次，调用方需持有锁
+func (d *UplinkDeduplicator) prune(now time.Time) {
+	if now.Sub(d.lastPrune) < d.window {
+		return
+	}
+	for key, t := range d.seen {
+		if now.Sub(t) > d.window {
+			delete(d.seen, key)
+		}
+	}
+	d.lastPrune = now
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUplinkDeduplicator(t *testing.T) {
+	type step struct {
+		devEUI  string
+		fCnt    uint32
+		dedupID string
+		reset   bool // 先模拟设备重新入网
+		want    UplinkVerdict
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "递增帧计数器",
+			steps: []step{
+				{devEUI: "a", fCnt: 1, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 2, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 10, want: UplinkAccepted},
+			},
+		},
+		{
+			name: "同一帧重推",
+			steps: []step{
+				{devEUI: "a", fCnt: 5, dedupID: "d1", want: UplinkAccepted},
+				{devEUI: "a", fCnt: 5, dedupID: "d1", want: UplinkDuplicate},
+				{devEUI: "a", fCnt: 5, want: UplinkDuplicate},
+			},
+		},
+		{
+			name: "deduplicationId 相同",
+			steps: []step{
+				{devEUI: "a", fCnt: 5, dedupID: "d1", want: UplinkAccepted},
+				{devEUI: "a", fCnt: 6, dedupID: "d1", want: UplinkDuplicate},
+				{devEUI: "a", fCnt: 6, dedupID: "d2", want: UplinkAccepted},
+			},
+		},
+		{
+			name: "帧计数器回退",
+			steps: []step{
+				{devEUI: "a", fCnt: 5, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 3, want: UplinkReplay},
+				{devEUI: "a", fCnt: 6, want: UplinkAccepted},
+			},
+		},
+		{
+			name: "重新入网后帧计数器归零",
+			steps: []step{
+				{devEUI: "a", fCnt: 5, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 0, reset: true, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 1, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 1, want: UplinkDuplicate},
+			},
+		},
+		{
+			name: "重新入网只影响该设备",
+			steps: []step{
+				{devEUI: "a", fCnt: 5, want: UplinkAccepted},
+				{devEUI: "b", fCnt: 5, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 1, reset: true, want: UplinkAccepted},
+				{devEUI: "b", fCnt: 4, want: UplinkReplay},
+			},
+		},
+		{
+			name: "入网事件丢失时帧计数器大幅回退",
+			steps: []step{
+				{devEUI: "a", fCnt: 500, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 0, want: UplinkCounterReset},
+				{devEUI: "a", fCnt: 1, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 1, want: UplinkDuplicate},
+				{devEUI: "a", fCnt: 2, want: UplinkAccepted},
+			},
+		},
+		{
+			name: "回退后离上一次的值更近时仍视为重放",
+			steps: []step{
+				{devEUI: "a", fCnt: 500, want: UplinkAccepted},
+				{devEUI: "a", fCnt: 251, want: UplinkReplay},
+				{devEUI: "a", fCnt: 249, want: UplinkCounterReset},
+				{devEUI: "a", fCnt: 250, want: UplinkAccepted},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewUplinkDeduplicator(time.Minute)
+			for i, s := range tt.steps {
+				if s.reset {
+					d.Reset(s.devEUI)
+				}
+				if got := d.Check(s.devEUI, s.fCnt, s.dedupID); got != s.want {
+					t.Fatalf("第 %d 步 %s fCnt=%d: %s，期望 %s", i, s.devEUI, s.fCnt, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUplinkDeduplicatorWindow(t *testing.T) {
+	d := NewUplinkDeduplicator(10 * time.Millisecond)
+	if got := d.Check("a", 5, "d1"); got != UplinkAccepted {
+		t.Fatalf("首条上行: %s", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// 超出去重窗口后，旧帧不再被视为重推，而是按帧计数器回退判定为重放
+	if got := d.Check("a", 5, ""); got != UplinkReplay {
+		t.Fatalf("窗口外的旧帧: %s，期望 %s", got, UplinkReplay)
+	}
+	if got := d.Check("a", 6, "d1"); got != UplinkAccepted {
+		t.Fatalf("窗口外复用的 deduplicationId: %s，期望 %s", got, UplinkAccepted)
+	}
+}
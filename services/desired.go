@@ -0,0 +1,182 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DesiredValue 是一个期望的配置值及其设置时间
+type DesiredValue struct {
+	Value int       `json:"value"`
+	SetAt time.Time `json:"setAt"`
+}
+
+// DesiredConfig 是整体设置（fPort 15）所覆盖配置项的期望值，未设置的项为 nil
+type DesiredConfig struct {
+	Color       *DesiredValue `json:"color,omitempty"`
+	Frequency   *DesiredValue `json:"frequency,omitempty"`
+	Level       *DesiredValue `json:"level,omitempty"`
+	Manner      *DesiredValue `json:"manner,omitempty"`
+	RadarEnable *DesiredValue `json:"radarEnable,omitempty"`
+}
+
+// desiredField 是 DesiredConfig 中的一个配置项
+type desiredField struct {
+	name string
+	ptr  **DesiredValue
+}
+
+// fields 返回各配置项的名称与指针，便于逐项比较和合并
+func (c *DesiredConfig) fields() []desiredField {
+	return []desiredField{
+		{"color", &c.Color},
+		{"frequency", &c.Frequency},
+		{"level", &c.Level},
+		{"manner", &c.Manner},
+		{"radarEnable", &c.RadarEnable},
+	}
+}
+
+// set 将 LightConfig 中已设置的整体设置项记为期望值
+func (c *DesiredConfig) set(lc LightConfig, at time.Time) {
+	values := []*int{lc.Color, lc.Frequency, lc.Level, lc.Manner, lc.RadarEnable}
+	for i, f := range c.fields() {
+		if values[i] != nil {
+			*f.ptr = &DesiredValue{Value: *values[i], SetAt: at}
+		}
+	}
+}
+
+// merge 逐项合并 other，较晚设置的值优先
+func (c *DesiredConfig) merge(other DesiredConfig) {
+	theirs := other.fields()
+	for i, f := range c.fields() {
+		v := *theirs[i].ptr
+		if v != nil && (*f.ptr == nil || v.SetAt.After((*f.ptr).SetAt)) {
+			*f.ptr = v
+		}
+	}
+}
+
+// Diff 返回与已送达配置 lc 不一致的配置项名称，已送达配置中缺少的项同样视为不一致
+func (c DesiredConfig) Diff(lc LightConfig) []string {
+	delivered := []*int{lc.Color, lc.Frequency, lc.Level, lc.Manner, lc.RadarEnable}
+	var diff []string
+	for i, f := range c.fields() {
+		if want := *f.ptr; want != nil && (delivered[i] == nil || *delivered[i] != want.Value) {
+			diff = append(diff, f.name)
+		}
+	}
+	return diff
+}
+
+// Resolve 以期望值覆盖已送达配置，得到完整的整体设置；仍有缺失的项时返回其名称
+func (c DesiredConfig) Resolve(lc LightConfig) (LightConfig, []string) {
+	resolved := []**int{&lc.Color, &lc.Frequency, &lc.Level, &lc.Manner, &lc.RadarEnable}
+	var missing []string
+	for i, f := range c.fields() {
+		if want := *f.ptr; want != nil {
+			v := want.Value
+			*resolved[i] = &v
+		}
+		if *resolved[i] == nil {
+			missing = append(missing, f.name)
+		}
+	}
+	return lc, missing
+}
+
+// desiredFile 是期望状态的持久化格式
+type desiredFile struct {
+	Devices map[string]*DesiredConfig `json:"devices"`
+	Groups  map[string]*DesiredConfig `json:"groups"`
+}
+
+// DesiredStateStore 保存按设备（DevEUI）和按多播组设置的期望配置，
+// 变更后立即写入 dataDir 下的 desired_state.json
+type DesiredStateStore struct {
+	mu      sync.Mutex
+	path    string
+	devices map[string]*DesiredConfig
+	groups  map[string]*DesiredConfig
+}
+
+// NewDesiredStateStore 创建期望状态存储
+func NewDesiredStateStore(dataDir string) (*DesiredStateStore, error) {
+	s := &DesiredStateStore{
+		path:    filepath.Join(dataDir, "desired_state.json"),
+		devices: make(map[string]*DesiredConfig),
+		groups:  make(map[string]*DesiredConfig),
+	}
+
+	var file desiredFile
+	if err := loadJSONFile(s.path, &file); err != nil {
+		return nil, fmt.Errorf("读取期望状态失败: %w", err)
+	}
+	for k, v := range file.Devices {
+		s.devices[k] = v
+	}
+	for k, v := range file.Groups {
+		s.groups[k] = v
+	}
+	return s, nil
+}
+
+// SetDevice 记录对单个设备下发的期望配置
+func (s *DesiredStateStore) SetDevice(devEUI string, lc LightConfig) error {
+	return s.set(s.devices, devEUI, lc)
+}
+
+// SetGroup 记录对多播组下发的期望配置
+func (s *DesiredStateStore) SetGroup(group string, lc LightConfig) error {
+	return s.set(s.groups, group, lc)
+}
+
+// DeleteGroup 删除多播组的期望配置，多播组不存在期望配置时不做任何事
+func (s *DesiredStateStore) DeleteGroup(group string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.groups[group]
+	if !ok {
+		return nil
+	}
+	delete(s.groups, group)
+	if err := saveJSONFile(s.path, desiredFile{Devices: s.devices, Groups: s.groups}); err != nil {
+		s.groups[group] = c
+		return err
+	}
+	return nil
+}
+
+func (s *DesiredStateStore) set(m map[string]*DesiredConfig, key string, lc LightConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := m[key]
+	if !ok {
+		c = &DesiredConfig{}
+		m[key] = c
+	}
+	c.set(lc, time.Now())
+	return saveJSONFile(s.path, desiredFile{Devices: s.devices, Groups: s.groups})
+}
+
+// Effective 合并设备自身及其所属多播组的期望配置，每项取最近一次设置的值
+func (s *DesiredStateStore) Effective(devEUI string, groups []string) DesiredConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c DesiredConfig
+	for _, g := range groups {
+		if gc, ok := s.groups[g]; ok {
+			c.merge(*gc)
+		}
+	}
+	if dc, ok := s.devices[devEUI]; ok {
+		c.merge(*dc)
+	}
+	return c
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// intPtr 返回 v 的指针
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestDesiredStateEffective(t *testing.T) {
+	type set struct {
+		group string // 为空表示按设备设置
+		lc    LightConfig
+	}
+	tests := []struct {
+		name   string
+		sets   []set
+		groups []string
+		want   map[string]int
+	}{
+		{
+			name: "只有设备自身的期望",
+			sets: []set{{lc: LightConfig{Color: intPtr(1), Level: intPtr(2000)}}},
+			want: map[string]int{"color": 1, "level": 2000},
+		},
+		{
+			name:   "多播组后设置的项覆盖设备",
+			sets:   []set{{lc: LightConfig{Color: intPtr(1), Level: intPtr(2000)}}, {group: "g1", lc: LightConfig{Color: intPtr(0)}}},
+			groups: []string{"g1"},
+			want:   map[string]int{"color": 0, "level": 2000},
+		},
+		{
+			name:   "设备后设置的项覆盖多播组",
+			sets:   []set{{group: "g1", lc: LightConfig{Color: intPtr(0), Frequency: intPtr(60)}}, {lc: LightConfig{Color: intPtr(1)}}},
+			groups: []string{"g1"},
+			want:   map[string]int{"color": 1, "frequency": 60},
+		},
+		{
+			name:   "多个多播组逐项取最近设置的值",
+			sets:   []set{{group: "g1", lc: LightConfig{Manner: intPtr(1), Level: intPtr(500)}}, {group: "g2", lc: LightConfig{Level: intPtr(7000)}}},
+			groups: []string{"g2", "g1"},
+			want:   map[string]int{"manner": 1, "level": 7000},
+		},
+		{
+			name: "不属于的多播组不参与合并",
+			sets: []set{{group: "g1", lc: LightConfig{Color: intPtr(1)}}},
+			want: map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewDesiredStateStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, st := range tt.sets {
+				if st.group != "" {
+					err = s.SetGroup(st.group, st.lc)
+				} else {
+					err = s.SetDevice("dev", st.lc)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(time.Millisecond) // 保证设置时间先后可区分
+			}
+
+			got := make(map[string]int)
+			c := s.Effective("dev", tt.groups)
+			for _, f := range c.fields() {
+				if v := *f.ptr; v != nil {
+					got[f.name] = v.Value
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Effective = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDesiredConfigDiffResolve(t *testing.T) {
+	at := time.Now()
+	desired := DesiredConfig{
+		Color: &DesiredValue{Value: 1, SetAt: at},
+		Level: &DesiredValue{Value: 2000, SetAt: at},
+	}
+	tests := []struct {
+		name        string
+		delivered   LightConfig
+		wantDiff    []string
+		wantMissing []string
+	}{
+		{
+			name:        "尚未送达任何配置",
+			wantDiff:    []string{"color", "level"},
+			wantMissing: []string{"frequency", "manner", "radarEnable"},
+		},
+		{
+			name:        "部分不一致",
+			delivered:   LightConfig{Color: intPtr(1), Level: intPtr(500), Frequency: intPtr(60), Manner: intPtr(0), RadarEnable: intPtr(1)},
+			wantDiff:    []string{"level"},
+			wantMissing: nil,
+		},
+		{
+			name:        "已一致",
+			delivered:   LightConfig{Color: intPtr(1), Level: intPtr(2000), Frequency: intPtr(60)},
+			wantDiff:    nil,
+			wantMissing: []string{"manner", "radarEnable"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := desired.Diff(tt.delivered); !reflect.DeepEqual(diff, tt.wantDiff) {
+				t.Errorf("Diff = %v，期望 %v", diff, tt.wantDiff)
+			}
+			resolved, missing := desired.Resolve(tt.delivered)
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("Resolve 缺失 %v，期望 %v", missing, tt.wantMissing)
+			}
+			if *resolved.Color != 1 || *resolved.Level != 2000 {
+				t.Errorf("Resolve 未以期望值覆盖: color=%d level=%d", *resolved.Color, *resolved.Level)
+			}
+			if tt.delivered.Frequency != nil && *resolved.Frequency != *tt.delivered.Frequency {
+				t.Errorf("Resolve 改变了未设置期望的项: frequency=%d", *resolved.Frequency)
+			}
+		})
+	}
+}
+
+func TestDesiredStatePersist(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDesiredStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetGroup("g1", LightConfig{Frequency: intPtr(120)}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewDesiredStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := reloaded.Effective("dev", []string{"g1"}); c.Frequency == nil || c.Frequency.Value != 120 {
+		t.Fatalf("重新加载后 frequency = %+v，期望 120", c.Frequency)
+	}
+}
+
+func TestDesiredStateDeleteGroup(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDesiredStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetGroup("g1", LightConfig{Color: intPtr(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDevice("dev", LightConfig{Level: intPtr(2000)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteGroup("g1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteGroup("missing"); err != nil {
+		t.Fatalf("删除不存在的多播组: %v", err)
+	}
+
+	// 重新加载后多播组的期望配置不再参与合并，设备自身的期望配置保留
+	reloaded, err := NewDesiredStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := reloaded.Effective("dev", []string{"g1"}); c.Color != nil || c.Level == nil || c.Level.Value != 2000 {
+		t.Fatalf("删除多播组后 color=%+v level=%+v，期望 color 为空、level 为 2000", c.Color, c.Level)
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LightConfig 是灯具最近一次成功下发的配置，未下发过的项为 nil
+type LightConfig struct {
+	Color            *int       `json:"color,omitempty"`
+	Frequency        *int       `json:"frequency,omitempty"`
+	Level            *int       `json:"level,omitempty"`
+	Manner           *int       `json:"manner,omitempty"`
+	Switch           *int       `json:"switch,omitempty"`
+	RadarEnable      *int       `json:"radarEnable,omitempty"`
+	Brightness       *int       `json:"brightness,omitempty"`
+	Character        *int       `json:"character,omitempty"`
+	AccelerationMode *int       `json:"accelerationMode,omitempty"`
+	MulticastDevAddr string     `json:"multicastDevAddr,omitempty"`
+	UpdatedAt        *time.Time `json:"updatedAt,omitempty"`
+}
+
+// AlarmSample 是最近一次报警
+type AlarmSample struct {
+	WarnType int       `json:"warnType"`
+	At       time.Time `json:"at"`
+}
+
+// AccelerationSample 是一次三轴加速度采样，单位为 g
+type AccelerationSample struct {
+	X  float64   `json:"x"`
+	Y  float64   `json:"y"`
+	Z  float64   `json:"z"`
+	At time.Time `json:"at"`
+}
+
+// ReportedConfig 是设备应答读取配置命令时上报的配置
+type ReportedConfig struct {
+	Color       int       `json:"color"`
+	Frequency   int       `json:"frequency"`
+	Level       int       `json:"level"`
+	Manner      int       `json:"manner"`
+	RadarEnable int       `json:"radarEnable"`
+	Firmware    string    `json:"firmware"`
+	At          time.Time `json:"at"`
+}
+
+// DeviceState 汇总设备的最新配置与遥测
+type DeviceState struct {
+	DevEUI           string              `json:"devEUI"`
+	ProtocolVersion  int                 `json:"protocolVersion,omitempty"` // 设备标签 protocolVersion 声明的协议版本
+	Config           LightConfig         `json:"config"`
+	Reported         *ReportedConfig     `json:"reported,omitempty"` // 设备最近一次自行上报的配置
+	LastHeartbeat    *time.Time          `json:"lastHeartbeat,omitempty"`
+	LastAlarm        *AlarmSample        `json:"lastAlarm,omitempty"`
+	LastAcceleration *AccelerationSample `json:"lastAcceleration,omitempty"`
+	// 加速度基线与最近一次检测到碰撞的时间
+	AccelerationBaseline *AccelerationBaseline `json:"accelerationBaseline,omitempty"`
+	LastCollision        *time.Time            `json:"lastCollision,omitempty"`
+	// 安装姿态基线、最近一次姿态，以及偏转角是否超过阈值
+	TiltBaseline *TiltBaseline `json:"tiltBaseline,omitempty"`
+	LastTilt     *TiltSample   `json:"lastTilt,omitempty"`
+	Tilted       bool          `json:"tilted,omitempty"`
+	// 最近一次时间同步的时延补偿与时钟漂移
+	LastTimeSync *TimeSyncSample `json:"lastTimeSync,omitempty"`
+	// 最近一次事件中设备所属的 ChirpStack 设备配置文件
+	DeviceProfileID   string    `json:"deviceProfileId,omitempty"`
+	DeviceProfileName string    `json:"deviceProfileName,omitempty"`
+	DroppedUplinks    int       `json:"droppedUplinks,omitempty"` // 上行队列满时被丢弃的该设备任务数
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// DeviceStateStore 以 DevEUI 为键保存设备状态，并定期持久化到 dataDir 下的 device_state.json
+type DeviceStateStore struct {
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	path    string
+	states  map[string]*DeviceState
+	dirty   bool
+}
+
+// NewDeviceStateStore 创建设备状态存储
+func NewDeviceStateStore(dataDir string) (*DeviceStateStore, error) {
+	s := &DeviceStateStore{
+		path:   filepath.Join(dataDir, "device_state.json"),
+		states: make(map[string]*DeviceState),
+	}
+
+	var states []*DeviceState
+	if err := loadJSONFile(s.path, &states); err != nil {
+		return nil, fmt.Errorf("读取设备状态失败: %w", err)
+	}
+	for _, st := range states {
+		s.states[st.DevEUI] = st
+	}
+	return s, nil
+}
+
+// Get 返回设备状态
+func (s *DeviceStateStore) Get(devEUI string) (DeviceState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[devEUI]
+	if !ok {
+		return DeviceState{}, false
+	}
+	return *st, true
+}
+
+// Update 在锁内修改设备状态，设备尚无记录时先创建
+func (s *DeviceStateStore) Update(devEUI string, fn func(st *DeviceState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[devEUI]
+	if !ok {
+		st = &DeviceState{DevEUI: devEUI}
+		s.states[devEUI] = st
+	}
+	fn(st)
+	st.UpdatedAt = time.Now()
+	s.dirty = true
+}
+
+// UpdateConfig 合并一次成功下发的配置
+func (s *DeviceStateStore) UpdateConfig(devEUI string, fn func(c *LightConfig)) {
+	s.Update(devEUI, func(st *DeviceState) {
+		fn(&st.Config)
+		now := time.Now()
+		st.Config.UpdatedAt = &now
+	})
+}
+
+// Run 周期性地将状态落盘，直到 ctx 结束
+func (s *DeviceStateStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Flush()
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Flush 将有变更的设备状态写入文件
+func (s *DeviceStateStore) Flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return
+	}
+	states := make([]DeviceState, 0, len(s.states))
+	for _, st := range s.states {
+		states = append(states, *st)
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err := saveJSONFile(s.path, states); err != nil {
+		log.Error().Err(err).Msg("保存设备状态失败")
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeviceStateMerge(t *testing.T) {
+	s, err := NewDeviceStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const devEUI = "0000000000000001"
+	if _, ok := s.Get(devEUI); ok {
+		t.Fatal("未更新过的设备已有状态")
+	}
+
+	// 每次下发只更新对应的配置项，其余项保持不变
+	s.UpdateConfig(devEUI, func(c *LightConfig) { c.Color, c.Frequency = intPtr(1), intPtr(60) })
+	s.UpdateConfig(devEUI, func(c *LightConfig) { c.Level = intPtr(2000) })
+	s.UpdateConfig(devEUI, func(c *LightConfig) { c.Color = intPtr(0) })
+	heartbeat := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Update(devEUI, func(st *DeviceState) { st.LastHeartbeat = &heartbeat })
+
+	st, ok := s.Get(devEUI)
+	if !ok {
+		t.Fatal("设备状态不存在")
+	}
+	c := st.Config
+	if c.Color == nil || *c.Color != 0 || c.Frequency == nil || *c.Frequency != 60 || c.Level == nil || *c.Level != 2000 {
+		t.Fatalf("合并后的配置 %+v，期望 color=0 frequency=60 level=2000", c)
+	}
+	if c.Manner != nil || c.Switch != nil {
+		t.Fatalf("未下发过的配置项不为空: %+v", c)
+	}
+	if c.UpdatedAt == nil || st.UpdatedAt.IsZero() || st.DevEUI != devEUI {
+		t.Fatalf("设备状态 %+v 缺少 DevEUI 或更新时间", st)
+	}
+	if st.LastHeartbeat == nil || !st.LastHeartbeat.Equal(heartbeat) {
+		t.Fatalf("心跳时间 %v，期望 %v", st.LastHeartbeat, heartbeat)
+	}
+
+	// Get 返回副本，修改副本不影响存储
+	st.ProtocolVersion = 2
+	if got, _ := s.Get(devEUI); got.ProtocolVersion != 0 {
+		t.Fatal("修改 Get 返回的副本影响了存储中的状态")
+	}
+}
+
+func TestDeviceStateFlush(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device_state.json")
+	s, err := NewDeviceStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const devEUI = "0000000000000001"
+
+	// 没有变更时不写文件
+	s.Flush()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("没有变更时写入了文件: %v", err)
+	}
+
+	s.UpdateConfig(devEUI, func(c *LightConfig) { c.Level = intPtr(500) })
+	s.Update(devEUI, func(st *DeviceState) { st.ProtocolVersion, st.DroppedUplinks = 2, 3 })
+	s.Flush()
+
+	reloaded, err := NewDeviceStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := reloaded.Get(devEUI)
+	if !ok || st.Config.Level == nil || *st.Config.Level != 500 || st.ProtocolVersion != 2 || st.DroppedUplinks != 3 {
+		t.Fatalf("重新加载的状态 %+v", st)
+	}
+
+	// 已落盘后再次 Flush 不重写文件
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("没有新的变更时重写了文件: %v", err)
+	}
+
+	// 写入失败时保留变更，下次 Flush 重试。目标路径被非空目录占用时替换文件必然失败
+	s.Update(devEUI, func(st *DeviceState) { st.DeviceProfileName = "street-light" })
+	if err := os.MkdirAll(filepath.Join(path, "blocker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush()
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush()
+	reloaded, err = NewDeviceStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := reloaded.Get(devEUI); st.DeviceProfileName != "street-light" {
+		t.Fatalf("写入失败后重试未保存变更: %+v", st)
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DownlinkStatus 表示单播下行的投递状态
+type DownlinkStatus string
+
+const (
+	// DownlinkQueued 已进入 ChirpStack 设备队列
+	DownlinkQueued DownlinkStatus = "queued"
+	// DownlinkSent 网关已发出（收到 txack）
+	DownlinkSent DownlinkStatus = "sent"
+	// DownlinkAcknowledged 设备已确认（确认帧收到 ack）
+	DownlinkAcknowledged DownlinkStatus = "acknowledged"
+	// DownlinkFailed 发送失败或设备未确认
+	DownlinkFailed DownlinkStatus = "failed"
+	// DownlinkExpired 超过有效期仍未完成投递
+	DownlinkExpired DownlinkStatus = "expired"
+)
+
+// DownlinkRecord 记录一条单播下行及其投递状态，ID 为 ChirpStack 返回的队列项 ID
+type DownlinkRecord struct {
+	ID        string         `json:"id"`
+	DevEUI    string         `json:"devEUI"`
+	StakeNo   string         `json:"stakeNo,omitempty"`
+	FPort     uint32         `json:"fPort"`
+	Confirmed bool           `json:"confirmed"`
+	Data      []byte         `json:"data"`
+	Status    DownlinkStatus `json:"status"`
+	FCntDown  uint32         `json:"fCntDown,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// Done 判断下行是否已到达最终状态；非确认帧发出即视为完成
+func (r DownlinkRecord) Done() bool {
+	switch r.Status {
+	case DownlinkAcknowledged, DownlinkFailed, DownlinkExpired:
+		return true
+	case DownlinkSent:
+		return !r.Confirmed
+	default:
+		return false
+	}
+}
+
+// Delivered 判断下行是否已成功送达：确认帧需收到设备确认，非确认帧发出即可
+func (r DownlinkRecord) Delivered() bool {
+	return r.Status == DownlinkAcknowledged || (r.Status == DownlinkSent && !r.Confirmed)
+}
+
+// DownlinkTracker 跟踪单播下行的投递状态，并定期持久化到 dataDir 下的 downlinks.json
+type DownlinkTracker struct {
+	mu        sync.Mutex
+	flushMu   sync.Mutex
+	path      string
+	ttl       time.Duration
+	retention time.Duration
+	records   map[string]*DownlinkRecord
+	dirty     bool
+}
+
+// NewDownlinkTracker 创建下行跟踪器。ttl 为下行完成投递的期限，
+// retention 为记录的保留时长
+func NewDownlinkTracker(dataDir string, ttl, retention time.Duration) (*DownlinkTracker, error) {
+	t := &DownlinkTracker{
+		path:      filepath.Join(dataDir, "downlinks.json"),
+		ttl:       ttl,
+		retention: retention,
+		records:   make(map[string]*DownlinkRecord),
+	}
+
+	var records []*DownlinkRecord
+	if err := loadJSONFile(t.path, &records); err != nil {
+		return nil, fmt.Errorf("读取下行记录失败: %w", err)
+	}
+	for _, r := range records {
+		t.records[r.ID] = r
+	}
+	return t, nil
+}
+
+// Track 记录一条刚入队的下行
+func (t *DownlinkTracker) Track(rec DownlinkRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	rec.Status = DownlinkQueued
+	rec.CreatedAt, rec.UpdatedAt = now, now
+	t.records[rec.ID] = &rec
+	t.dirty = true
+}
+
+// Get 返回下行记录
+func (t *DownlinkTracker) Get(id string) (DownlinkRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.records[id]
+	if !ok {
+		return DownlinkRecord{}, false
+	}
+	t.expire(r, time.Now())
+	return *r, true
+}
+
+// Pending 返回设备尚未完成投递的下行，按创建时间排序
+func (t *DownlinkTracker) Pending(devEUI string) []DownlinkRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var pending []DownlinkRecord
+	for _, r := range t.records {
+		if r.DevEUI != devEUI {
+			continue
+		}
+		t.expire(r, now)
+		if !r.Done() {
+			pending = append(pending, *r)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+	return pending
+}
+
+// MarkSent 处理 txack 事件
+func (t *DownlinkTracker) MarkSent(id string, fCntDown uint32) (DownlinkRecord, bool) {
+	return t.update(id, func(r *DownlinkRecord) {
+		if r.Status == DownlinkQueued {
+			r.Status = DownlinkSent
+		}
+		r.FCntDown = fCntDown
+	})
+}
+
+// MarkAck 处理确认帧的 ack 事件，acknowledged 为 false 表示设备未确认
+func (t *DownlinkTracker) MarkAck(id string, acknowledged bool) (DownlinkRecord, bool) {
+	return t.update(id, func(r *DownlinkRecord) {
+		if acknowledged {
+			r.Status = DownlinkAcknowledged
+			r.Error = ""
+		} else {
+			r.Status = DownlinkFailed
+			r.Error = "not acknowledged by device"
+		}
+	})
+}
+
+// MarkFailed 将下行标记为失败
+func (t *DownlinkTracker) MarkFailed(id string, reason string) (DownlinkRecord, bool) {
+	return t.update(id, func(r *DownlinkRecord) {
+		r.Status = DownlinkFailed
+		r.Error = reason
+	})
+}
+
+// MarkExpired 处理 ChirpStack 报告的队列项过期
+func (t *DownlinkTracker) MarkExpired(id string, reason string) (DownlinkRecord, bool) {
+	return t.update(id, func(r *DownlinkRecord) {
+		r.Status = DownlinkExpired
+		r.Error = reason
+	})
+}
+
+// update 在锁内修改一条记录，记录不存在时返回 false
+func (t *DownlinkTracker) update(id string, fn func(r *DownlinkRecord)) (DownlinkRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.records[id]
+	if !ok {
+		return DownlinkRecord{}, false
+	}
+	fn(r)
+	r.UpdatedAt = time.Now()
+	t.dirty = true
+	return *r, true
+}
+
+// expire 将超过期限仍未完成投递的记录标记为过期，调用方需持有锁
+func (t *DownlinkTracker) expire(r *DownlinkRecord, now time.Time) {
+	if t.ttl > 0 && !r.Done() && now.Sub(r.CreatedAt) > t.ttl {
+		r.Status = DownlinkExpired
+		r.Error = "delivery not completed within " + t.ttl.String()
+		r.UpdatedAt = now
+		t.dirty = true
+	}
+}
+
+// Run 周期性地处理过期、清理超过保留时长的记录并落盘，直到 ctx 结束
+func (t *DownlinkTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Flush()
+			return
+		case now := <-ticker.C:
+			t.sweep(now)
+			t.Flush()
+		}
+	}
+}
+
+// sweep 将超过期限的记录标记为过期，并删除超过保留时长的记录
+func (t *DownlinkTracker) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, r := range t.records {
+		t.expire(r, now)
+		if t.retention > 0 && now.Sub(r.UpdatedAt) > t.retention {
+			delete(t.records, id)
+			t.dirty = true
+		}
+	}
+}
+
+// Flush 将有变更的记录写入文件
+func (t *DownlinkTracker) Flush() {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return
+	}
+	records := make([]DownlinkRecord, 0, len(t.records))
+	for _, r := range t.records {
+		records = append(records, *r)
+	}
+	t.dirty = false
+	t.mu.Unlock()
+
+	if err := saveJSONFile(t.path, records); err != nil {
+		log.Error().Err(err).Msg("保存下行记录失败")
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownlinkTrackerTransitions(t *testing.T) {
+	tests := []struct {
+		name          string
+		confirmed     bool
+		events        func(tr *DownlinkTracker, id string)
+		wantStatus    DownlinkStatus
+		wantDone      bool
+		wantDelivered bool
+		wantError     string
+	}{
+		{
+			name:       "刚入队",
+			events:     func(*DownlinkTracker, string) {},
+			wantStatus: DownlinkQueued,
+		},
+		{
+			name:          "非确认帧发出即完成",
+			events:        func(tr *DownlinkTracker, id string) { tr.MarkSent(id, 7) },
+			wantStatus:    DownlinkSent,
+			wantDone:      true,
+			wantDelivered: true,
+		},
+		{
+			name:       "确认帧发出后等待设备确认",
+			confirmed:  true,
+			events:     func(tr *DownlinkTracker, id string) { tr.MarkSent(id, 7) },
+			wantStatus: DownlinkSent,
+		},
+		{
+			name:      "确认帧收到设备确认",
+			confirmed: true,
+			events: func(tr *DownlinkTracker, id string) {
+				tr.MarkSent(id, 7)
+				tr.MarkAck(id, true)
+			},
+			wantStatus:    DownlinkAcknowledged,
+			wantDone:      true,
+			wantDelivered: true,
+		},
+		{
+			name:      "确认帧未被设备确认",
+			confirmed: true,
+			events: func(tr *DownlinkTracker, id string) {
+				tr.MarkSent(id, 7)
+				tr.MarkAck(id, false)
+			},
+			wantStatus: DownlinkFailed,
+			wantDone:   true,
+			wantError:  "not acknowledged by device",
+		},
+		{
+			name: "确认晚于 txack 到达时不回退为已发出",
+			events: func(tr *DownlinkTracker, id string) {
+				tr.MarkAck(id, true)
+				tr.MarkSent(id, 7)
+			},
+			wantStatus:    DownlinkAcknowledged,
+			wantDone:      true,
+			wantDelivered: true,
+		},
+		{
+			name:       "发送失败",
+			events:     func(tr *DownlinkTracker, id string) { tr.MarkFailed(id, "TX_FREQ") },
+			wantStatus: DownlinkFailed,
+			wantDone:   true,
+			wantError:  "TX_FREQ",
+		},
+		{
+			name:       "ChirpStack 报告队列项过期",
+			confirmed:  true,
+			events:     func(tr *DownlinkTracker, id string) { tr.MarkExpired(id, "ttl exceeded") },
+			wantStatus: DownlinkExpired,
+			wantDone:   true,
+			wantError:  "ttl exceeded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewDownlinkTracker(t.TempDir(), time.Hour, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr.Track(DownlinkRecord{ID: "dl-1", DevEUI: "0000000000000001", Confirmed: tt.confirmed})
+			tt.events(tr, "dl-1")
+
+			r, ok := tr.Get("dl-1")
+			if !ok {
+				t.Fatal("下行记录不存在")
+			}
+			if r.Status != tt.wantStatus || r.Done() != tt.wantDone || r.Delivered() != tt.wantDelivered || r.Error != tt.wantError {
+				t.Fatalf("状态 %s done=%v delivered=%v error=%q，期望 %s done=%v delivered=%v error=%q",
+					r.Status, r.Done(), r.Delivered(), r.Error, tt.wantStatus, tt.wantDone, tt.wantDelivered, tt.wantError)
+			}
+			if pending := tr.Pending("0000000000000001"); (len(pending) == 0) != tt.wantDone {
+				t.Fatalf("未完成的下行 %d 条，与 done=%v 不符", len(pending), tt.wantDone)
+			}
+		})
+	}
+
+	tr, err := NewDownlinkTracker(t.TempDir(), time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.MarkSent("dl-404", 1); ok {
+		t.Fatal("未跟踪的下行被更新")
+	}
+}
+
+func TestDownlinkTrackerExpiry(t *testing.T) {
+	tr, err := NewDownlinkTracker(t.TempDir(), time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const devEUI = "0000000000000001"
+	tr.Track(DownlinkRecord{ID: "queued", DevEUI: devEUI, Confirmed: true})
+	tr.Track(DownlinkRecord{ID: "sent", DevEUI: devEUI, Confirmed: true})
+	tr.Track(DownlinkRecord{ID: "acked", DevEUI: devEUI, Confirmed: true})
+	tr.Track(DownlinkRecord{ID: "fresh", DevEUI: devEUI})
+	tr.MarkSent("sent", 1)
+	tr.MarkAck("acked", true)
+
+	// 除 fresh 外都已超过投递期限
+	for _, id := range []string{"queued", "sent", "acked"} {
+		tr.records[id].CreatedAt = time.Now().Add(-2 * time.Minute)
+	}
+
+	pending := tr.Pending(devEUI)
+	if len(pending) != 1 || pending[0].ID != "fresh" {
+		t.Fatalf("未完成的下行 %+v，期望只有 fresh", pending)
+	}
+	for id, want := range map[string]DownlinkStatus{"queued": DownlinkExpired, "sent": DownlinkExpired, "acked": DownlinkAcknowledged, "fresh": DownlinkQueued} {
+		if r, _ := tr.Get(id); r.Status != want {
+			t.Errorf("%s 状态 %s，期望 %s", id, r.Status, want)
+		}
+	}
+	if r, _ := tr.Get("queued"); r.Error != "delivery not completed within 1m0s" {
+		t.Errorf("过期原因 %q", r.Error)
+	}
+}
+
+func TestDownlinkTrackerRetention(t *testing.T) {
+	dir := t.TempDir()
+	tr, err := NewDownlinkTracker(dir, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const devEUI = "0000000000000001"
+	tr.Track(DownlinkRecord{ID: "old", DevEUI: devEUI})
+	tr.Track(DownlinkRecord{ID: "recent", DevEUI: devEUI})
+	tr.MarkSent("old", 1)
+	tr.MarkSent("recent", 2)
+
+	// 保留时长从最后一次状态变化算起
+	now := time.Now()
+	tr.records["old"].UpdatedAt = now.Add(-2 * time.Hour)
+	tr.records["recent"].UpdatedAt = now.Add(-30 * time.Minute)
+	tr.sweep(now)
+	tr.Flush()
+
+	if _, ok := tr.Get("old"); ok {
+		t.Fatal("超过保留时长的记录未被清理")
+	}
+	if _, ok := tr.Get("recent"); !ok {
+		t.Fatal("保留时长内的记录被清理")
+	}
+
+	// 清理结果已落盘
+	reloaded, err := NewDownlinkTracker(dir, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Get("old"); ok {
+		t.Fatal("重新加载后仍有已清理的记录")
+	}
+	if r, ok := reloaded.Get("recent"); !ok || r.Status != DownlinkSent || r.FCntDown != 2 {
+		t.Fatalf("重新加载的记录 %+v", r)
+	}
+}
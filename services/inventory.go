@@ -0,0 +1,272 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"chirpstack-httpserver/protocol"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// 台账文件格式
+const (
+	InventoryCSV  = "csv"
+	InventoryXLSX = "xlsx"
+)
+
+// inventoryColumns 是导出台账的列顺序，也是导入时识别的标准列名
+var inventoryColumns = []string{"stakeNo", "devEUI", "road", "direction", "kilometrePost", "multicastGroups", "installDate", "protocolVersion"}
+
+// inventoryAliases 将勘测表中常见的中文表头映射到标准列名
+var inventoryAliases = map[string]string{
+	"桩号":   "stakeNo",
+	"设备编号": "devEUI",
+	"道路":   "road",
+	"路线":   "road",
+	"方向":   "direction",
+	"公里桩":  "kilometrePost",
+	"里程":   "kilometrePost",
+	"多播组":  "multicastGroups",
+	"安装日期": "installDate",
+	"协议版本": "protocolVersion",
+}
+
+// InventoryRow 是台账中的一行，Row 为其在文件中的行号（从 1 开始，含表头）
+type InventoryRow struct {
+	Row    int
+	Device Device
+}
+
+// ImportIssue 描述导入台账时发现的一个问题
+type ImportIssue struct {
+	Row     int    `json:"row,omitempty"`
+	StakeNo string `json:"stakeNo,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportReport 汇总一次台账导入的结果，存在 Issues 时不会写入注册表
+type ImportReport struct {
+	Total     int           `json:"total"`
+	Created   int           `json:"created"`
+	Updated   int           `json:"updated"`
+	Committed bool          `json:"committed"`
+	Issues    []ImportIssue `json:"issues,omitempty"`
+}
+
+// InventoryFormat 根据文件名后缀推断台账格式
+func InventoryFormat(filename string) (string, error) {
+	switch {
+	case strings.HasSuffix(strings.ToLower(filename), ".csv"):
+		return InventoryCSV, nil
+	case strings.HasSuffix(strings.ToLower(filename), ".xlsx"):
+		return InventoryXLSX, nil
+	default:
+		return "", fmt.Errorf("不支持的台账文件格式: %s", filename)
+	}
+}
+
+// ImportInventory 读取并校验台账，全部通过后一次性写入注册表；dryRun 时只校验不写入
+func ImportInventory(reg *DeviceRegistry, r io.Reader, format string, groups map[string]string, dryRun bool) (ImportReport, error) {
+	rows, issues, err := ParseInventory(r, format)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	issues = append(issues, ValidateInventory(rows, groups)...)
+
+	report := ImportReport{Total: len(rows), Issues: issues}
+	if len(issues) > 0 {
+		return report, nil
+	}
+
+	created, updated, issues, err := reg.Import(rows, dryRun)
+	if err != nil {
+		return report, err
+	}
+	report.Created, report.Updated, report.Issues = created, updated, issues
+	report.Committed = !dryRun && len(issues) == 0
+	return report, nil
+}
+
+// ParseInventory 解析 CSV 或 XLSX 台账。第一行必须是表头；
+// 单元格格式错误作为 ImportIssue 返回，文件本身无法读取时返回 error
+func ParseInventory(r io.Reader, format string) ([]InventoryRow, []ImportIssue, error) {
+	var records [][]string
+	switch format {
+	case InventoryCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		var err error
+		if records, err = cr.ReadAll(); err != nil {
+			return nil, nil, fmt.Errorf("读取 CSV 失败: %w", err)
+		}
+	case InventoryXLSX:
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("读取 XLSX 失败: %w", err)
+		}
+		defer f.Close()
+		if records, err = f.GetRows(f.GetSheetName(0)); err != nil {
+			return nil, nil, fmt.Errorf("读取 XLSX 工作表失败: %w", err)
+		}
+	default:
+		return nil, nil, fmt.Errorf("不支持的台账格式: %s", format)
+	}
+
+	if len(records) == 0 {
+		return nil, nil, errors.New("台账为空")
+	}
+
+	index := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if alias, ok := inventoryAliases[name]; ok {
+			name = alias
+		}
+		for _, col := range inventoryColumns {
+			if strings.EqualFold(name, col) {
+				index[col] = i
+			}
+		}
+	}
+	for _, col := range []string{"stakeNo", "devEUI"} {
+		if _, ok := index[col]; !ok {
+			return nil, nil, fmt.Errorf("台账缺少必需的列: %s", col)
+		}
+	}
+
+	var rows []InventoryRow
+	var issues []ImportIssue
+	for n, rec := range records[1:] {
+		rowNo := n + 2
+		cell := func(col string) string {
+			i, ok := index[col]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue // 跳过空行
+		}
+
+		d := Device{
+			StakeNo:   cell("stakeNo"),
+			DevEUI:    strings.ToLower(cell("devEUI")),
+			Road:      cell("road"),
+			Direction: cell("direction"),
+		}
+
+		if km := cell("kilometrePost"); km != "" {
+			v, err := strconv.ParseFloat(km, 64)
+			if err != nil {
+				issues = append(issues, ImportIssue{Row: rowNo, StakeNo: d.StakeNo, Message: "invalid kilometrePost: " + km})
+			}
+			d.KilometrePost = v
+		}
+		if date := cell("installDate"); date != "" {
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				issues = append(issues, ImportIssue{Row: rowNo, StakeNo: d.StakeNo, Message: "invalid installDate: " + date})
+			}
+			d.InstallDate = date
+		}
+		if pv := cell("protocolVersion"); pv != "" {
+			v, err := protocol.ParseVersion(pv)
+			if err != nil {
+				issues = append(issues, ImportIssue{Row: rowNo, StakeNo: d.StakeNo, Message: "invalid protocolVersion: " + pv})
+			}
+			d.ProtocolVersion = int(v)
+		}
+		d.MulticastGroups = strings.FieldsFunc(cell("multicastGroups"), func(r rune) bool {
+			return r == ';' || r == ',' || r == '，' || r == '|' || r == ' '
+		})
+		rows = append(rows, InventoryRow{Row: rowNo, Device: d})
+	}
+	return rows, issues, nil
+}
+
+// ValidateInventory 检查必填项、DevEUI 格式、文件内重复以及未知的多播组名称
+func ValidateInventory(rows []InventoryRow, groups map[string]string) []ImportIssue {
+	var issues []ImportIssue
+	stakeRows := make(map[string]int)
+	euiRows := make(map[string]int)
+
+	for _, row := range rows {
+		d := row.Device
+		add := func(format string, args ...any) {
+			issues = append(issues, ImportIssue{Row: row.Row, StakeNo: d.StakeNo, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if d.StakeNo == "" {
+			add("stakeNo is empty")
+		} else if prev, dup := stakeRows[d.StakeNo]; dup {
+			add("duplicate stakeNo, first seen at row %d", prev)
+		} else {
+			stakeRows[d.StakeNo] = row.Row
+		}
+
+		if !IsDevEUI(d.DevEUI) {
+			add("invalid devEUI: %q", d.DevEUI)
+		} else if prev, dup := euiRows[d.DevEUI]; dup {
+			add("duplicate devEUI %s, first seen at row %d", d.DevEUI, prev)
+		} else {
+			euiRows[d.DevEUI] = row.Row
+		}
+
+		for _, g := range d.MulticastGroups {
+			if _, ok := groups[g]; !ok {
+				add("unknown multicast group: %s", g)
+			}
+		}
+	}
+	return issues
+}
+
+// WriteInventory 将设备记录按标准列导出为 CSV 或 XLSX
+func WriteInventory(w io.Writer, format string, devices []Device) error {
+	records := [][]string{inventoryColumns}
+	for _, d := range devices {
+		km, pv := "", ""
+		if d.KilometrePost != 0 {
+			km = strconv.FormatFloat(d.KilometrePost, 'f', -1, 64)
+		}
+		if d.ProtocolVersion != 0 {
+			pv = strconv.Itoa(d.ProtocolVersion)
+		}
+		records = append(records, []string{
+			d.StakeNo, d.DevEUI, d.Road, d.Direction, km, strings.Join(d.MulticastGroups, ";"), d.InstallDate, pv,
+		})
+	}
+
+	switch format {
+	case InventoryCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.WriteAll(records); err != nil {
+			return fmt.Errorf("写入 CSV 失败: %w", err)
+		}
+		return nil
+	case InventoryXLSX:
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		for i, rec := range records {
+			cell, err := excelize.CoordinatesToCellName(1, i+1)
+			if err != nil {
+				return err
+			}
+			if err := f.SetSheetRow(sheet, cell, &rec); err != nil {
+				return fmt.Errorf("写入 XLSX 失败: %w", err)
+			}
+		}
+		return f.Write(w)
+	default:
+		return fmt.Errorf("不支持的台账格式: %s", format)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxFixture 将 records 写入单个工作表的 XLSX 文件
+func xlsxFixture(t *testing.T, records [][]string) *bytes.Buffer {
+	t.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	for i, rec := range records {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow(sheet, cell, &rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestParseInventory(t *testing.T) {
+	records := [][]string{
+		{"\ufeff桩号", "设备编号", "道路", "里程", "多播组", "安装日期", "协议版本", "备注"},
+		{"K1", "0102030405060708", "G30", "12.5", "group1;group2", "2024-05-01", "2", "忽略的列"},
+		{"", "", "", "", "", "", "", ""},
+		{"K2", "0102030405060709", "G30", "abc", "group1", "2024/05/01", "9"},
+	}
+	var csvBuf bytes.Buffer
+	for _, rec := range records {
+		csvBuf.WriteString(strings.Join(rec, ",") + "\n")
+	}
+
+	wantRows := []InventoryRow{
+		{Row: 2, Device: Device{StakeNo: "K1", DevEUI: "0102030405060708", Road: "G30", KilometrePost: 12.5, MulticastGroups: []string{"group1", "group2"}, InstallDate: "2024-05-01", ProtocolVersion: 2}},
+		{Row: 4, Device: Device{StakeNo: "K2", DevEUI: "0102030405060709", Road: "G30", MulticastGroups: []string{"group1"}, InstallDate: "2024/05/01"}},
+	}
+	wantIssues := []string{"invalid kilometrePost: abc", "invalid installDate: 2024/05/01", "invalid protocolVersion: 9"}
+
+	for _, tt := range []struct {
+		format string
+		data   *bytes.Buffer
+	}{
+		{InventoryCSV, &csvBuf},
+		{InventoryXLSX, xlsxFixture(t, records)},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			rows, issues, err := ParseInventory(tt.data, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, wantRows) {
+				t.Errorf("rows = %+v，期望 %+v", rows, wantRows)
+			}
+			var messages []string
+			for _, issue := range issues {
+				if issue.Row != 4 || issue.StakeNo != "K2" {
+					t.Errorf("问题 %+v 应属于第 4 行 K2", issue)
+				}
+				messages = append(messages, issue.Message)
+			}
+			if !reflect.DeepEqual(messages, wantIssues) {
+				t.Errorf("issues = %q，期望 %q", messages, wantIssues)
+			}
+		})
+	}
+}
+
+func TestParseInventoryMissingColumn(t *testing.T) {
+	if _, _, err := ParseInventory(strings.NewReader("stakeNo,road\nK1,G30\n"), InventoryCSV); err == nil {
+		t.Fatal("缺少 devEUI 列时应返回错误")
+	}
+	if _, _, err := ParseInventory(strings.NewReader(""), InventoryCSV); err == nil {
+		t.Fatal("空台账应返回错误")
+	}
+}
+
+func TestValidateInventory(t *testing.T) {
+	groups := map[string]string{"group1": "e81cd77b-f1e9-40fc-87ba-10e1fc935596"}
+	tests := []struct {
+		name string
+		rows []Device
+		want []string
+	}{
+		{
+			name: "合法",
+			rows: []Device{{StakeNo: "K1", DevEUI: "0102030405060708", MulticastGroups: []string{"group1"}}},
+		},
+		{
+			name: "DevEUI 格式错误",
+			rows: []Device{{StakeNo: "K1", DevEUI: "01020304"}},
+			want: []string{`invalid devEUI: "01020304"`},
+		},
+		{
+			name: "桩号为空",
+			rows: []Device{{DevEUI: "0102030405060708"}},
+			want: []string{"stakeNo is empty"},
+		},
+		{
+			name: "文件内重复",
+			rows: []Device{
+				{StakeNo: "K1", DevEUI: "0102030405060708"},
+				{StakeNo: "K1", DevEUI: "0102030405060709"},
+				{StakeNo: "K2", DevEUI: "0102030405060708"},
+			},
+			want: []string{"duplicate stakeNo, first seen at row 2", "duplicate devEUI 0102030405060708, first seen at row 2"},
+		},
+		{
+			name: "未知多播组",
+			rows: []Device{{StakeNo: "K1", DevEUI: "0102030405060708", MulticastGroups: []string{"group1", "group9"}}},
+			want: []string{"unknown multicast group: group9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := make([]InventoryRow, len(tt.rows))
+			for i, d := range tt.rows {
+				rows[i] = InventoryRow{Row: i + 2, Device: d}
+			}
+			var got []string
+			for _, issue := range ValidateInventory(rows, groups) {
+				got = append(got, issue.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("issues = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInventoryRoundTrip(t *testing.T) {
+	devices := []Device{
+		{StakeNo: "K1", DevEUI: "0102030405060708", Road: "G30", Direction: "上行", KilometrePost: 12.5, MulticastGroups: []string{"group1", "group2"}, InstallDate: "2024-05-01", ProtocolVersion: 2},
+		{StakeNo: "K2", DevEUI: "0102030405060709", MulticastGroups: []string{}},
+	}
+	for _, format := range []string{InventoryCSV, InventoryXLSX} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteInventory(&buf, format, devices); err != nil {
+				t.Fatal(err)
+			}
+			rows, issues, err := ParseInventory(&buf, format)
+			if err != nil || len(issues) > 0 {
+				t.Fatalf("err = %v，issues = %+v", err, issues)
+			}
+			got := make([]Device, len(rows))
+			for i, row := range rows {
+				got[i] = row.Device
+			}
+			if !reflect.DeepEqual(got, devices) {
+				t.Errorf("导入结果 %+v，期望 %+v", got, devices)
+			}
+		})
+	}
+}
+
+func TestImportInventory(t *testing.T) {
+	groups := map[string]string{"group1": "e81cd77b-f1e9-40fc-87ba-10e1fc935596"}
+	const valid = "stakeNo,devEUI,multicastGroups\nK1,0102030405060708,group1\nK2,0102030405060709,\n"
+
+	r := newTestRegistry(t)
+	report, err := ImportInventory(r, strings.NewReader(valid), InventoryCSV, groups, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Committed || report.Created != 2 || r.Len() != 0 {
+		t.Fatalf("dryRun 不应写入: %+v，注册表记录数 %d", report, r.Len())
+	}
+
+	report, err = ImportInventory(r, strings.NewReader("stakeNo,devEUI\nK3,0102030405060710\nK4,bad\n"), InventoryCSV, groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Committed || len(report.Issues) != 1 || r.Len() != 0 {
+		t.Fatalf("存在问题时不应写入任何行: %+v，注册表记录数 %d", report, r.Len())
+	}
+
+	report, err = ImportInventory(r, strings.NewReader(valid), InventoryCSV, groups, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Committed || report.Created != 2 || r.Len() != 2 {
+		t.Fatalf("report = %+v，注册表记录数 %d", report, r.Len())
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"chirpstack-httpserver/config"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+// MQTTEventHandler 处理一条 MQTT 集成事件，event 为主题的最后一级（up、join、ack 等）
+type MQTTEventHandler func(event string, payload []byte)
+
+// MQTTConsumer 订阅 ChirpStack MQTT 集成发布的应用事件
+type MQTTConsumer struct {
+	client  mqtt.Client
+	topic   string
+	qos     byte
+	handler MQTTEventHandler
+}
+
+// NewMQTTConsumer 创建 MQTT 订阅者，连接断开后会自动重连并重新订阅
+func NewMQTTConsumer(cfg config.MQTTConfig, handler MQTTEventHandler) *MQTTConsumer {
+	c := &MQTTConsumer{
+		topic:   cfg.Topic,
+		qos:     cfg.QoS,
+		handler: handler,
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Server).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(c.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Warn().Err(err).Str("server", cfg.Server).Msg("MQTT 连接断开，正在重连")
+		})
+	c.client = mqtt.NewClient(opts)
+	return c
+}
+
+// Start 连接 MQTT 服务器，订阅在连接建立后完成
+func (c *MQTTConsumer) Start(timeout time.Duration) error {
+	token := c.client.Connect()
+	if !token.WaitTimeout(timeout) {
+		return errors.New("连接 MQTT 服务器超时")
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("连接 MQTT 服务器失败: %w", err)
+	}
+	return nil
+}
+
+// Stop 断开 MQTT 连接
+func (c *MQTTConsumer) Stop() {
+	c.client.Disconnect(250)
+}
+
+// subscribe 在每次连接（包括自动重连）成功后订阅事件主题
+func (c *MQTTConsumer) subscribe(client mqtt.Client) {
+	token := client.Subscribe(c.topic, c.qos, c.onMessage)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		log.Error().Err(err).Str("topic", c.topic).Msg("MQTT 订阅失败")
+		return
+	}
+	log.Info().Str("topic", c.topic).Msg("MQTT 订阅成功")
+}
+
+// onMessage 从主题中解析事件类型后交给 handler。paho 按到达顺序逐条回调，
+// 因此同一设备的事件保持顺序
+func (c *MQTTConsumer) onMessage(_ mqtt.Client, msg mqtt.Message) {
+	event, ok := EventFromTopic(msg.Topic())
+	if !ok {
+		log.Warn().Str("topic", msg.Topic()).Msg("无法识别的 MQTT 事件主题，已忽略")
+		return
+	}
+	c.handler(event, msg.Payload())
+}
+
+// EventFromTopic 从 application/{appID}/device/{devEUI}/event/{event} 形式的主题中取出事件类型
+func EventFromTopic(topic string) (string, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 6 || parts[0] != "application" || parts[2] != "device" || parts[4] != "event" || parts[5] == "" {
+		return "", false
+	}
+	return parts[5], true
+}
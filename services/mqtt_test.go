@@ -0,0 +1,108 @@
+package services
+
+import (
+	"chirpstack-httpserver/config"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// startBroker 启动一个仅供测试使用的内嵌 MQTT 服务器，返回其监听地址
+func startBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+
+	server := mochi.New(&mochi.Options{InlineClient: true})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve()
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+	return server, "tcp://" + tcp.Address()
+}
+
+func TestMQTTConsumer(t *testing.T) {
+	server, addr := startBroker(t)
+
+	type received struct {
+		event   string
+		payload string
+	}
+	events := make(chan received, 4)
+
+	consumer := NewMQTTConsumer(config.MQTTConfig{
+		Server:   addr,
+		ClientID: "test-consumer",
+		Topic:    "application/+/device/+/event/+",
+		QoS:      1,
+	}, func(event string, payload []byte) {
+		events <- received{event, string(payload)}
+	})
+	if err := consumer.Start(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+
+	// 订阅在连接回调中异步完成，等待服务器上出现订阅后再发布
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Topics.Subscribers("application/a/device/b/event/up").Subscriptions) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("订阅未在期限内完成")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	publish := []struct{ topic, payload string }{
+		{"application/app1/device/0102030405060708/event/up", `{"fCnt":1}`},
+		{"application/app1/device/0102030405060708/command/down", `{}`}, // 不匹配订阅
+		{"application/app1/device/0102030405060708/event/join", `{"devAddr":"01020304"}`},
+	}
+	for _, p := range publish {
+		if err := server.Publish(p.topic, []byte(p.payload), false, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []received{
+		{"up", `{"fCnt":1}`},
+		{"join", `{"devAddr":"01020304"}`},
+	}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Errorf("收到 %+v，期望 %+v", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("未收到事件 %s", w.event)
+		}
+	}
+}
+
+func TestEventFromTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		event string
+		ok    bool
+	}{
+		{"application/app1/device/0102030405060708/event/up", "up", true},
+		{"application/app1/device/0102030405060708/event/txack", "txack", true},
+		{"application/app1/device/0102030405060708/event/", "", false},
+		{"application/app1/device/0102030405060708/command/down", "", false},
+		{"gateway/0102030405060708/event/up", "", false},
+	}
+	for _, tt := range tests {
+		event, ok := EventFromTopic(tt.topic)
+		if event != tt.event || ok != tt.ok {
+			t.Errorf("EventFromTopic(%q) = %q, %v，期望 %q, %v", tt.topic, event, ok, tt.event, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrGroupNotFound 表示多播组名称未登记
+	ErrGroupNotFound = errors.New("multicast group not found")
+	// ErrGroupConflict 表示多播组名称或 ChirpStack 多播组已被其他记录占用
+	ErrGroupConflict = errors.New("multicast group already exists")
+)
+
+// MulticastGroupDirectory 保存多播组名称（即 API 中的 groupId）到 ChirpStack 多播组 UUID 的映射，
+// 变更后立即写入 dataDir 下的 multicast_groups.json。文件不存在时以配置中的 multicast_groups 初始化，
+// 此后以该文件为准，配置中的映射不再生效
+type MulticastGroupDirectory struct {
+	mu     sync.Mutex
+	path   string
+	groups map[string]string
+}
+
+// NewMulticastGroupDirectory 加载多播组目录，seed 为文件不存在时的初始映射
+func NewMulticastGroupDirectory(dataDir string, seed map[string]string) (*MulticastGroupDirectory, error) {
+	d := &MulticastGroupDirectory{
+		path:   filepath.Join(dataDir, "multicast_groups.json"),
+		groups: maps.Clone(seed),
+	}
+	if d.groups == nil {
+		d.groups = make(map[string]string)
+	}
+	if err := loadJSONFile(d.path, &d.groups); err != nil {
+		return nil, fmt.Errorf("读取多播组目录失败: %w", err)
+	}
+	return d, nil
+}
+
+// Lookup 返回多播组名称对应的 ChirpStack 多播组 UUID
+func (d *MulticastGroupDirectory) Lookup(name string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id, ok := d.groups[name]
+	return id, ok
+}
+
+// Names 按名称排序返回全部多播组名称
+func (d *MulticastGroupDirectory) Names() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(d.groups))
+}
+
+// Snapshot 返回当前映射的副本
+func (d *MulticastGroupDirectory) Snapshot() map[string]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return maps.Clone(d.groups)
+}
+
+// Add 登记多播组，名称或 UUID 已登记时返回 ErrGroupConflict
+func (d *MulticastGroupDirectory) Add(name, multicastGroupID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.groups[name]; ok {
+		return fmt.Errorf("%w: groupId %s", ErrGroupConflict, name)
+	}
+	for other, id := range d.groups {
+		if id == multicastGroupID {
+			return fmt.Errorf("%w: multicast group %s is registered as %s", ErrGroupConflict, multicastGroupID, other)
+		}
+	}
+
+	d.groups[name] = multicastGroupID
+	if err := saveJSONFile(d.path, d.groups); err != nil {
+		delete(d.groups, name)
+		return err
+	}
+	return nil
+}
+
+// Delete 注销多播组
+func (d *MulticastGroupDirectory) Delete(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id, ok := d.groups[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	delete(d.groups, name)
+	if err := saveJSONFile(d.path, d.groups); err != nil {
+		d.groups[name] = id
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,508 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// OutboxKind 表示发件箱消息的类型
+type OutboxKind string
+
+const (
+	// OutboxWarnInfo 报警信息，对应 SendWarnInfoAt
+	OutboxWarnInfo OutboxKind = "warnInfo"
+	// OutboxHeartbeat 心跳信息，对应 SendLoraStatusAt。同一桩号只投递最新的一条
+	OutboxHeartbeat OutboxKind = "heartbeat"
+)
+
+// ErrOutboxMessageNotFound 表示死信列表中没有该消息
+var ErrOutboxMessageNotFound = errors.New("outbox message not found")
+
+// OutboxMessage 是一条待投递到状态服务器的通知
+type OutboxMessage struct {
+	ID          string     `json:"id"`
+	Kind        OutboxKind `json:"kind"`
+	StakeNo     string     `json:"stakeNo"`
+	WarnType    int        `json:"warnType,omitempty"`
+	LoraStatus  string     `json:"loraStatus,omitempty"` // 心跳上报的在线状态，为空时视为 Online
+	EventDate   time.Time  `json:"eventDate"`            // 事件的原始发生时间，重试时保持不变
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"nextAttempt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	LastError   string     `json:"lastError,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	DeadAt      *time.Time `json:"deadAt,omitempty"`
+}
+
+// outboxFile 是发件箱的持久化格式
+type outboxFile struct {
+	Pending []OutboxMessage `json:"pending"`
+	Dead    []OutboxMessage `json:"dead"`
+}
+
+// outboxEntry 是发件箱日志中的一行：Put 新增或更新待投递消息，Dead 将消息转入死信列表，Delete 移除消息
+type outboxEntry struct {
+	Put    *OutboxMessage `json:"put,omitempty"`
+	Dead   *OutboxMessage `json:"dead,omitempty"`
+	Delete string         `json:"delete,omitempty"`
+}
+
+// outboxCompactEntries 是发件箱日志的行数上限，超过后合并回 outbox.json
+const outboxCompactEntries = 1000
+
+// Outbox 是状态服务器通知的持久化发件箱：消息先落盘再投递，失败后按指数退避重试，
+// 超过有效期或被状态服务器明确拒绝的消息转入死信列表，可人工重新投递。
+// 每次变更只向 outbox.journal 追加一行，日志达到一定行数后才重写 outbox.json
+type Outbox struct {
+	mu          sync.Mutex
+	path        string
+	journalPath string
+	journal     *os.File
+	journalLen  int
+	client      *StatusServerClient
+	ttl         map[OutboxKind]time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	concurrency int
+	pending     map[string]*OutboxMessage
+	dead        map[string]*OutboxMessage
+	dirty       bool // 有变更未能写入日志，需要重写 outbox.json
+	wake        chan struct{}
+}
+
+// NewOutbox 创建发件箱并加载 dataDir 下 outbox.json 与 outbox.journal 中未完成的消息。
+// ttl 为各类消息的有效期，重试间隔从 minBackoff 开始翻倍，最长为 maxBackoff，
+// concurrency 为同时进行的投递数上限
+func NewOutbox(dataDir string, client *StatusServerClient, ttl map[OutboxKind]time.Duration, minBackoff, maxBackoff time.Duration, concurrency int) (*Outbox, error) {
+	o := &Outbox{
+		path:        filepath.Join(dataDir, "outbox.json"),
+		journalPath: filepath.Join(dataDir, "outbox.journal"),
+		client:      client,
+		ttl:         ttl,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		concurrency: max(concurrency, 1),
+		pending:     make(map[string]*OutboxMessage),
+		dead:        make(map[string]*OutboxMessage),
+		wake:        make(chan struct{}, 1),
+	}
+
+	var file outboxFile
+	if err := loadJSONFile(o.path, &file); err != nil {
+		return nil, fmt.Errorf("读取发件箱失败: %w", err)
+	}
+	for i := range file.Pending {
+		o.pending[file.Pending[i].ID] = &file.Pending[i]
+	}
+	for i := range file.Dead {
+		o.dead[file.Dead[i].ID] = &file.Dead[i]
+	}
+	if err := o.replay(); err != nil {
+		return nil, fmt.Errorf("读取发件箱日志失败: %w", err)
+	}
+
+	// 将上次运行的日志合并进 outbox.json，本次运行从空日志开始
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(o.journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("打开发件箱日志失败: %w", err)
+	}
+	o.journal = journal
+	o.dirty = true
+	if err := o.Flush(); err != nil {
+		return nil, fmt.Errorf("保存发件箱失败: %w", err)
+	}
+	return o, nil
+}
+
+// replay 按顺序重放发件箱日志。进程中断时最后一行可能不完整，读到无法解析的行即停止
+func (o *Outbox) replay() error {
+	f, err := os.Open(o.journalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e outboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Warn().Err(err).Str("path", o.journalPath).Msg("发件箱日志存在不完整的记录，已忽略其后的内容")
+			break
+		}
+		o.apply(e)
+	}
+	return scanner.Err()
+}
+
+// apply 将一条日志记录应用到内存中的消息
+func (o *Outbox) apply(e outboxEntry) {
+	switch {
+	case e.Put != nil:
+		delete(o.dead, e.Put.ID)
+		o.pending[e.Put.ID] = e.Put
+	case e.Dead != nil:
+		delete(o.pending, e.Dead.ID)
+		o.dead[e.Dead.ID] = e.Dead
+	case e.Delete != "":
+		delete(o.pending, e.Delete)
+		delete(o.dead, e.Delete)
+	}
+}
+
+// record 向发件箱日志追加一条记录，调用方需持有锁。写入失败时由下一次 Flush 重写 outbox.json 补齐。
+// 报警已向 ChirpStack 应答，新增或变更报警的记录立即刷到磁盘，断电也不会丢失
+func (o *Outbox) record(e outboxEntry) error {
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = o.journal.Write(append(line, '\n'))
+	}
+	if err == nil && (e.Put != nil && e.Put.Kind == OutboxWarnInfo || e.Dead != nil && e.Dead.Kind == OutboxWarnInfo) {
+		err = o.journal.Sync()
+	}
+	if err != nil {
+		o.dirty = true
+		return err
+	}
+	o.journalLen++
+	return nil
+}
+
+// recordOrLog 追加日志记录，失败时记录日志
+func (o *Outbox) recordOrLog(e outboxEntry) {
+	if err := o.record(e); err != nil {
+		log.Error().Err(err).Msg("写入发件箱日志失败")
+	}
+}
+
+// EnqueueWarnInfo 将报警信息写入发件箱
+func (o *Outbox) EnqueueWarnInfo(stakeNo string, warnType int, eventDate time.Time) (OutboxMessage, error) {
+	return o.enqueue(OutboxMessage{Kind: OutboxWarnInfo, StakeNo: stakeNo, WarnType: warnType, EventDate: eventDate})
+}
+
+// EnqueueHeartbeat 将心跳信息写入发件箱
+func (o *Outbox) EnqueueHeartbeat(stakeNo string, updateDate time.Time) (OutboxMessage, error) {
+	return o.EnqueueLoraStatus(stakeNo, LoraOnline, updateDate)
+}
+
+// EnqueueLoraStatus 将设备在线状态的变化写入发件箱，并取代该桩号尚未投递的旧状态
+func (o *Outbox) EnqueueLoraStatus(stakeNo string, loraStatus string, updateDate time.Time) (OutboxMessage, error) {
+	return o.enqueue(OutboxMessage{Kind: OutboxHeartbeat, StakeNo: stakeNo, LoraStatus: loraStatus, EventDate: updateDate})
+}
+
+// enqueue 记录消息并立即追加到日志，随后唤醒投递协程
+func (o *Outbox) enqueue(m OutboxMessage) (OutboxMessage, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return OutboxMessage{}, err
+	}
+
+	now := time.Now()
+	m.ID = hex.EncodeToString(id)
+	m.CreatedAt, m.NextAttempt = now, now
+	if ttl := o.ttl[m.Kind]; ttl > 0 {
+		m.ExpiresAt = now.Add(ttl)
+	}
+
+	o.mu.Lock()
+	if !o.supersede(&m) {
+		o.mu.Unlock()
+		return m, nil
+	}
+	o.pending[m.ID] = &m
+	err := o.record(outboxEntry{Put: &m})
+	o.mu.Unlock()
+
+	if err != nil {
+		return m, fmt.Errorf("保存发件箱失败: %w", err)
+	}
+	o.notify()
+	return m, nil
+}
+
+// Pending 返回等待投递的消息，按创建时间排序
+func (o *Outbox) Pending() []OutboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return sortedMessages(o.pending)
+}
+
+// DeadLetters 返回死信列表，按创建时间排序
+func (o *Outbox) DeadLetters() []OutboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return sortedMessages(o.dead)
+}
+
+// Redrive 将死信重新放回待投递队列，重置重试次数和有效期
+func (o *Outbox) Redrive(id string) (OutboxMessage, error) {
+	o.mu.Lock()
+	m, ok := o.dead[id]
+	if !ok {
+		o.mu.Unlock()
+		return OutboxMessage{}, ErrOutboxMessageNotFound
+	}
+	o.revive(m, time.Now())
+	revived := *m // 解锁后投递协程可能随时修改 m
+	o.mu.Unlock()
+
+	o.notify()
+	return revived, nil
+}
+
+// RedriveAll 将全部死信重新放回待投递队列，返回重新投递的数量
+func (o *Outbox) RedriveAll() int {
+	o.mu.Lock()
+	now := time.Now()
+	n := len(o.dead)
+	for _, m := range o.dead {
+		o.revive(m, now)
+	}
+	o.mu.Unlock()
+
+	o.notify()
+	return n
+}
+
+// revive 将死信移回待投递队列，调用方需持有锁
+func (o *Outbox) revive(m *OutboxMessage, now time.Time) {
+	delete(o.dead, m.ID)
+	m.Attempts = 0
+	m.DeadAt = nil
+	m.NextAttempt = now
+	m.ExpiresAt = time.Time{}
+	if ttl := o.ttl[m.Kind]; ttl > 0 {
+		m.ExpiresAt = now.Add(ttl)
+	}
+	if !o.supersede(m) {
+		o.recordOrLog(outboxEntry{Delete: m.ID})
+		return
+	}
+	o.pending[m.ID] = m
+	o.recordOrLog(outboxEntry{Put: m})
+}
+
+// supersede 保证每个桩号至多有一条待投递的在线状态消息，只保留事件时间最新的一条，
+// 避免重试中的旧状态晚于新状态送达，使状态服务器上的在线状态倒退。
+// 返回 m 是否需要放入待投递队列，调用方需持有锁
+func (o *Outbox) supersede(m *OutboxMessage) bool {
+	if m.Kind != OutboxHeartbeat {
+		return true
+	}
+	for id, p := range o.pending {
+		if id == m.ID || p.Kind != OutboxHeartbeat || p.StakeNo != m.StakeNo {
+			continue
+		}
+		if p.EventDate.After(m.EventDate) {
+			log.Info().Str("id", m.ID).Str("stakeNo", m.StakeNo).Str("loraStatus", m.LoraStatus).Str("newer", p.ID).Msg("在线状态早于待投递的状态，已丢弃")
+			return false
+		}
+		delete(o.pending, id)
+		o.recordOrLog(outboxEntry{Delete: id})
+		log.Info().Str("id", id).Str("stakeNo", p.StakeNo).Str("loraStatus", p.LoraStatus).Str("newer", m.ID).Msg("待投递的在线状态已被新状态取代")
+	}
+	return true
+}
+
+// notify 唤醒投递协程
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run 投递到期的消息，日志过长或有变更未写入日志时重写 outbox.json，直到 ctx 结束
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			o.flushOrLog()
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+		o.deliverDue(ctx)
+
+		o.mu.Lock()
+		compact := o.dirty || o.journalLen >= outboxCompactEntries
+		o.mu.Unlock()
+		if compact {
+			o.flushOrLog()
+		}
+	}
+}
+
+// deliverDue 以有限并发投递所有到期的消息。报警先于心跳开始投递，
+// 状态服务器无响应时报警不会排在积压的心跳之后逐条等待超时。
+// 已超过有效期的消息不再投递，直接转入死信列表，以免过时的心跳被当作当前状态送达
+func (o *Outbox) deliverDue(ctx context.Context) {
+	o.mu.Lock()
+	now := time.Now()
+	var due []OutboxMessage
+	for id, m := range o.pending {
+		if !m.ExpiresAt.IsZero() && now.After(m.ExpiresAt) {
+			delete(o.pending, id)
+			m.DeadAt = &now
+			if m.LastError == "" {
+				m.LastError = "expired before delivery"
+			}
+			o.dead[id] = m
+			o.recordOrLog(outboxEntry{Dead: m})
+			log.Error().Str("id", id).Str("kind", string(m.Kind)).Str("stakeNo", m.StakeNo).Int("attempts", m.Attempts).Msg("状态服务器通知已超过有效期，已转入死信列表")
+			continue
+		}
+		if !m.NextAttempt.After(now) {
+			due = append(due, *m)
+		}
+	}
+	o.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool {
+		if wi, wj := due[i].Kind == OutboxWarnInfo, due[j].Kind == OutboxWarnInfo; wi != wj {
+			return wi
+		}
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+
+	sem := make(chan struct{}, o.concurrency)
+	var wg sync.WaitGroup
+	for _, m := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			o.finish(m.ID, o.send(m))
+		}()
+	}
+	wg.Wait()
+}
+
+// send 按消息类型调用状态服务器
+func (o *Outbox) send(m OutboxMessage) error {
+	switch m.Kind {
+	case OutboxWarnInfo:
+		return o.client.SendWarnInfoAt(m.StakeNo, m.WarnType, m.EventDate)
+	case OutboxHeartbeat:
+		if m.LoraStatus == "" {
+			return o.client.SendHeartbeatAt(m.StakeNo, m.EventDate)
+		}
+		return o.client.SendLoraStatusAt(m.StakeNo, m.LoraStatus, m.EventDate)
+	default:
+		return &permanentError{fmt.Errorf("未知的消息类型: %s", m.Kind)}
+	}
+}
+
+// permanentError 表示不应重试的投递失败
+type permanentError struct {
+	error
+}
+
+// finish 根据投递结果删除消息、安排重试或转入死信列表
+func (o *Outbox) finish(id string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	m, ok := o.pending[id]
+	if !ok {
+		return
+	}
+
+	if err == nil {
+		delete(o.pending, id)
+		o.recordOrLog(outboxEntry{Delete: id})
+		log.Info().Str("id", id).Str("kind", string(m.Kind)).Str("stakeNo", m.StakeNo).Int("attempts", m.Attempts+1).Msg("状态服务器通知投递成功")
+		return
+	}
+
+	now := time.Now()
+	m.Attempts++
+	m.LastError = err.Error()
+
+	var statusErr *StatusCodeError
+	var permErr *permanentError
+	permanent := errors.As(err, &permErr) || (errors.As(err, &statusErr) && statusErr.Permanent())
+	expired := !m.ExpiresAt.IsZero() && now.After(m.ExpiresAt)
+	if permanent || expired {
+		delete(o.pending, id)
+		m.DeadAt = &now
+		o.dead[id] = m
+		o.recordOrLog(outboxEntry{Dead: m})
+		log.Error().Err(err).Str("id", id).Str("kind", string(m.Kind)).Str("stakeNo", m.StakeNo).Int("attempts", m.Attempts).Msg("状态服务器通知投递失败，已转入死信列表")
+		return
+	}
+
+	backoff := o.minBackoff << (m.Attempts - 1)
+	if backoff <= 0 || backoff > o.maxBackoff {
+		backoff = o.maxBackoff
+	}
+	m.NextAttempt = now.Add(backoff)
+	o.recordOrLog(outboxEntry{Put: m})
+	log.Warn().Err(err).Str("id", id).Str("kind", string(m.Kind)).Str("stakeNo", m.StakeNo).Int("attempts", m.Attempts).Time("nextAttempt", m.NextAttempt).Msg("状态服务器通知投递失败，稍后重试")
+}
+
+// Flush 将发件箱完整写入 outbox.json 并清空日志
+func (o *Outbox) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.dirty && o.journalLen == 0 {
+		return nil
+	}
+	// outbox.json 落到磁盘后才能清空日志，否则断电时两者可能同时丢失
+	file := outboxFile{Pending: sortedMessages(o.pending), Dead: sortedMessages(o.dead)}
+	if err := syncJSONFile(o.path, file); err != nil {
+		o.dirty = true
+		return err
+	}
+	// outbox.json 已包含日志中的全部变更，清空失败时重放结果相同，下次再试
+	if err := o.journal.Truncate(0); err != nil {
+		o.dirty = true
+		return err
+	}
+	o.journalLen = 0
+	o.dirty = false
+	return nil
+}
+
+// flushOrLog 落盘，失败时记录日志，下次再试
+func (o *Outbox) flushOrLog() {
+	if err := o.Flush(); err != nil {
+		log.Error().Err(err).Msg("保存发件箱失败")
+	}
+}
+
+// sortedMessages 复制消息并按创建时间排序
+func sortedMessages(messages map[string]*OutboxMessage) []OutboxMessage {
+	list := make([]OutboxMessage, 0, len(messages))
+	for _, m := range messages {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/config"
+)
+
+// statusServerStub 记录收到的请求，并按 code 应答
+type statusServerStub struct {
+	mu       sync.Mutex
+	code     int
+	requests []string // 每个请求的路径、桩号以及心跳中的在线状态
+}
+
+func (s *statusServerStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request := r.URL.Path + " " + r.URL.Query().Get("stakeNo")
+	if r.Method == http.MethodPost {
+		var beat struct{ StakeNo, LoraStatus string }
+		_ = json.NewDecoder(r.Body).Decode(&beat)
+		request = r.URL.Path + " " + beat.StakeNo + " " + beat.LoraStatus
+	}
+	s.requests = append(s.requests, request)
+	w.WriteHeader(s.code)
+}
+
+// newTestOutbox 创建指向 stub 的发件箱
+func newTestOutbox(t *testing.T, dir string, stub *statusServerStub, ttl time.Duration) *Outbox {
+	t.Helper()
+
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	client := NewStatusServerClient(config.Config{StatusServerURL: server.URL, HTTPTimeout: time.Second})
+	o, err := NewOutbox(dir, client, map[OutboxKind]time.Duration{OutboxWarnInfo: ttl, OutboxHeartbeat: ttl}, time.Second, 8*time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	o := newTestOutbox(t, t.TempDir(), &statusServerStub{}, time.Hour)
+	m, err := o.EnqueueWarnInfo("K1", WarnManual, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重试间隔从 minBackoff 开始翻倍，最长为 maxBackoff
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		before := time.Now()
+		o.finish(m.ID, &StatusCodeError{Code: http.StatusServiceUnavailable})
+		got := o.Pending()[0]
+		if got.Attempts != attempt+1 {
+			t.Fatalf("attempts = %d，期望 %d", got.Attempts, attempt+1)
+		}
+		if backoff := got.NextAttempt.Sub(before); backoff < want || backoff > want+time.Second {
+			t.Fatalf("第 %d 次失败后重试间隔 %v，期望 %v", attempt+1, backoff, want)
+		}
+	}
+}
+
+func TestOutboxFinish(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		err      error
+		wantLeft int // 仍在待投递队列中的消息数
+		wantDead int
+	}{
+		{"投递成功", time.Hour, nil, 0, 0},
+		{"服务不可用时重试", time.Hour, &StatusCodeError{Code: http.StatusServiceUnavailable}, 1, 0},
+		{"限流时重试", time.Hour, &StatusCodeError{Code: http.StatusTooManyRequests}, 1, 0},
+		{"网络错误时重试", time.Hour, errors.New("connection refused"), 1, 0},
+		{"请求被拒绝转入死信", time.Hour, &StatusCodeError{Code: http.StatusBadRequest}, 0, 1},
+		{"超过有效期转入死信", time.Nanosecond, &StatusCodeError{Code: http.StatusServiceUnavailable}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestOutbox(t, t.TempDir(), &statusServerStub{}, tt.ttl)
+			m, err := o.EnqueueHeartbeat("K1", time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+
+			o.finish(m.ID, tt.err)
+			if left, dead := len(o.Pending()), len(o.DeadLetters()); left != tt.wantLeft || dead != tt.wantDead {
+				t.Fatalf("待投递 %d、死信 %d，期望 %d、%d", left, dead, tt.wantLeft, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestOutboxRedrive(t *testing.T) {
+	o := newTestOutbox(t, t.TempDir(), &statusServerStub{}, time.Hour)
+	m, err := o.EnqueueWarnInfo("K1", WarnAccident, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.finish(m.ID, &StatusCodeError{Code: http.StatusBadRequest})
+
+	if _, err := o.Redrive("missing"); !errors.Is(err, ErrOutboxMessageNotFound) {
+		t.Fatalf("重新投递不存在的死信: %v", err)
+	}
+	revived, err := o.Redrive(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revived.Attempts != 0 || revived.DeadAt != nil || len(o.DeadLetters()) != 0 || len(o.Pending()) != 1 {
+		t.Fatalf("重新投递后 %+v，死信 %d，待投递 %d", revived, len(o.DeadLetters()), len(o.Pending()))
+	}
+}
+
+func TestOutboxDeliverAlarmsFirst(t *testing.T) {
+	stub := &statusServerStub{code: http.StatusOK}
+	o := newTestOutbox(t, t.TempDir(), stub, time.Hour)
+	for _, stakeNo := range []string{"K1", "K2", "K3"} {
+		if _, err := o.EnqueueHeartbeat(stakeNo, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := o.EnqueueWarnInfo("K9", WarnAccident, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	o.deliverDue(context.Background())
+	if len(stub.requests) != 4 || stub.requests[0] != "/warn/warnInfo K9" {
+		t.Fatalf("投递顺序 %v，期望报警最先投递", stub.requests)
+	}
+	if len(o.Pending()) != 0 {
+		t.Fatalf("仍有 %d 条消息待投递", len(o.Pending()))
+	}
+}
+
+func TestOutboxJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	o := newTestOutbox(t, dir, &statusServerStub{}, time.Hour)
+
+	delivered, _ := o.EnqueueWarnInfo("K1", WarnManual, time.Now())
+	retried, _ := o.EnqueueWarnInfo("K2", WarnManual, time.Now())
+	dead, _ := o.EnqueueHeartbeat("K3", time.Now())
+	o.finish(delivered.ID, nil)
+	o.finish(retried.ID, &StatusCodeError{Code: http.StatusServiceUnavailable})
+	o.finish(dead.ID, &StatusCodeError{Code: http.StatusBadRequest})
+
+	// 变更只追加到日志，未重写 outbox.json
+	if o.journalLen != 6 {
+		t.Fatalf("日志行数 %d，期望 6", o.journalLen)
+	}
+	wantPending, wantDead := o.Pending(), o.DeadLetters()
+
+	// 模拟进程在写最后一行时中断
+	f, err := os.OpenFile(filepath.Join(dir, "outbox.journal"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"put":{"id":"trunc`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reloaded := newTestOutbox(t, dir, &statusServerStub{}, time.Hour)
+	if got := reloaded.Pending(); !reflect.DeepEqual(ids(got), ids(wantPending)) || got[0].Attempts != 1 {
+		t.Fatalf("重放后待投递 %+v，期望 %+v", got, wantPending)
+	}
+	if got := reloaded.DeadLetters(); !reflect.DeepEqual(ids(got), ids(wantDead)) {
+		t.Fatalf("重放后死信 %+v，期望 %+v", got, wantDead)
+	}
+	// 加载时已将日志合并进 outbox.json
+	if info, err := os.Stat(filepath.Join(dir, "outbox.journal")); err != nil || info.Size() != 0 || reloaded.journalLen != 0 {
+		t.Fatalf("加载后日志未清空: %v", err)
+	}
+}
+
+// ids 提取消息 ID
+func ids(messages []OutboxMessage) []string {
+	var list []string
+	for _, m := range messages {
+		list = append(list, m.ID)
+	}
+	return list
+}
+
+func TestOutboxSupersedeLoraStatus(t *testing.T) {
+	stub := &statusServerStub{code: http.StatusOK}
+	o := newTestOutbox(t, t.TempDir(), stub, time.Hour)
+	start := time.Now()
+
+	// 离线通知投递失败，等待重试期间设备恢复在线
+	offline, _ := o.EnqueueLoraStatus("K1", LoraOffline, start)
+	o.finish(offline.ID, &StatusCodeError{Code: http.StatusServiceUnavailable})
+	if _, err := o.EnqueueHeartbeat("K1", start.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// 其他桩号和报警不受影响
+	if _, err := o.EnqueueLoraStatus("K2", LoraOffline, start); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.EnqueueWarnInfo("K1", WarnManual, start); err != nil {
+		t.Fatal(err)
+	}
+	// 事件时间更早的状态不会取代已排队的新状态
+	if _, err := o.EnqueueLoraStatus("K1", LoraOffline, start.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(o.Pending()); n != 3 {
+		t.Fatalf("待投递 %d 条，期望 3 条", n)
+	}
+
+	o.deliverDue(context.Background())
+	want := []string{"/warn/warnInfo K1", "/equipmentfailure/sendBeat K1 Online", "/equipmentfailure/sendBeat K2 Offline"}
+	if !reflect.DeepEqual(stub.requests, want) {
+		t.Fatalf("投递 %q，期望 %q", stub.requests, want)
+	}
+}
+
+func TestOutboxRedriveSupersededStatus(t *testing.T) {
+	o := newTestOutbox(t, t.TempDir(), &statusServerStub{}, time.Hour)
+	start := time.Now()
+
+	offline, _ := o.EnqueueLoraStatus("K1", LoraOffline, start)
+	o.finish(offline.ID, &StatusCodeError{Code: http.StatusBadRequest})
+	online, _ := o.EnqueueHeartbeat("K1", start.Add(time.Minute))
+
+	// 重新投递旧的离线死信时，已排队的新状态优先
+	if n := o.RedriveAll(); n != 1 {
+		t.Fatalf("重新投递 %d 条死信，期望 1 条", n)
+	}
+	if got := ids(o.Pending()); !reflect.DeepEqual(got, []string{online.ID}) {
+		t.Fatalf("待投递 %v，期望只有 %s", got, online.ID)
+	}
+	if n := len(o.DeadLetters()); n != 0 {
+		t.Fatalf("死信 %d 条，期望 0 条", n)
+	}
+}
+
+func TestOutboxDeliverExpired(t *testing.T) {
+	stub := &statusServerStub{code: http.StatusOK}
+	o := newTestOutbox(t, t.TempDir(), stub, time.Millisecond)
+	if _, err := o.EnqueueHeartbeat("K1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// 状态服务器恢复后，过期的心跳不再作为当前状态投递
+	o.deliverDue(context.Background())
+	if len(stub.requests) != 0 {
+		t.Fatalf("投递了过期消息: %v", stub.requests)
+	}
+	if dead := o.DeadLetters(); len(dead) != 1 || len(o.Pending()) != 0 || dead[0].Attempts != 0 {
+		t.Fatalf("死信 %+v，待投递 %d，期望过期消息未经投递转入死信", dead, len(o.Pending()))
+	}
+}
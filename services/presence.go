@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DevicePresence 记录设备最近一次上行的时间及其在线状态
+type DevicePresence struct {
+	DevEUI    string    `json:"devEUI"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Online    bool      `json:"online"`
+	ChangedAt time.Time `json:"changedAt"` // 在线状态最近一次变化的时间
+}
+
+// PresenceChangeFunc 在设备上线或离线时被调用
+type PresenceChangeFunc func(devEUI string, online bool, at time.Time)
+
+// PresenceMonitor 跟踪每个 DevEUI 的最近上行时间，超过 timeout 没有上行即判定离线。
+// 状态定期持久化到 dataDir 下的 presence.json，重启后继续沿用
+type PresenceMonitor struct {
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	path    string
+	timeout time.Duration
+	devices map[string]*DevicePresence
+	dirty   bool
+}
+
+// NewPresenceMonitor 创建在线状态监视器
+func NewPresenceMonitor(dataDir string, timeout time.Duration) (*PresenceMonitor, error) {
+	m := &PresenceMonitor{
+		path:    filepath.Join(dataDir, "presence.json"),
+		timeout: timeout,
+		devices: make(map[string]*DevicePresence),
+	}
+
+	var devices []*DevicePresence
+	if err := loadJSONFile(m.path, &devices); err != nil {
+		return nil, fmt.Errorf("读取设备在线状态失败: %w", err)
+	}
+	for _, d := range devices {
+		m.devices[d.DevEUI] = d
+	}
+	return m, nil
+}
+
+// Seen 记录一次上行，设备此前被判定为离线时返回 true
+func (m *PresenceMonitor) Seen(devEUI string, at time.Time) (cameOnline bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.devices[devEUI]
+	if !ok {
+		m.devices[devEUI] = &DevicePresence{DevEUI: devEUI, LastSeen: at, Online: true, ChangedAt: at}
+		m.dirty = true
+		return false
+	}
+	if at.After(d.LastSeen) {
+		d.LastSeen = at
+	}
+	cameOnline = !d.Online
+	if cameOnline {
+		d.Online = true
+		d.ChangedAt = at
+	}
+	m.dirty = true
+	return cameOnline
+}
+
+// Get 返回设备的在线状态
+func (m *PresenceMonitor) Get(devEUI string) (DevicePresence, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.devices[devEUI]
+	if !ok {
+		return DevicePresence{}, false
+	}
+	return *d, true
+}
+
+// Run 周期性地检查超时未上行的设备并标记为离线，通过 onChange 通知，直到 ctx 结束
+func (m *PresenceMonitor) Run(ctx context.Context, interval time.Duration, onChange PresenceChangeFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.Flush()
+			return
+		case now := <-ticker.C:
+			for _, devEUI := range m.expire(now) {
+				onChange(devEUI, false, now)
+			}
+			m.Flush()
+		}
+	}
+}
+
+// expire 将超时的在线设备标记为离线，返回这些设备的 DevEUI
+func (m *PresenceMonitor) expire(now time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var offline []string
+	for _, d := range m.devices {
+		if d.Online && now.Sub(d.LastSeen) > m.timeout {
+			d.Online = false
+			d.ChangedAt = now
+			m.dirty = true
+			offline = append(offline, d.DevEUI)
+		}
+	}
+	sort.Strings(offline)
+	return offline
+}
+
+// Flush 将有变更的在线状态写入文件
+func (m *PresenceMonitor) Flush() {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return
+	}
+	devices := make([]DevicePresence, 0, len(m.devices))
+	for _, d := range m.devices {
+		devices = append(devices, *d)
+	}
+	m.dirty = false
+	m.mu.Unlock()
+
+	if err := saveJSONFile(m.path, devices); err != nil {
+		log.Error().Err(err).Msg("保存设备在线状态失败")
+		m.mu.Lock()
+		m.dirty = true
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPresenceMonitor(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const timeout = 30 * time.Minute
+
+	type step struct {
+		seen        string        // 该设备上行，为空表示检查超时
+		at          time.Duration // 相对 start 的时间
+		wantOnline  bool          // seen 不为空时：是否从离线恢复
+		wantOffline []string      // 检查超时的结果
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "首次上行不算恢复在线",
+			steps: []step{
+				{seen: "a", at: 0},
+				{at: timeout, wantOffline: nil},
+			},
+		},
+		{
+			name: "超时判定离线，只通知一次",
+			steps: []step{
+				{seen: "a", at: 0},
+				{seen: "b", at: 20 * time.Minute},
+				{at: timeout + time.Second, wantOffline: []string{"a"}},
+				{at: timeout + time.Minute, wantOffline: nil},
+				{at: 2 * timeout, wantOffline: []string{"b"}},
+			},
+		},
+		{
+			name: "离线后上行恢复在线",
+			steps: []step{
+				{seen: "a", at: 0},
+				{at: 2 * timeout, wantOffline: []string{"a"}},
+				{seen: "a", at: 2*timeout + time.Minute, wantOnline: true},
+				{seen: "a", at: 2*timeout + 2*time.Minute},
+				{at: 3 * timeout, wantOffline: nil},
+			},
+		},
+		{
+			name: "乱序到达的旧上行不回退最近上行时间",
+			steps: []step{
+				{seen: "a", at: 20 * time.Minute},
+				{seen: "a", at: 0},
+				{at: timeout + time.Minute, wantOffline: nil},
+				{at: timeout + 21*time.Minute, wantOffline: []string{"a"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewPresenceMonitor(t.TempDir(), timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.steps {
+				at := start.Add(s.at)
+				if s.seen != "" {
+					if got := m.Seen(s.seen, at); got != s.wantOnline {
+						t.Fatalf("第 %d 步 %s 上行: cameOnline=%v，期望 %v", i, s.seen, got, s.wantOnline)
+					}
+					continue
+				}
+				if got := m.expire(at); !reflect.DeepEqual(got, s.wantOffline) {
+					t.Fatalf("第 %d 步检查超时: %v，期望 %v", i, got, s.wantOffline)
+				}
+			}
+		})
+	}
+}
+
+func TestPresenceMonitorPersist(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewPresenceMonitor(dir, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Seen("a", time.Now().Add(-time.Second))
+
+	// Run 将超时设备判定为离线、通知并落盘
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, time.Millisecond, func(devEUI string, online bool, at time.Time) {
+			if !online {
+				changes <- devEUI
+			}
+		})
+		close(done)
+	}()
+	if got := <-changes; got != "a" {
+		t.Fatalf("离线通知 %s，期望 a", got)
+	}
+	cancel()
+	<-done
+
+	// 重启后沿用离线状态，再次上行时判定为恢复在线
+	reloaded, err := NewPresenceMonitor(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := reloaded.Get("a"); !ok || d.Online {
+		t.Fatalf("重启后状态 %+v，期望离线", d)
+	}
+	if !reloaded.Seen("a", time.Now()) {
+		t.Fatal("重启后上行应判定为恢复在线")
+	}
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrDeviceNotFound 表示桩号未登记
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrDeviceConflict 表示桩号或 DevEUI 已被其他记录占用
+	ErrDeviceConflict = errors.New("device already exists")
+)
+
+var devEUIPattern = regexp.MustCompile(`^[0-9a-fA-F]{16}$`)
+
+// IsDevEUI 判断 s 是否为 16 位十六进制的 DevEUI
+func IsDevEUI(s string) bool {
+	return devEUIPattern.MatchString(s)
+}
+
+// Device 描述一个道路桩号及其对应的设备
+type Device struct {
+	StakeNo         string   `json:"stakeNo"`
+	DevEUI          string   `json:"devEUI"`
+	Road            string   `json:"road,omitempty"`
+	Direction       string   `json:"direction,omitempty"`
+	KilometrePost   float64  `json:"kilometrePost,omitempty"` // 公里桩，单位 km
+	MulticastGroups []string `json:"multicastGroups,omitempty"`
+	InstallDate     string   `json:"installDate,omitempty"`     // 安装日期，格式 2006-01-02
+	ProtocolVersion int      `json:"protocolVersion,omitempty"` // 固件协议版本，0 表示未指定
+}
+
+// DeviceFilter 描述设备列表的筛选条件，零值字段不参与筛选
+type DeviceFilter struct {
+	Road      string
+	Direction string
+	Group     string
+	Keyword   string // 匹配桩号或 DevEUI 的子串
+	KmFrom    *float64
+	KmTo      *float64
+}
+
+// match 判断设备是否满足筛选条件
+func (f DeviceFilter) match(d *Device) bool {
+	if f.Road != "" && d.Road != f.Road {
+		return false
+	}
+	if f.Direction != "" && d.Direction != f.Direction {
+		return false
+	}
+	if f.Group != "" && !slices.Contains(d.MulticastGroups, f.Group) {
+		return false
+	}
+	if f.Keyword != "" {
+		kw := strings.ToLower(f.Keyword)
+		if !strings.Contains(strings.ToLower(d.StakeNo), kw) && !strings.Contains(d.DevEUI, kw) {
+			return false
+		}
+	}
+	if f.KmFrom != nil && d.KilometrePost < *f.KmFrom {
+		return false
+	}
+	if f.KmTo != nil && d.KilometrePost > *f.KmTo {
+		return false
+	}
+	return true
+}
+
+// DeviceRegistry 维护桩号与 DevEUI 的双向映射，数据保存在本地 JSON 文件中
+type DeviceRegistry struct {
+	mu      sync.RWMutex
+	path    string
+	byStake map[string]*Device
+	byEUI   map[string]*Device
+}
+
+// NewDeviceRegistry 从 dataDir 下的 devices.json 加载设备注册表，文件不存在时得到空注册表
+func NewDeviceRegistry(dataDir string) (*DeviceRegistry, error) {
+	r := &DeviceRegistry{
+		path:    filepath.Join(dataDir, "devices.json"),
+		byStake: make(map[string]*Device),
+		byEUI:   make(map[string]*Device),
+	}
+
+	var devices []Device
+	if err := loadJSONFile(r.path, &devices); err != nil {
+		return nil, fmt.Errorf("读取设备注册表失败: %w", err)
+	}
+	for i := range devices {
+		d := devices[i]
+		d.DevEUI = strings.ToLower(d.DevEUI)
+		if _, dup := r.byStake[d.StakeNo]; dup {
+			return nil, fmt.Errorf("设备注册表中桩号重复: %s", d.StakeNo)
+		}
+		if _, dup := r.byEUI[d.DevEUI]; dup {
+			return nil, fmt.Errorf("设备注册表中 DevEUI 重复: %s", d.DevEUI)
+		}
+		r.byStake[d.StakeNo] = &d
+		r.byEUI[d.DevEUI] = &d
+	}
+	return r, nil
+}
+
+// DevEUI 返回桩号对应的 DevEUI
+func (r *DeviceRegistry) DevEUI(stakeNo string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.byStake[stakeNo]
+	if !ok {
+		return "", false
+	}
+	return d.DevEUI, true
+}
+
+// StakeNo 返回 DevEUI 对应的桩号
+func (r *DeviceRegistry) StakeNo(devEUI string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.byEUI[strings.ToLower(devEUI)]
+	if !ok {
+		return "", false
+	}
+	return d.StakeNo, true
+}
+
+// Len 返回已登记的设备数量
+func (r *DeviceRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byStake)
+}
+
+// Get 返回桩号对应的设备记录
+func (r *DeviceRegistry) Get(stakeNo string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.byStake[stakeNo]
+	if !ok {
+		return Device{}, false
+	}
+	return cloneDevice(d), true
+}
+
+// List 返回满足筛选条件的设备，按桩号排序
+func (r *DeviceRegistry) List(filter DeviceFilter) []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	devices := make([]Device, 0, len(r.byStake))
+	for _, d := range r.byStake {
+		if filter.match(d) {
+			devices = append(devices, cloneDevice(d))
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].StakeNo < devices[j].StakeNo })
+	return devices
+}
+
+// Create 登记一个新设备，桩号或 DevEUI 已存在时返回 ErrDeviceConflict
+func (r *DeviceRegistry) Create(d Device) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d.DevEUI = strings.ToLower(d.DevEUI)
+	if _, ok := r.byStake[d.StakeNo]; ok {
+		return fmt.Errorf("%w: stakeNo %s", ErrDeviceConflict, d.StakeNo)
+	}
+	if other, ok := r.byEUI[d.DevEUI]; ok {
+		return fmt.Errorf("%w: devEUI %s is used by %s", ErrDeviceConflict, d.DevEUI, other.StakeNo)
+	}
+
+	r.byStake[d.StakeNo] = &d
+	r.byEUI[d.DevEUI] = &d
+	if err := r.save(); err != nil {
+		delete(r.byStake, d.StakeNo)
+		delete(r.byEUI, d.DevEUI)
+		return err
+	}
+	return nil
+}
+
+// Update 以 d 替换桩号 stakeNo 的记录，桩号本身不可修改
+func (r *DeviceRegistry) Update(stakeNo string, d Device) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.byStake[stakeNo]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, stakeNo)
+	}
+	d.StakeNo = stakeNo
+	d.DevEUI = strings.ToLower(d.DevEUI)
+	if other, ok := r.byEUI[d.DevEUI]; ok && other.StakeNo != stakeNo {
+		return fmt.Errorf("%w: devEUI %s is used by %s", ErrDeviceConflict, d.DevEUI, other.StakeNo)
+	}
+
+	delete(r.byEUI, old.DevEUI)
+	r.byStake[stakeNo] = &d
+	r.byEUI[d.DevEUI] = &d
+	if err := r.save(); err != nil {
+		delete(r.byEUI, d.DevEUI)
+		r.byStake[stakeNo] = old
+		r.byEUI[old.DevEUI] = old
+		return err
+	}
+	return nil
+}
+
+// Delete 删除桩号 stakeNo 的记录
+func (r *DeviceRegistry) Delete(stakeNo string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.byStake[stakeNo]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, stakeNo)
+	}
+
+	delete(r.byStake, stakeNo)
+	delete(r.byEUI, old.DevEUI)
+	if err := r.save(); err != nil {
+		r.byStake[stakeNo] = old
+		r.byEUI[old.DevEUI] = old
+		return err
+	}
+	return nil
+}
+
+// SetGroupMember 将桩号 stakeNo 加入或移出多播组 group，已处于目标状态时不写文件
+func (r *DeviceRegistry) SetGroupMember(stakeNo, group string, member bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.byStake[stakeNo]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, stakeNo)
+	}
+	if slices.Contains(d.MulticastGroups, group) == member {
+		return nil
+	}
+	old := d.MulticastGroups
+	if member {
+		d.MulticastGroups = append(slices.Clone(old), group)
+	} else {
+		d.MulticastGroups = slices.DeleteFunc(slices.Clone(old), func(g string) bool { return g == group })
+	}
+	if err := r.save(); err != nil {
+		d.MulticastGroups = old
+		return err
+	}
+	return nil
+}
+
+// RemoveGroup 将多播组 group 从所有设备记录中移除，返回受影响的设备数
+func (r *DeviceRegistry) RemoveGroup(group string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := make(map[*Device][]string)
+	for _, d := range r.byStake {
+		if slices.Contains(d.MulticastGroups, group) {
+			old[d] = d.MulticastGroups
+			d.MulticastGroups = slices.DeleteFunc(slices.Clone(d.MulticastGroups), func(g string) bool { return g == group })
+		}
+	}
+	if len(old) == 0 {
+		return 0, nil
+	}
+	if err := r.save(); err != nil {
+		for d, groups := range old {
+			d.MulticastGroups = groups
+		}
+		return 0, err
+	}
+	return len(old), nil
+}
+
+// Import 按桩号批量新增或覆盖记录，所有行都不与其余记录的 DevEUI 冲突时才整体写入；
+// dryRun 时只做冲突检查
+func (r *DeviceRegistry) Import(rows []InventoryRow, dryRun bool) (created, updated int, issues []ImportIssue, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byStake := make(map[string]*Device, len(r.byStake)+len(rows))
+	for k, d := range r.byStake {
+		byStake[k] = d
+	}
+	for _, row := range rows {
+		d := row.Device
+		if _, ok := byStake[d.StakeNo]; ok {
+			updated++
+		} else {
+			created++
+		}
+		byStake[d.StakeNo] = &d
+	}
+
+	// 本次被覆盖的桩号不再占用原 DevEUI，其余记录的 DevEUI 不允许被导入行复用
+	imported := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		imported[row.Device.StakeNo] = true
+	}
+	byEUI := make(map[string]*Device, len(byStake))
+	for eui, d := range r.byEUI {
+		if !imported[d.StakeNo] {
+			byEUI[eui] = d
+		}
+	}
+	for _, row := range rows {
+		d := byStake[row.Device.StakeNo]
+		if owner, ok := byEUI[d.DevEUI]; ok && owner.StakeNo != d.StakeNo {
+			issues = append(issues, ImportIssue{
+				Row:     row.Row,
+				StakeNo: d.StakeNo,
+				Message: fmt.Sprintf("devEUI %s is already registered to %s", d.DevEUI, owner.StakeNo),
+			})
+			continue
+		}
+		byEUI[d.DevEUI] = d
+	}
+	if len(issues) > 0 || dryRun {
+		return created, updated, issues, nil
+	}
+
+	oldStake, oldEUI := r.byStake, r.byEUI
+	r.byStake, r.byEUI = byStake, byEUI
+	if err := r.save(); err != nil {
+		r.byStake, r.byEUI = oldStake, oldEUI
+		return 0, 0, nil, err
+	}
+	return created, updated, nil, nil
+}
+
+// save 将注册表按桩号排序后写回文件，调用方需持有写锁
+func (r *DeviceRegistry) save() error {
+	devices := make([]Device, 0, len(r.byStake))
+	for _, d := range r.byStake {
+		devices = append(devices, *d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].StakeNo < devices[j].StakeNo })
+	if err := saveJSONFile(r.path, devices); err != nil {
+		return fmt.Errorf("保存设备注册表失败: %w", err)
+	}
+	return nil
+}
+
+// cloneDevice 复制设备记录，避免调用方修改注册表内部的切片
+func cloneDevice(d *Device) Device {
+	c := *d
+	c.MulticastGroups = slices.Clone(d.MulticastGroups)
+	return c
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestRegistry 在临时目录中创建注册表并登记 devices
+func newTestRegistry(t *testing.T, devices ...Device) *DeviceRegistry {
+	t.Helper()
+
+	r, err := NewDeviceRegistry(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range devices {
+		if err := r.Create(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRegistryImport(t *testing.T) {
+	existing := []Device{
+		{StakeNo: "K1", DevEUI: "0102030405060701"},
+		{StakeNo: "K2", DevEUI: "0102030405060702"},
+	}
+	tests := []struct {
+		name             string
+		rows             []Device
+		created, updated int
+		conflicts        int
+	}{
+		{
+			name:    "新增与覆盖",
+			rows:    []Device{{StakeNo: "K1", DevEUI: "0102030405060711"}, {StakeNo: "K3", DevEUI: "0102030405060703"}},
+			created: 1, updated: 1,
+		},
+		{
+			name:      "导入行复用未覆盖桩号的 DevEUI",
+			rows:      []Device{{StakeNo: "K3", DevEUI: "0102030405060702"}},
+			created:   1,
+			conflicts: 1,
+		},
+		{
+			name:    "被覆盖桩号让出的 DevEUI 可由其他行使用",
+			rows:    []Device{{StakeNo: "K1", DevEUI: "0102030405060711"}, {StakeNo: "K3", DevEUI: "0102030405060701"}},
+			created: 1, updated: 1,
+		},
+		{
+			name:      "两桩号互换 DevEUI 时只覆盖其一",
+			rows:      []Device{{StakeNo: "K1", DevEUI: "0102030405060702"}},
+			updated:   1,
+			conflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 冲突检查不能依赖 map 的遍历顺序，多次执行以暴露不确定的结果
+			for range 20 {
+				r := newTestRegistry(t, existing...)
+				rows := make([]InventoryRow, len(tt.rows))
+				for i, d := range tt.rows {
+					rows[i] = InventoryRow{Row: i + 2, Device: d}
+				}
+
+				created, updated, issues, err := r.Import(rows, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if created != tt.created || updated != tt.updated || len(issues) != tt.conflicts {
+					t.Fatalf("created=%d updated=%d issues=%v，期望 %d、%d、%d 个冲突", created, updated, issues, tt.created, tt.updated, tt.conflicts)
+				}
+				if tt.conflicts > 0 {
+					// 存在冲突时整体不写入
+					for _, d := range existing {
+						if got, _ := r.Get(d.StakeNo); got.DevEUI != d.DevEUI {
+							t.Fatalf("%s 的 DevEUI 被改为 %s", d.StakeNo, got.DevEUI)
+						}
+					}
+					if r.Len() != len(existing) {
+						t.Fatalf("注册表记录数 %d，期望 %d", r.Len(), len(existing))
+					}
+					continue
+				}
+				// 写入后的文件能够重新加载
+				if _, err := NewDeviceRegistry(filepath.Dir(r.path)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
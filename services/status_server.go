@@ -9,6 +9,18 @@ import (
 	"time"
 )
 
+// 心跳接口中 loraStatus 的取值
+const (
+	LoraOnline  = "Online"
+	LoraOffline = "Offline"
+)
+
+// 报警接口中 warnType 的取值，碰撞检测的取值由配置 collision.warn_type 决定
+const (
+	WarnManual   = 1 // 人工报警 (0x07)
+	WarnAccident = 2 // 事故报警 (0x08)
+)
+
 // StatusServerClient 封装了与状态服务器的交互
 type StatusServerClient struct {
 	client  *http.Client
@@ -25,8 +37,27 @@ func NewStatusServerClient(cfg config.Config) *StatusServerClient {
 	}
 }
 
-// SendWarnInfo 发送报警信息
+// StatusCodeError 表示状态服务器返回了非 200 状态码
+type StatusCodeError struct {
+	Code int
+}
+
+func (e *StatusCodeError) Error() string {
+	return fmt.Sprintf("服务器返回非 200 状态码: %d", e.Code)
+}
+
+// Permanent 判断该错误重试是否无意义：4xx（请求超时和限流除外）说明请求本身被拒绝
+func (e *StatusCodeError) Permanent() bool {
+	return e.Code >= 400 && e.Code < 500 && e.Code != http.StatusRequestTimeout && e.Code != http.StatusTooManyRequests
+}
+
+// SendWarnInfo 以当前时间作为事件时间发送报警信息
 func (c *StatusServerClient) SendWarnInfo(stakeNo string, warnType int) error {
+	return c.SendWarnInfoAt(stakeNo, warnType, time.Now())
+}
+
+// SendWarnInfoAt 发送报警信息，eventDate 为报警的原始发生时间
+func (c *StatusServerClient) SendWarnInfoAt(stakeNo string, warnType int, eventDate time.Time) error {
 	url := fmt.Sprintf("%s/warn/warnInfo", c.baseURL)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -35,7 +66,7 @@ func (c *StatusServerClient) SendWarnInfo(stakeNo string, warnType int) error {
 
 	q := req.URL.Query()
 	q.Add("stakeNo", stakeNo)
-	q.Add("eventDate", time.Now().Format("2006-01-02 15:04:05"))
+	q.Add("eventDate", eventDate.Format("2006-01-02 15:04:05"))
 	q.Add("warnType", fmt.Sprintf("%d", warnType))
 	req.URL.RawQuery = q.Encode()
 
@@ -46,18 +77,28 @@ func (c *StatusServerClient) SendWarnInfo(stakeNo string, warnType int) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("服务器返回非 200 状态码: %d", resp.StatusCode)
+		return &StatusCodeError{Code: resp.StatusCode}
 	}
 	return nil
 }
 
-// SendHeartbeat 发送心跳信息
+// SendHeartbeat 以当前时间发送心跳信息
 func (c *StatusServerClient) SendHeartbeat(stakeNo string) error {
+	return c.SendHeartbeatAt(stakeNo, time.Now())
+}
+
+// SendHeartbeatAt 发送心跳信息，updateDate 为心跳的原始接收时间
+func (c *StatusServerClient) SendHeartbeatAt(stakeNo string, updateDate time.Time) error {
+	return c.SendLoraStatusAt(stakeNo, LoraOnline, updateDate)
+}
+
+// SendLoraStatusAt 通过心跳接口上报设备的在线状态
+func (c *StatusServerClient) SendLoraStatusAt(stakeNo string, loraStatus string, updateDate time.Time) error {
 	url := fmt.Sprintf("%s/equipmentfailure/sendBeat", c.baseURL)
 	data := map[string]string{
 		"stakeNo":    stakeNo,
-		"updateDate": time.Now().Format("2006-01-02 15:04:05"),
-		"loraStatus": "Online",
+		"updateDate": updateDate.Format("2006-01-02 15:04:05"),
+		"loraStatus": loraStatus,
 	}
 
 	jsonData, err := json.Marshal(data)
@@ -72,7 +113,7 @@ func (c *StatusServerClient) SendHeartbeat(stakeNo string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("服务器返回非 200 状态码: %d", resp.StatusCode)
+		return &StatusCodeError{Code: resp.StatusCode}
 	}
 	return nil
 }
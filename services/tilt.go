@@ -0,0 +1,118 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+// TiltSample 是一次偏移上报 (0x04) 换算出的姿态，角度单位为度。
+// X/Y/Z 为灯体静止时的重力分量（g），Tilt 为灯体偏离竖直方向的角度，
+// Deviation 为相对安装基线的偏转角，基线尚未建立时为 0
+type TiltSample struct {
+	X         float64   `json:"x"`
+	Y         float64   `json:"y"`
+	Z         float64   `json:"z"`
+	Pitch     float64   `json:"pitch"`
+	Roll      float64   `json:"roll"`
+	Tilt      float64   `json:"tilt"`
+	Deviation float64   `json:"deviation"`
+	At        time.Time `json:"at"`
+}
+
+// NewTiltSample 根据重力分量计算俯仰角、横滚角与偏离竖直方向的角度
+func NewTiltSample(x, y, z float64, at time.Time) TiltSample {
+	s := TiltSample{X: x, Y: y, Z: z, At: at}
+	s.Pitch = degrees(math.Atan2(-x, math.Hypot(y, z)))
+	s.Roll = degrees(math.Atan2(y, z))
+	if g := distance(x, y, z); g > 0 {
+		s.Tilt = degrees(math.Acos(clamp(z / g)))
+	}
+	return s
+}
+
+// TiltBaseline 是安装后的基准姿态，由安装或重置基线后的前 tilt.baseline_samples 次上报平均得到
+type TiltBaseline struct {
+	X       float64   `json:"x"`
+	Y       float64   `json:"y"`
+	Z       float64   `json:"z"`
+	Samples int       `json:"samples"`
+	SetAt   time.Time `json:"setAt"` // 基线建立完成的时间
+}
+
+// Add 将一次上报计入尚未建立完成的基线
+func (b *TiltBaseline) Add(s TiltSample, required int) {
+	n := float64(b.Samples)
+	b.X = (b.X*n + s.X) / (n + 1)
+	b.Y = (b.Y*n + s.Y) / (n + 1)
+	b.Z = (b.Z*n + s.Z) / (n + 1)
+	b.Samples++
+	if b.Samples >= required {
+		b.SetAt = s.At
+	}
+}
+
+// Ready 判断基线是否已建立完成
+func (b TiltBaseline) Ready() bool {
+	return !b.SetAt.IsZero()
+}
+
+// DeviationOf 返回姿态相对基线的偏转角，即两次重力方向之间的夹角
+func (b TiltBaseline) DeviationOf(s TiltSample) float64 {
+	gb, gs := distance(b.X, b.Y, b.Z), distance(s.X, s.Y, s.Z)
+	if gb == 0 || gs == 0 {
+		return 0
+	}
+	return degrees(math.Acos(clamp((b.X*s.X + b.Y*s.Y + b.Z*s.Z) / (gb * gs))))
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// clamp 将浮点误差导致的越界余弦值限制在 [-1, 1]
+func clamp(v float64) float64 {
+	return math.Max(-1, math.Min(1, v))
+}
+
+// TiltStore 以 DevEUI 分桶保存姿态历史，数据位于 dataDir 下的 tilt.db，值为 JSON 编码的 TiltSample
+type TiltStore struct {
+	*timeSeries
+}
+
+// NewTiltStore 打开姿态时间序列库，retention 为保留时长，0 表示永久保留
+func NewTiltStore(dataDir string, retention time.Duration) (*TiltStore, error) {
+	ts, err := openTimeSeries(filepath.Join(dataDir, "tilt.db"), "姿态", retention)
+	if err != nil {
+		return nil, err
+	}
+	return &TiltStore{timeSeries: ts}, nil
+}
+
+// Append 写入一次姿态
+func (s *TiltStore) Append(devEUI string, sample TiltSample) error {
+	v, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	return s.put(devEUI, sample.At, v)
+}
+
+// Samples 返回 [from, to) 内的姿态，数量超过 limit 时返回 ErrTooManySamples
+func (s *TiltStore) Samples(devEUI string, from, to time.Time, limit int) ([]TiltSample, error) {
+	samples := make([]TiltSample, 0)
+	err := s.each(devEUI, from, to, func(at time.Time, v []byte) error {
+		if limit > 0 && len(samples) >= limit {
+			return fmt.Errorf("%w: more than %d", ErrTooManySamples, limit)
+		}
+		var sample TiltSample
+		if err := json.Unmarshal(v, &sample); err != nil {
+			return err
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	return samples, err
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewTiltSample(t *testing.T) {
+	tests := []struct {
+		name                string
+		x, y, z             float64
+		wantPitch, wantRoll float64
+		wantTilt            float64
+	}{
+		{"竖直", 0, 0, 1, 0, 0, 0},
+		{"向 y 轴倾斜 45°", 0, math.Sqrt2 / 2, math.Sqrt2 / 2, 0, 45, 45},
+		{"向 x 轴倾倒", 1, 0, 0, -90, 0, 90},
+		{"倒置", 0, 0, -1, 0, 180, 180},
+		{"无重力分量", 0, 0, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTiltSample(tt.x, tt.y, tt.z, time.Now())
+			if math.Abs(s.Pitch-tt.wantPitch) > 1e-6 || math.Abs(s.Roll-tt.wantRoll) > 1e-6 || math.Abs(s.Tilt-tt.wantTilt) > 1e-6 {
+				t.Fatalf("pitch=%v roll=%v tilt=%v，期望 %v、%v、%v", s.Pitch, s.Roll, s.Tilt, tt.wantPitch, tt.wantRoll, tt.wantTilt)
+			}
+		})
+	}
+}
+
+func TestTiltBaseline(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 前 required 次上报取平均，达到次数后基线建立完成
+	var b TiltBaseline
+	for i, x := range []float64{-0.1, 0.1, 0.3} {
+		if b.Ready() {
+			t.Fatalf("第 %d 次上报前基线已建立", i)
+		}
+		b.Add(NewTiltSample(x, 0, 1, start.Add(time.Duration(i)*time.Minute)), 3)
+	}
+	if !b.Ready() || !b.SetAt.Equal(start.Add(2*time.Minute)) {
+		t.Fatalf("基线 %+v，期望在第 3 次上报时建立完成", b)
+	}
+	if math.Abs(b.X-0.1) > 1e-9 || b.Y != 0 || b.Z != 1 || b.Samples != 3 {
+		t.Fatalf("基线 %+v，期望 x=0.1 y=0 z=1", b)
+	}
+}
+
+func TestTiltBaselineDeviation(t *testing.T) {
+	// 安装时向 y 轴倾斜 45°，偏转角相对安装姿态计算
+	b := TiltBaseline{Y: math.Sqrt2 / 2, Z: math.Sqrt2 / 2, Samples: 1, SetAt: time.Now()}
+	tests := []struct {
+		name    string
+		x, y, z float64
+		want    float64
+	}{
+		{"与基线一致", 0, math.Sqrt2 / 2, math.Sqrt2 / 2, 0},
+		{"模长不同、方向一致", 0, 0.5, 0.5, 0},
+		{"回到竖直", 0, 0, 1, 45},
+		{"继续倾倒至水平", 0, 1, 0, 45},
+		{"向另一侧倾倒", 0, -1, 0, 135},
+		{"无重力分量", 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.DeviationOf(NewTiltSample(tt.x, tt.y, tt.z, time.Now())); math.Abs(got-tt.want) > 1e-6 {
+				t.Fatalf("偏转角 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// timeSeries 是基于 bbolt 的时间序列，每个 DevEUI 一个桶，
+// 键为采样时间的 UnixNano（大端序，保证按时间排序），同一时间的后续采样在键后追加序号，
+// 值的编码由使用方决定
+type timeSeries struct {
+	db        *bolt.DB
+	name      string // 仅用于日志
+	retention time.Duration
+}
+
+func openTimeSeries(path, name string, retention time.Duration) (*timeSeries, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开%s时间序列库失败: %w", name, err)
+	}
+	return &timeSeries{db: db, name: name, retention: retention}, nil
+}
+
+// Close 关闭时间序列库
+func (t *timeSeries) Close() error {
+	return t.db.Close()
+}
+
+func timeKey(at time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(at.UnixNano()))
+}
+
+// keyTime 只取键的前 8 字节，忽略同一时间采样的序号
+func keyTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+}
+
+// put 写入一次采样，与已有采样时间相同时不覆盖，而是追加序号另存一条。
+// 带序号的键排在同一时间的原键之后、下一纳秒之前，范围遍历与清理不受影响
+func (t *timeSeries) put(devEUI string, at time.Time, value []byte) error {
+	return t.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(devEUI))
+		if err != nil {
+			return err
+		}
+		k := timeKey(at)
+		for seq := uint32(1); b.Get(k) != nil; seq++ {
+			k = binary.BigEndian.AppendUint32(timeKey(at), seq)
+		}
+		return b.Put(k, value)
+	})
+}
+
+// each 按时间顺序遍历 [from, to) 内的采样
+func (t *timeSeries) each(devEUI string, from, to time.Time, fn func(at time.Time, value []byte) error) error {
+	return t.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(devEUI))
+		if b == nil {
+			return nil
+		}
+		end := timeKey(to)
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && string(k) < string(end); k, v = c.Next() {
+			if err := fn(keyTime(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Count 返回 [from, to) 内的采样数
+func (t *timeSeries) Count(devEUI string, from, to time.Time) (int, error) {
+	n := 0
+	err := t.each(devEUI, from, to, func(time.Time, []byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Prune 删除早于 before 的采样，删空的设备桶一并删除，返回删除的采样数
+func (t *timeSeries) Prune(before time.Time) (int, error) {
+	removed := 0
+	err := t.db.Update(func(tx *bolt.Tx) error {
+		var empty [][]byte
+		end := timeKey(before)
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(end); k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				removed++
+			}
+			if k, _ := c.First(); k == nil {
+				empty = append(empty, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range empty {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Run 周期性地清理超过保留时长的采样，直到 ctx 结束
+func (t *timeSeries) Run(ctx context.Context, interval time.Duration) {
+	if t.retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := t.Prune(now.Add(-t.retention))
+			if err != nil {
+				log.Error().Err(err).Str("series", t.name).Msg("清理过期采样失败")
+			} else if removed > 0 {
+				log.Info().Str("series", t.name).Int("removed", removed).Msg("已清理过期采样")
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	"chirpstack-httpserver/protocol"
+)
+
+// 时间同步参考时间的来源，按精度从高到低排列
+const (
+	SyncSourceGPS     = "gps"     // 网关 GPS 时间
+	SyncSourceGateway = "gateway" // 网关接收时间
+	SyncSourceNetwork = "network" // 网络服务器接收时间
+	SyncSourceServer  = "server"  // 本服务处理上行的时间，缺少元数据时使用
+)
+
+// LoRaAirtime 按 Semtech SX127x 数据手册计算 LoRa 帧的空中时间：显式头部，8 个前导码符号，
+// 符号时长超过 16ms 时启用低速率优化。payloadLen 为 PHYPayload 字节数，codeRate 取 1-4 对应 4/5-4/8
+func LoRaAirtime(payloadLen, spreadingFactor, bandwidth, codeRate int, crc bool) time.Duration {
+	if spreadingFactor <= 0 || bandwidth <= 0 {
+		return 0
+	}
+	if codeRate < 1 || codeRate > 4 {
+		codeRate = 1
+	}
+	tSym := float64(int(1)<<spreadingFactor) / float64(bandwidth)
+	de := 0
+	if tSym > 0.016 {
+		de = 1
+	}
+	crcBits := 0
+	if crc {
+		crcBits = 16
+	}
+
+	symbols := 8 + 4.25 + 8
+	if num := 8*payloadLen - 4*spreadingFactor + 28 + crcBits; num > 0 {
+		symbols += math.Ceil(float64(num)/float64(4*(spreadingFactor-2*de))) * float64(codeRate+4)
+	}
+	return time.Duration(math.Round(symbols * tSym * float64(time.Second)))
+}
+
+// Class B 的信标周期、信标保留时长与 ping 时隙长度
+const (
+	beaconPeriod   = 128 * time.Second
+	beaconReserved = 2120 * time.Millisecond
+	pingSlotLen    = 30 * time.Millisecond
+)
+
+// NextPingSlot 返回 DevAddr（多播组为 McAddr）在 after 之后的第一个 Class B ping 时隙。
+// periodicity 取 0-7，每个信标周期有 2^(7-periodicity) 个时隙；时隙偏移按 LoRaWAN Class B 规范，
+// 以信标时间与 DevAddr 的 AES 加密结果计算
+func NextPingSlot(after time.Time, devAddr [4]byte, periodicity int) time.Time {
+	pingNb := 1 << (7 - min(max(periodicity, 0), 7))
+	pingPeriod := 4096 / pingNb
+	gps := protocol.ToGPSTime(after)
+	for beacon := gps - gps%beaconPeriod; ; beacon += beaconPeriod {
+		offset := pingOffset(beacon, devAddr, pingPeriod)
+		for n := 0; n < pingNb; n++ {
+			if slot := beacon + beaconReserved + time.Duration(offset+n*pingPeriod)*pingSlotLen; slot > gps {
+				return protocol.FromGPSTime(slot)
+			}
+		}
+	}
+}
+
+// pingOffset 计算信标周期内的 ping 时隙偏移，信标时间与 DevAddr 均以小端序参与加密
+func pingOffset(beacon time.Duration, devAddr [4]byte, pingPeriod int) int {
+	var b [16]byte
+	binary.LittleEndian.PutUint32(b[0:], uint32(beacon/time.Second))
+	b[4], b[5], b[6], b[7] = devAddr[3], devAddr[2], devAddr[1], devAddr[0]
+	block, _ := aes.NewCipher(make([]byte, 16))
+	block.Encrypt(b[:], b[:])
+	return (int(b[0]) + int(b[1])*256) % pingPeriod
+}
+
+// TimeSyncSample 记录一次时间同步的时延补偿与测得的时钟漂移，时长单位均为毫秒
+type TimeSyncSample struct {
+	Source            string    `json:"source"`
+	At                time.Time `json:"at"` // 网关收到上行的时刻
+	UplinkAirtimeMs   float64   `json:"uplinkAirtimeMs"`
+	DownlinkAirtimeMs float64   `json:"downlinkAirtimeMs"`
+	ProcessingDelayMs float64   `json:"processingDelayMs"` // 自网关收到上行至本服务处理的时延
+	Target            time.Time `json:"target"`            // 下行负载对应的时刻，即预计设备收完下行的时刻
+	// 设备时钟减参考时钟，正值表示设备时钟偏快；请求未附带设备时钟时为 nil
+	DriftMs *int64 `json:"driftMs,omitempty"`
+}
+
+// Milliseconds 将时长换算为毫秒
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// TimeSyncStore 以 DevEUI 分桶保存时间同步历史，数据位于 dataDir 下的 time_sync.db，值为 JSON 编码的 TimeSyncSample
+type TimeSyncStore struct {
+	*timeSeries
+}
+
+// NewTimeSyncStore 打开时间同步时间序列库，retention 为保留时长，0 表示永久保留
+func NewTimeSyncStore(dataDir string, retention time.Duration) (*TimeSyncStore, error) {
+	ts, err := openTimeSeries(filepath.Join(dataDir, "time_sync.db"), "时间同步", retention)
+	if err != nil {
+		return nil, err
+	}
+	return &TimeSyncStore{timeSeries: ts}, nil
+}
+
+// Append 写入一次时间同步记录
+func (s *TimeSyncStore) Append(devEUI string, sample TimeSyncSample) error {
+	v, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	return s.put(devEUI, sample.At, v)
+}
+
+// Samples 返回 [from, to) 内的时间同步记录，数量超过 limit 时返回 ErrTooManySamples
+func (s *TimeSyncStore) Samples(devEUI string, from, to time.Time, limit int) ([]TimeSyncSample, error) {
+	samples := make([]TimeSyncSample, 0)
+	err := s.each(devEUI, from, to, func(at time.Time, v []byte) error {
+		if limit > 0 && len(samples) >= limit {
+			return fmt.Errorf("%w: more than %d", ErrTooManySamples, limit)
+		}
+		var sample TimeSyncSample
+		if err := json.Unmarshal(v, &sample); err != nil {
+			return err
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	return samples, err
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/protocol"
+)
+
+func TestLoRaAirtime(t *testing.T) {
+	tests := []struct {
+		payloadLen, sf, bw, cr int
+		crc                    bool
+		want                   time.Duration
+	}{
+		{14, 7, 125000, 1, true, 46336 * time.Microsecond},
+		{17, 12, 125000, 1, false, 1155072 * time.Microsecond},
+		{0, 0, 125000, 1, true, 0},
+	}
+	for _, tt := range tests {
+		if got := LoRaAirtime(tt.payloadLen, tt.sf, tt.bw, tt.cr, tt.crc); got != tt.want {
+			t.Errorf("LoRaAirtime(%d, SF%d) = %v，期望 %v", tt.payloadLen, tt.sf, got, tt.want)
+		}
+	}
+}
+
+func TestNextPingSlot(t *testing.T) {
+	addr := [4]byte{0x01, 0x02, 0x03, 0x04}
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for periodicity := 0; periodicity <= 7; periodicity++ {
+		slot := NextPingSlot(after, addr, periodicity)
+		if !slot.After(after) || slot.Sub(after) > 2*beaconPeriod {
+			t.Fatalf("periodicity %d: 时隙 %v 不在 after 之后的两个信标周期内", periodicity, slot)
+		}
+		// 时隙距信标开始的时长应为信标保留时长加整数个时隙长度
+		gps := protocol.ToGPSTime(slot)
+		if rem := (gps%beaconPeriod - beaconReserved) % pingSlotLen; rem != 0 {
+			t.Errorf("periodicity %d: 时隙 %v 未对齐", periodicity, slot)
+		}
+		if next := NextPingSlot(slot, addr, periodicity); next.Sub(slot) < time.Duration(4096>>(7-periodicity))*pingSlotLen {
+			t.Errorf("periodicity %d: 相邻时隙间隔 %v 过短", periodicity, next.Sub(slot))
+		}
+	}
+}
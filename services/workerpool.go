@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 队列满时的处理方式
+const (
+	// OverflowDropOldest 丢弃同一个键在队列中最早的任务，为新任务腾出位置；
+	// 该键没有排队中的任务时拒绝新任务，不会丢弃其他键的任务
+	OverflowDropOldest = "drop_oldest"
+	// OverflowReject 拒绝新任务
+	OverflowReject = "reject"
+)
+
+var (
+	// ErrQueueFull 表示队列已满且无法为新任务腾出位置
+	ErrQueueFull = errors.New("queue is full")
+	// ErrPoolStopped 表示工作池已停止
+	ErrPoolStopped = errors.New("worker pool is stopped")
+)
+
+// queuedJob 是排队中的任务及其所属的键
+type queuedJob struct {
+	key string
+	run func()
+}
+
+// workerQueue 是单个工作协程的有界任务队列
+type workerQueue struct {
+	mu     sync.Mutex
+	ready  *sync.Cond // 有新任务或队列关闭时通知工作协程
+	jobs   []queuedJob
+	size   int
+	closed bool
+}
+
+// WorkerPool 是按键分片的有界工作池：同一个键（如 DevEUI）的任务总是由同一个工作协程
+// 按提交顺序执行，不同键的任务并行执行
+type WorkerPool struct {
+	queues   []*workerQueue
+	overflow string
+	wg       sync.WaitGroup
+}
+
+// NewWorkerPool 创建并启动工作池。queueSize 为所有工作协程队列长度之和，
+// overflow 为 OverflowDropOldest 或 OverflowReject
+func NewWorkerPool(workers, queueSize int, overflow string) (*WorkerPool, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("工作协程数必须大于 0: %d", workers)
+	}
+	if overflow != OverflowDropOldest && overflow != OverflowReject {
+		return nil, fmt.Errorf("不支持的队列溢出处理方式: %s", overflow)
+	}
+
+	perWorker := (queueSize + workers - 1) / workers
+	if perWorker < 1 {
+		perWorker = 1
+	}
+
+	p := &WorkerPool{overflow: overflow}
+	for i := 0; i < workers; i++ {
+		q := &workerQueue{size: perWorker}
+		q.ready = sync.NewCond(&q.mu)
+		p.queues = append(p.queues, q)
+		p.wg.Add(1)
+		go p.work(q)
+	}
+	return p, nil
+}
+
+// Submit 将任务提交到 key 对应的队列。dropped 为 true 表示为此丢弃了同一个键排队中最早的任务；
+// 队列满且无法腾出位置时返回 ErrQueueFull
+func (p *WorkerPool) Submit(key string, job func()) (dropped bool, err error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	q := p.queues[h.Sum32()%uint32(len(p.queues))]
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, ErrPoolStopped
+	}
+	if len(q.jobs) >= q.size {
+		if p.overflow == OverflowReject {
+			return false, ErrQueueFull
+		}
+		// 只丢弃同一个键的任务，其他设备排队中的报警、入网等任务不受影响
+		i := slices.IndexFunc(q.jobs, func(j queuedJob) bool { return j.key == key })
+		if i < 0 {
+			return false, ErrQueueFull
+		}
+		q.jobs = slices.Delete(q.jobs, i, i+1)
+		dropped = true
+	}
+	q.jobs = append(q.jobs, queuedJob{key: key, run: job})
+	q.ready.Signal()
+	return dropped, nil
+}
+
+// Len 返回所有队列中等待执行的任务数
+func (p *WorkerPool) Len() int {
+	n := 0
+	for _, q := range p.queues {
+		q.mu.Lock()
+		n += len(q.jobs)
+		q.mu.Unlock()
+	}
+	return n
+}
+
+// Stop 停止接收新任务，并等待已排队的任务执行完毕
+func (p *WorkerPool) Stop() {
+	for _, q := range p.queues {
+		q.mu.Lock()
+		q.closed = true
+		q.ready.Signal()
+		q.mu.Unlock()
+	}
+	p.wg.Wait()
+}
+
+// work 依次执行队列中的任务，单个任务 panic 不会影响后续任务
+func (p *WorkerPool) work(q *workerQueue) {
+	defer p.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.jobs) == 0 && !q.closed {
+			q.ready.Wait()
+		}
+		if len(q.jobs) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		job := q.jobs[0].run
+		q.jobs[0] = queuedJob{}
+		q.jobs = q.jobs[1:]
+		q.mu.Unlock()
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().Interface("panic", r).Msg("工作池任务异常")
+				}
+			}()
+			job()
+		}()
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestNewWorkerPoolInvalid(t *testing.T) {
+	if _, err := NewWorkerPool(0, 10, OverflowReject); err == nil {
+		t.Error("工作协程数为 0 时应返回错误")
+	}
+	if _, err := NewWorkerPool(1, 10, "block"); err == nil {
+		t.Error("未知的溢出处理方式应返回错误")
+	}
+}
+
+func TestWorkerPoolOrderPerKey(t *testing.T) {
+	p, err := NewWorkerPool(4, 1000, OverflowReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	for i := range 100 {
+		for _, key := range keys {
+			if _, err := p.Submit(key, func() {
+				mu.Lock()
+				got[key] = append(got[key], i)
+				mu.Unlock()
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	p.Stop()
+
+	for _, key := range keys {
+		if len(got[key]) != 100 {
+			t.Fatalf("键 %s 执行了 %d 个任务，期望 100", key, len(got[key]))
+		}
+		for i, v := range got[key] {
+			if v != i {
+				t.Fatalf("键 %s 的第 %d 个任务是 %d，未按提交顺序执行", key, i, v)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolOverflow(t *testing.T) {
+	tests := []struct {
+		overflow    string
+		wantDropped bool
+		wantErr     error
+		wantRun     []int
+	}{
+		{OverflowDropOldest, true, nil, []int{0, 2, 3}},
+		{OverflowReject, false, ErrQueueFull, []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.overflow, func(t *testing.T) {
+			p, err := NewWorkerPool(1, 2, tt.overflow)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			var run []int
+			job := func(i int) func() {
+				return func() {
+					mu.Lock()
+					run = append(run, i)
+					mu.Unlock()
+				}
+			}
+
+			// 第一个任务阻塞工作协程，随后两个任务占满队列
+			started, release := make(chan struct{}), make(chan struct{})
+			if _, err := p.Submit("k", func() {
+				close(started)
+				<-release
+				job(0)()
+			}); err != nil {
+				t.Fatal(err)
+			}
+			<-started
+			for i := 1; i <= 2; i++ {
+				if dropped, err := p.Submit("k", job(i)); dropped || err != nil {
+					t.Fatalf("任务 %d: dropped=%v err=%v", i, dropped, err)
+				}
+			}
+
+			dropped, err := p.Submit("k", job(3))
+			if dropped != tt.wantDropped || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("队列满时 dropped=%v err=%v，期望 %v、%v", dropped, err, tt.wantDropped, tt.wantErr)
+			}
+			if p.Len() != 2 {
+				t.Fatalf("排队任务数 %d，期望 2", p.Len())
+			}
+
+			close(release)
+			p.Stop()
+			if !reflect.DeepEqual(run, tt.wantRun) {
+				t.Fatalf("执行的任务 %v，期望 %v", run, tt.wantRun)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolPanicAndStop(t *testing.T) {
+	p, err := NewWorkerPool(1, 10, OverflowReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ran bool
+	if _, err := p.Submit("k", func() { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Submit("k", func() { ran = true }); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+	if !ran {
+		t.Fatal("任务 panic 后后续任务未执行")
+	}
+
+	if _, err := p.Submit("k", func() {}); !errors.Is(err, ErrPoolStopped) {
+		t.Fatalf("停止后提交: %v，期望 %v", err, ErrPoolStopped)
+	}
+}
+
+func TestWorkerPoolDropOldestPerKey(t *testing.T) {
+	p, err := NewWorkerPool(1, 3, OverflowDropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var run []string
+	job := func(name string) func() {
+		return func() {
+			mu.Lock()
+			run = append(run, name)
+			mu.Unlock()
+		}
+	}
+
+	// 阻塞工作协程，随后 a 的报警与 b 的两个任务占满队列
+	started, release := make(chan struct{}), make(chan struct{})
+	if _, err := p.Submit("a", func() { close(started); <-release }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	for _, s := range []struct{ key, name string }{{"a", "a-alarm"}, {"b", "b-1"}, {"b", "b-2"}} {
+		if _, err := p.Submit(s.key, job(s.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b 只丢弃自己最早的任务
+	if dropped, err := p.Submit("b", job("b-3")); !dropped || err != nil {
+		t.Fatalf("b 提交: dropped=%v err=%v，期望丢弃 b 自己的任务", dropped, err)
+	}
+	// c 没有排队中的任务可丢弃，不能挤掉其他设备的任务
+	if dropped, err := p.Submit("c", job("c-1")); dropped || !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("c 提交: dropped=%v err=%v，期望 %v", dropped, err, ErrQueueFull)
+	}
+
+	close(release)
+	p.Stop()
+	if want := []string{"a-alarm", "b-2", "b-3"}; !reflect.DeepEqual(run, want) {
+		t.Fatalf("执行的任务 %v，期望 %v", run, want)
+	}
+}
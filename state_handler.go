@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetDeviceState 查询设备最近一次成功下发的配置与最新遥测
+func (h *Handler) handleGetDeviceState(c *gin.Context) {
+	stakeNo := c.Param("stakeNo")
+	devEUI, err := h.resolveDevEUI(stakeNo)
+	if err != nil {
+		respondError(c, err, "Failed to resolve stakeNo.")
+		return
+	}
+
+	state, found := h.state.Get(devEUI)
+	if !found {
+		state = services.DeviceState{DevEUI: devEUI}
+	}
+	data := gin.H{"stakeNo": h.stakeNoOf(devEUI), "state": state}
+	if presence, ok := h.presence.Get(devEUI); ok {
+		data["presence"] = presence
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": data})
+}
+
+// enqueueMulticast 发送多播下行，成功入队后记录多播组的期望配置并更新组内已登记设备的配置状态。
+// 多播没有确认机制
+func (h *Handler) enqueueMulticast(groupName, multicastGroupID string, msg protocol.Message) (uint32, error) {
+	fPort, data, err := h.encodeForGroup(groupName, msg)
+	if err != nil {
+		return 0, err
+	}
+	fCnt, err := h.csClient.EnqueueMulticast(multicastGroupID, fPort, data)
+	if err != nil {
+		return 0, err
+	}
+	h.recordGroupDesired(groupName, msg)
+
+	// 多播没有逐台设备的确认，入队即暂记为组内设备均已送达，对账不会为此向每台设备单播补发；
+	// 未收到的设备在下一次自行上报配置（0x0A）后才会显示为不一致
+	for _, d := range h.registry.List(services.DeviceFilter{Group: groupName}) {
+		h.state.UpdateConfig(d.DevEUI, func(c *services.LightConfig) {
+			applyDownlinkConfig(c, msg)
+		})
+	}
+	return fCnt, nil
+}
+
+// recordDelivered 在单播下行送达后更新设备的配置状态
+func (h *Handler) recordDelivered(rec services.DownlinkRecord) {
+	if !rec.Delivered() {
+		return
+	}
+	msg, ok := h.decodeDownlinkRecord(rec)
+	if !ok {
+		return
+	}
+	h.state.UpdateConfig(rec.DevEUI, func(c *services.LightConfig) {
+		applyDownlinkConfig(c, msg)
+	})
+}
+
+// decodeDownlinkRecord 按设备协议版本解码已跟踪的下行负载
+func (h *Handler) decodeDownlinkRecord(rec services.DownlinkRecord) (protocol.Message, bool) {
+	msg, err := h.codecFor(rec.DevEUI).DecodeDownlink(rec.FPort, rec.Data)
+	return msg, err == nil
+}
+
+// applyDownlinkConfig 将下行命令中的配置项合并到 c
+func applyDownlinkConfig(c *services.LightConfig, msg protocol.Message) {
+	value := func(v int) *int { return &v }
+
+	switch m := msg.(type) {
+	case protocol.Frequency:
+		c.Frequency = value(m.PerMinute)
+	case protocol.Color:
+		c.Color = value(int(m.Color))
+	case protocol.Manner:
+		c.Manner = value(int(m.Manner))
+	case protocol.Level:
+		c.Level = value(int(m.Level))
+	case protocol.Switch:
+		c.Switch = value(int(m.Switch))
+	case protocol.Overall:
+		c.Color, c.Frequency, c.Level = value(int(m.Color)), value(m.Frequency), value(int(m.Level))
+		c.Manner, c.RadarEnable = value(int(m.Manner)), value(int(m.RadarEnable))
+	case protocol.MulticastKeys:
+		c.MulticastDevAddr = hex.EncodeToString(m.DevAddr[:])
+	case protocol.AccelerationMode:
+		c.AccelerationMode = value(int(m.Enable))
+	case protocol.Character:
+		c.Character = value(int(m.Switch))
+	case protocol.Brightness:
+		c.Brightness = value(int(m.Brightness))
+	}
+}
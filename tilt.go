@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"chirpstack-httpserver/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// detectTilt 以安装基线计算姿态偏转角并写入姿态历史。基线建立完成后，偏转角首次超过阈值时
+// 以配置的报警类型经发件箱上报灯桩被撞倒或倾斜，回落到阈值以内后解除，再次超过时重新上报
+func (h *Handler) detectTilt(devEUI string, sample services.TiltSample) error {
+	cfg := h.config.Tilt
+	var baseline services.TiltBaseline
+	report, recovered := false, false
+
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		if st.TiltBaseline != nil {
+			baseline = *st.TiltBaseline
+		}
+		if !baseline.Ready() {
+			baseline.Add(sample, cfg.BaselineSamples)
+		}
+		st.TiltBaseline = &baseline
+
+		if baseline.Ready() {
+			sample.Deviation = baseline.DeviationOf(sample)
+			if cfg.Enabled {
+				tilted := sample.Deviation > cfg.AngleThreshold
+				report, recovered = tilted && !st.Tilted, !tilted && st.Tilted
+				st.Tilted = tilted
+				if report {
+					st.LastAlarm = &services.AlarmSample{WarnType: cfg.WarnType, At: sample.At}
+				}
+			}
+		}
+		st.LastTilt = &sample
+	})
+	if err := h.tilt.Append(devEUI, sample); err != nil {
+		log.Error().Err(err).Str("devEUI", devEUI).Msg("保存姿态失败")
+	}
+
+	switch {
+	case recovered:
+		log.Info().Str("devEUI", devEUI).Float64("deviation", sample.Deviation).Msg("灯桩姿态已恢复到阈值以内")
+	case report:
+		log.Warn().Str("devEUI", devEUI).Float64("deviation", sample.Deviation).Float64("threshold", cfg.AngleThreshold).Msg("灯桩被撞倒或倾斜")
+		queued, err := h.outbox.EnqueueWarnInfo(h.stakeNoOf(devEUI), cfg.WarnType, sample.At)
+		if err != nil {
+			return fmt.Errorf("倾斜报警写入发件箱失败: %w", err)
+		}
+		log.Info().Str("devEUI", devEUI).Str("outboxID", queued.ID).Int("warnType", cfg.WarnType).Msg("倾斜报警已写入发件箱")
+	}
+	return nil
+}
+
+// handleGetTilt 查询设备的安装基线及时间范围内的姿态历史，数量超过 tilt.max_points 时需缩小范围
+func (h *Handler) handleGetTilt(c *gin.Context) {
+	r, ok := h.bindAccelerationQuery(c)
+	if !ok {
+		return
+	}
+	if r.interval != 0 {
+		respondValidationError(c, "interval is not supported for tilt history.")
+		return
+	}
+
+	samples, err := h.tilt.Samples(r.devEUI, r.from, r.to, h.config.Tilt.MaxPoints)
+	if errors.Is(err, services.ErrTooManySamples) {
+		respondValidationError(c, fmt.Sprintf("More than %d samples in range, narrow from/to.", h.config.Tilt.MaxPoints))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("devEUI", r.devEUI).Msg("查询姿态历史失败")
+		respondError(c, err, "Failed to query tilt samples.")
+		return
+	}
+
+	state, _ := h.state.Get(r.devEUI)
+	data := gin.H{
+		"stakeNo":  h.stakeNoOf(r.devEUI),
+		"devEUI":   r.devEUI,
+		"from":     r.from,
+		"to":       r.to,
+		"baseline": state.TiltBaseline,
+		"tilted":   state.Tilted,
+		"samples":  samples,
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": data})
+}
+
+// handleResetTiltBaseline 清除设备的安装基线，用于重新安装或扶正灯桩后以此后的偏移上报重新建立基线
+func (h *Handler) handleResetTiltBaseline(c *gin.Context) {
+	stakeNo := c.Param("stakeNo")
+	devEUI, err := h.resolveDevEUI(stakeNo)
+	if err != nil {
+		respondError(c, err, "Failed to resolve stakeNo.")
+		return
+	}
+
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.TiltBaseline = nil
+		st.Tilted = false
+	})
+	log.Info().Str("devEUI", devEUI).Msg("已重置姿态基线")
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "Tilt baseline reset; it will be rebuilt from the next skew reports."})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/services"
+)
+
+func TestDetectTilt(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// angle 为灯体在 xz 平面内偏离竖直方向的角度
+	type report struct {
+		angle      float64
+		wantTilted bool
+	}
+	tests := []struct {
+		name      string
+		enabled   bool
+		reports   []report
+		wantAlarm int // 写入发件箱的报警数
+	}{
+		{
+			name:    "基线建立期间不判定",
+			enabled: true,
+			reports: []report{{60, false}},
+		},
+		{
+			name:    "未超过阈值",
+			enabled: true,
+			reports: []report{{0, false}, {0, false}, {10, false}, {14, false}},
+		},
+		{
+			name:      "超过阈值上报一次",
+			enabled:   true,
+			reports:   []report{{0, false}, {0, false}, {20, true}, {40, true}, {90, true}},
+			wantAlarm: 1,
+		},
+		{
+			name:      "回落后再次超过阈值时重新上报",
+			enabled:   true,
+			reports:   []report{{0, false}, {0, false}, {20, true}, {5, false}, {30, true}},
+			wantAlarm: 2,
+		},
+		{
+			name:    "关闭时只记录姿态",
+			reports: []report{{0, false}, {0, false}, {90, false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, config.Config{Tilt: config.TiltConfig{
+				Enabled:         tt.enabled,
+				AngleThreshold:  15,
+				BaselineSamples: 2,
+				WarnType:        8,
+			}})
+			for i, r := range tt.reports {
+				rad := r.angle * math.Pi / 180
+				at := start.Add(time.Duration(i) * time.Minute)
+				if err := h.detectTilt("dev", services.NewTiltSample(math.Sin(rad), 0, math.Cos(rad), at)); err != nil {
+					t.Fatal(err)
+				}
+				if st, _ := h.state.Get("dev"); st.Tilted != r.wantTilted {
+					t.Fatalf("第 %d 次上报 %v° 后 tilted=%v，期望 %v", i, r.angle, st.Tilted, r.wantTilted)
+				}
+			}
+
+			if n := len(h.outbox.Pending()); n != tt.wantAlarm {
+				t.Fatalf("上报 %d 次报警，期望 %d 次", n, tt.wantAlarm)
+			}
+			samples, err := h.tilt.Samples("dev", start, start.Add(time.Hour), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(samples) != len(tt.reports) {
+				t.Fatalf("姿态历史 %d 条，期望 %d 条", len(samples), len(tt.reports))
+			}
+		})
+	}
+}
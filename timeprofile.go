@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chirpstack-httpserver/config"
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/integration"
+	"github.com/rs/zerolog/log"
+)
+
+// timeProfile 是设备时间同步应答所用的时区与时间格式
+type timeProfile struct {
+	Location *time.Location
+	Format   protocol.TimeFormat
+}
+
+// timeProfiles 按 ChirpStack 设备配置文件查找时间同步设置
+type timeProfiles struct {
+	fallback  timeProfile
+	byProfile map[string]timeProfile // 键为小写的设备配置文件 ID 或名称
+}
+
+// loadTimeProfiles 解析配置中的时区与时间格式，时区名称或格式无效时返回错误
+func loadTimeProfiles(cfg config.TimeSyncConfig) (timeProfiles, error) {
+	fallback, err := parseTimeProfile(cfg.Zone, cfg.Format)
+	if err != nil {
+		return timeProfiles{}, err
+	}
+	profiles := timeProfiles{fallback: fallback, byProfile: make(map[string]timeProfile, len(cfg.Profiles))}
+	for key, pc := range cfg.Profiles {
+		zone, format := pc.Zone, pc.Format
+		if zone == "" {
+			zone = cfg.Zone
+		}
+		if format == "" {
+			format = cfg.Format
+		}
+		p, err := parseTimeProfile(zone, format)
+		if err != nil {
+			return timeProfiles{}, fmt.Errorf("设备配置文件 %s: %w", key, err)
+		}
+		profiles.byProfile[strings.ToLower(key)] = p
+	}
+	return profiles, nil
+}
+
+func parseTimeProfile(zone, format string) (timeProfile, error) {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return timeProfile{}, fmt.Errorf("无效的时区 %q: %w", zone, err)
+	}
+	f, err := protocol.ParseTimeFormat(format)
+	if err != nil {
+		return timeProfile{}, err
+	}
+	return timeProfile{Location: loc, Format: f}, nil
+}
+
+// lookup 依次按设备配置文件 ID、名称查找，均未配置时返回默认设置
+func (p timeProfiles) lookup(profileID, profileName string) timeProfile {
+	for _, key := range []string{profileID, profileName} {
+		if tp, ok := p.byProfile[strings.ToLower(key)]; ok && key != "" {
+			return tp
+		}
+	}
+	return p.fallback
+}
+
+// timeProfileFor 按设备最近一次上报的设备配置文件返回其时间同步设置
+func (h *Handler) timeProfileFor(devEUI string) timeProfile {
+	st, _ := h.state.Get(devEUI)
+	return h.timeProfiles.lookup(st.DeviceProfileID, st.DeviceProfileName)
+}
+
+// learnDeviceProfile 记录事件 deviceInfo 中设备所属的设备配置文件，用于选择时间同步设置
+func (h *Handler) learnDeviceProfile(info *integration.DeviceInfo) {
+	devEUI, id, name := info.GetDevEui(), info.GetDeviceProfileId(), info.GetDeviceProfileName()
+	if id == "" && name == "" {
+		return
+	}
+	if st, ok := h.state.Get(devEUI); ok && st.DeviceProfileID == id && st.DeviceProfileName == name {
+		return
+	}
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.DeviceProfileID, st.DeviceProfileName = id, name
+	})
+	log.Info().Str("devEUI", devEUI).Str("deviceProfileId", id).Str("deviceProfileName", name).Msg("设备配置文件已更新")
+}
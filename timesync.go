@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"chirpstack-httpserver/protocol"
+	"chirpstack-httpserver/services"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// lorawanOverhead 是 PHYPayload 中除 FRMPayload 外的字节数：MHDR、不含 FOpts 的 FHDR、FPort 与 MIC
+const lorawanOverhead = 13
+
+// msPerDay 是一天的毫秒数
+const msPerDay = 24 * 60 * 60 * 1000
+
+// syncReference 返回网关收到上行的时刻及其来源：优先取网关 GPS 时间，其次网关、网络服务器的接收时间，
+// 都没有时退回本服务的当前时间
+func syncReference(uplink *UplinkEvent, useGPS bool) (time.Time, string) {
+	rxInfo := uplink.GetRxInfo()
+	if useGPS {
+		for _, rx := range rxInfo {
+			if d := rx.GetTimeSinceGpsEpoch(); d != nil {
+				return protocol.FromGPSTime(d.AsDuration()), services.SyncSourceGPS
+			}
+		}
+	}
+	for _, rx := range rxInfo {
+		if t := rx.GetGwTime(); t != nil {
+			return t.AsTime(), services.SyncSourceGateway
+		}
+	}
+	for _, rx := range rxInfo {
+		if t := rx.GetNsTime(); t != nil {
+			return t.AsTime(), services.SyncSourceNetwork
+		}
+	}
+	if t := uplink.GetTime(); t != nil {
+		return t.AsTime(), services.SyncSourceNetwork
+	}
+	return time.Now(), services.SyncSourceServer
+}
+
+// loraAirtime 按上行的 LoRa 调制参数计算 FRMPayload 长度为 payloadLen 的帧的空中时间，非 LoRa 调制时返回 0。
+// 下行假定 RX1 与上行使用相同的数据速率，且不带 CRC
+func loraAirtime(uplink *UplinkEvent, payloadLen int, crc bool) time.Duration {
+	lora := uplink.GetTxInfo().GetModulation().GetLora()
+	if lora == nil {
+		return 0
+	}
+	codeRate := int(lora.GetCodeRate())
+	if codeRate < int(gw.CodeRate_CR_4_5) || codeRate > int(gw.CodeRate_CR_4_8) {
+		codeRate = int(gw.CodeRate_CR_4_5)
+	}
+	return services.LoRaAirtime(lorawanOverhead+payloadLen, int(lora.GetSpreadingFactor()), int(lora.GetBandwidth()), codeRate, crc)
+}
+
+// compensateTimeSync 估算上行与下行的时延，返回应答负载应对应的时刻（设备在 RX1 窗口收完应答的时刻）。
+// 请求附带了设备时钟时，以上行开始发送的时刻为参考计算时钟漂移
+func (h *Handler) compensateTimeSync(uplink *UplinkEvent, req protocol.TimeSyncRequest, loc *time.Location) services.TimeSyncSample {
+	cfg := h.config.TimeSync
+	at, source := syncReference(uplink, cfg.UseGPS)
+	uplinkAirtime := loraAirtime(uplink, len(uplink.GetData()), true)
+	downlinkAirtime := loraAirtime(uplink, protocol.TimeSync{}.Spec().Size, false)
+
+	sample := services.TimeSyncSample{
+		Source:            source,
+		At:                at,
+		UplinkAirtimeMs:   services.Milliseconds(uplinkAirtime),
+		DownlinkAirtimeMs: services.Milliseconds(downlinkAirtime),
+		ProcessingDelayMs: services.Milliseconds(time.Since(at)),
+		Target:            at.Add(cfg.RxDelay + downlinkAirtime),
+	}
+	if req.DeviceClock != nil {
+		sent := protocol.NewTimeSync(at.Add(-uplinkAirtime).In(loc))
+		drift := clockDrift(*req.DeviceClock, sent.MsSinceMidnight)
+		sample.DriftMs = &drift
+	}
+	return sample
+}
+
+// clockDrift 计算设备时钟与参考时钟（均为自午夜起的毫秒数）之差，跨越午夜时取绝对值最小的差值
+func clockDrift(device, reference uint32) int64 {
+	drift := int64(device) - int64(reference)
+	switch {
+	case drift > msPerDay/2:
+		drift -= msPerDay
+	case drift <= -msPerDay/2:
+		drift += msPerDay
+	}
+	return drift
+}
+
+// recordTimeSync 保存时间同步记录并更新设备状态
+func (h *Handler) recordTimeSync(devEUI string, sample services.TimeSyncSample) {
+	h.state.Update(devEUI, func(st *services.DeviceState) {
+		st.LastTimeSync = &sample
+	})
+	if err := h.timeSync.Append(devEUI, sample); err != nil {
+		log.Error().Err(err).Str("devEUI", devEUI).Msg("保存时间同步记录失败")
+	}
+}
+
+// handleGetTimeSync 查询设备在时间范围内的时间同步记录，数量超过 time_sync.max_points 时需缩小范围
+func (h *Handler) handleGetTimeSync(c *gin.Context) {
+	r, ok := h.bindAccelerationQuery(c)
+	if !ok {
+		return
+	}
+	if r.interval != 0 {
+		respondValidationError(c, "interval is not supported for time sync history.")
+		return
+	}
+
+	samples, err := h.timeSync.Samples(r.devEUI, r.from, r.to, h.config.TimeSync.MaxPoints)
+	if errors.Is(err, services.ErrTooManySamples) {
+		respondValidationError(c, fmt.Sprintf("More than %d samples in range, narrow from/to.", h.config.TimeSync.MaxPoints))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("devEUI", r.devEUI).Msg("查询时间同步记录失败")
+		respondError(c, err, "Failed to query time sync samples.")
+		return
+	}
+	data := gin.H{"stakeNo": h.stakeNoOf(r.devEUI), "devEUI": r.devEUI, "from": r.from, "to": r.to, "samples": samples}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": data})
+}
+
+// ClockDrift 是设备最近一次测得的时钟漂移
+type ClockDrift struct {
+	StakeNo string    `json:"stakeNo"`
+	DevEUI  string    `json:"devEUI"`
+	DriftMs int64     `json:"driftMs"`
+	Source  string    `json:"source"`
+	At      time.Time `json:"at"`
+}
+
+// handleClockDriftReport 按漂移绝对值从大到小列出已登记设备最近一次测得的时钟漂移，可按多播组筛选；
+// 尚未测得漂移的设备计入 unmeasured
+func (h *Handler) handleClockDriftReport(c *gin.Context) {
+	drifts := make([]ClockDrift, 0)
+	unmeasured := 0
+	for _, d := range h.registry.List(services.DeviceFilter{Group: c.Query("group")}) {
+		state, _ := h.state.Get(d.DevEUI)
+		last := state.LastTimeSync
+		if last == nil || last.DriftMs == nil {
+			unmeasured++
+			continue
+		}
+		drifts = append(drifts, ClockDrift{StakeNo: d.StakeNo, DevEUI: d.DevEUI, DriftMs: *last.DriftMs, Source: last.Source, At: last.At})
+	}
+	sort.SliceStable(drifts, func(i, j int) bool {
+		return math.Abs(float64(drifts[i].DriftMs)) > math.Abs(float64(drifts[j].DriftMs))
+	})
+	c.JSON(http.StatusOK, gin.H{"code": 200, "message": "ok", "data": gin.H{"devices": drifts, "unmeasured": unmeasured}})
+}